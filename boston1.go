@@ -101,15 +101,16 @@ func fitModel(y []float64, features []int, data [][]float64) (mse, aic float64)
 	// Set the observed variable
 	r.SetObserved("mv")
 
-	// Prepare the feature data and add the selected features to the regression model
-	for _, idx := range features {
-		varName := strconv.Itoa(idx)
-		// Define the function to extract the feature and add it directly to the regression model
-		r.SetVar(idx, varName)
-		// Prepare the feature data
-		var x []float64
-		for _, row := range data {
-			x = append(x, row[idx])
+	// Add the selected features to the regression model
+	for i, idx := range features {
+		r.SetVar(i, strconv.Itoa(idx))
+	}
+
+	// Prepare one row of selected feature values per observation
+	for _, row := range data {
+		x := make([]float64, len(features))
+		for j, idx := range features {
+			x[j] = row[idx]
 		}
 		xs = append(xs, x)
 	}
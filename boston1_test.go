@@ -0,0 +1,29 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// TestFitModelTrainsOnObservations fits a response that is an exact linear
+// function of three columns. The fit must train on one data point per
+// observation, not one per feature, to recover it.
+func TestFitModelTrainsOnObservations(t *testing.T) {
+	const n = 30
+	y := make([]float64, n)
+	data := make([][]float64, n)
+	for i := range data {
+		x := float64(i)
+		data[i] = []float64{x, math.Sin(x), x * x / 10, math.Cos(2 * x), 0}
+		y[i] = 2 + 1.5*data[i][0] - 3*data[i][2] + 0.5*data[i][3]
+		data[i][4] = y[i]
+	}
+
+	mse, aic := fitModel(y, []int{0, 2, 3}, data)
+	if mse > 1e-18 {
+		t.Errorf("MSE of an exact fit = %g, want 0", mse)
+	}
+	if want := n*math.Log(mse) + 2*3; aic != want {
+		t.Errorf("AIC = %g, want %g", aic, want)
+	}
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// baseline is one model in a -baselines comparison.
+type baseline struct {
+	Name     string
+	Features []int
+	CVError  float64
+}
+
+// compareBaselines scores the selected features and four baselines on the
+// same cross-validation folds: the mean of the response, the best single
+// feature by the search criterion, all the features, and an additive
+// spline model of all the features, which shows how much nonlinearity the
+// linear models miss. The selected model comes first.
+func compareBaselines(y []float64, data [][]float64, numExplanatory int, selected []int, opts searchOptions) []baseline {
+	single := []int{0}
+	bestScore := math.Inf(1)
+	for j := 0; j < numExplanatory; j++ {
+		if _, score, err := fitModel(y, []int{j}, data, opts); err == nil && score < bestScore {
+			single, bestScore = []int{j}, score
+		}
+	}
+	full := make([]int, numExplanatory)
+	for j := range full {
+		full[j] = j
+	}
+
+	models := []baseline{
+		{Name: "selected", Features: selected},
+		{Name: "mean", Features: nil},
+		{Name: "best single", Features: single},
+		{Name: "full", Features: full},
+	}
+	for i := range models {
+		models[i].CVError = cvError(y, models[i].Features, data, opts)
+	}
+	models = append(models, baseline{Name: "additive", Features: full, CVError: additiveCVError(y, full, data, opts.folds)})
+	return models
+}
+
+// additiveCVError is cvError for an additive model of the features,
+// fitted by fitAdditive on each training fold.
+func additiveCVError(y []float64, features []int, data [][]float64, folds int) float64 {
+	var sse float64
+	for fold := 0; fold < folds; fold++ {
+		var trainY []float64
+		var trainData, testData [][]float64
+		var testY []float64
+		for i, row := range data {
+			if i%folds == fold {
+				testY = append(testY, y[i])
+				testData = append(testData, row)
+			} else {
+				trainY = append(trainY, y[i])
+				trainData = append(trainData, row)
+			}
+		}
+		if len(testData) == 0 {
+			continue
+		}
+		m := fitAdditive(trainY, features, trainData)
+		for i, row := range testData {
+			d := testY[i] - m.predict(row)
+			sse += d * d
+		}
+	}
+	return sse / float64(len(data))
+}
+
+// additiveModel is a penalized regression spline GAM: an intercept plus,
+// for each feature, a linear term and, if the feature takes enough
+// distinct values, the nonlinear terms of a natural cubic spline. The
+// spline terms are standardized with center and scale before fitting.
+type additiveModel struct {
+	features []int
+	knots    [][]float64 // nil for a feature fitted linearly
+	center   []float64
+	scale    []float64
+	coeffs   []float64 // intercept, linear terms, then spline terms
+}
+
+// expand returns the design row of row: 1, the features, then the
+// standardized spline terms in feature order.
+func (m *additiveModel) expand(row []float64) []float64 {
+	x := []float64{1}
+	x = append(x, featureRow(row, m.features)...)
+	var spline []float64
+	for j, idx := range m.features {
+		if m.knots[j] != nil {
+			spline = append(spline, naturalSplineBasis(row[idx], m.knots[j])...)
+		}
+	}
+	for i := range spline {
+		spline[i] = (spline[i] - m.center[i]) / m.scale[i]
+	}
+	return append(x, spline...)
+}
+
+func (m *additiveModel) predict(row []float64) float64 {
+	return dot(m.coeffs, m.expand(row))
+}
+
+// fitAdditive fits an additiveModel of y on the features of data. Each
+// feature with at least 10 distinct values gets a natural spline with 5
+// knots at quantiles of its values. The spline terms carry a ridge
+// penalty, smoothing the fit as a smoothing spline does; its weight is
+// chosen from a grid by generalized cross-validation, and the linear
+// terms are unpenalized.
+func fitAdditive(y []float64, features []int, data [][]float64) *additiveModel {
+	const knotCount = 5
+	m := &additiveModel{features: features, knots: make([][]float64, len(features))}
+	for j, idx := range features {
+		sorted := column(data, idx)
+		sort.Float64s(sorted)
+		distinct := 1
+		for i := 1; i < len(sorted); i++ {
+			if sorted[i] != sorted[i-1] {
+				distinct++
+			}
+		}
+		if distinct < 10 {
+			continue
+		}
+		var knots []float64
+		for k := 0; k < knotCount; k++ {
+			q := quantile(sorted, 0.05+0.9*float64(k)/float64(knotCount-1))
+			if len(knots) == 0 || q > knots[len(knots)-1] {
+				knots = append(knots, q)
+			}
+		}
+		if len(knots) >= 3 {
+			m.knots[j] = knots
+		}
+	}
+
+	// Standardize the spline terms so one penalty weight suits them all.
+	var splines [][]float64
+	for _, row := range data {
+		var spline []float64
+		for j, idx := range features {
+			if m.knots[j] != nil {
+				spline = append(spline, naturalSplineBasis(row[idx], m.knots[j])...)
+			}
+		}
+		splines = append(splines, spline)
+	}
+	nonlinear := 0
+	if len(splines) > 0 {
+		nonlinear = len(splines[0])
+	}
+	n := float64(len(data))
+	m.center = make([]float64, nonlinear)
+	m.scale = make([]float64, nonlinear)
+	for _, spline := range splines {
+		for i, v := range spline {
+			m.center[i] += v / n
+		}
+	}
+	for _, spline := range splines {
+		for i, v := range spline {
+			m.scale[i] += (v - m.center[i]) * (v - m.center[i]) / n
+		}
+	}
+	for i := range m.scale {
+		m.scale[i] = math.Sqrt(m.scale[i])
+		if m.scale[i] == 0 {
+			m.scale[i] = 1
+		}
+	}
+
+	p := 1 + len(features) + nonlinear
+	xtx := make([][]float64, p)
+	for i := range xtx {
+		xtx[i] = make([]float64, p)
+	}
+	xty := make([]float64, p)
+	for obs, row := range data {
+		x := m.expand(row)
+		for a := range x {
+			for b := range x {
+				xtx[a][b] += x[a] * x[b]
+			}
+			xty[a] += x[a] * y[obs]
+		}
+	}
+
+	bestGCV := math.Inf(1)
+	for e := -4; e <= 4; e++ {
+		lambda := math.Pow(10, float64(e))
+		a := make([][]float64, p)
+		for i := range a {
+			a[i] = append([]float64(nil), xtx[i]...)
+			if i > len(features) {
+				a[i][i] += lambda
+			}
+		}
+		l, err := cholesky(a)
+		if err != nil {
+			continue
+		}
+		coeffs := solveCholesky(l, xty)
+
+		// The effective degrees of freedom are the trace of the hat
+		// matrix, tr((X'X + lambda D)^-1 X'X).
+		var df float64
+		for c := 0; c < p; c++ {
+			col := make([]float64, p)
+			for r := range col {
+				col[r] = xtx[r][c]
+			}
+			df += solveCholesky(l, col)[c]
+		}
+		var rss float64
+		for obs, row := range data {
+			d := y[obs] - dot(coeffs, m.expand(row))
+			rss += d * d
+		}
+		if df >= n {
+			continue
+		}
+		if gcv := n * rss / ((n - df) * (n - df)); gcv < bestGCV {
+			bestGCV, m.coeffs = gcv, coeffs
+		}
+	}
+	if m.coeffs == nil {
+		m.coeffs = make([]float64, p)
+	}
+	return m
+}
+
+// compareTrees scores two black-box models of all the features on the
+// cvError folds, as references for how much accuracy the interpretable
+// linear subsets give up: a random forest and gradient boosted trees. Each
+// model's folds are fitted concurrently on workers goroutines, which the
+// forest's folds share out to grow their trees.
+func compareTrees(y []float64, data [][]float64, numExplanatory, folds int, seed int64, workers int) []baseline {
+	full := make([]int, numExplanatory)
+	for j := range full {
+		full[j] = j
+	}
+	treeWorkers := workers / folds
+	if treeWorkers < 1 {
+		treeWorkers = 1
+	}
+	fits := []struct {
+		name string
+		fit  func(y []float64, data [][]float64, rng *rand.Rand) func(row []float64) float64
+	}{
+		{"forest", func(y []float64, data [][]float64, rng *rand.Rand) func([]float64) float64 {
+			return fitForest(y, data, full, 200, treeWorkers, rng)
+		}},
+		{"boosting", func(y []float64, data [][]float64, rng *rand.Rand) func([]float64) float64 {
+			return fitBoosting(y, data, full, 300, 0.05, 3)
+		}},
+	}
+
+	var models []baseline
+	for _, f := range fits {
+		sse := make([]float64, folds)
+		forEachConcurrently(folds, workers, func(fold int) {
+			var trainY, testY []float64
+			var trainData, testData [][]float64
+			for i, row := range data {
+				if i%folds == fold {
+					testY = append(testY, y[i])
+					testData = append(testData, row)
+				} else {
+					trainY = append(trainY, y[i])
+					trainData = append(trainData, row)
+				}
+			}
+			if len(testData) == 0 {
+				return
+			}
+			predict := f.fit(trainY, trainData, rand.New(rand.NewSource(seed+int64(fold))))
+			for i, row := range testData {
+				d := testY[i] - predict(row)
+				sse[fold] += d * d
+			}
+		})
+		var total float64
+		for _, v := range sse {
+			total += v
+		}
+		models = append(models, baseline{Name: f.name, Features: full, CVError: total / float64(len(data))})
+	}
+	return models
+}
+
+// treeNode is a node of a regression tree; a leaf has no children.
+type treeNode struct {
+	feature     int
+	threshold   float64
+	value       float64
+	left, right *treeNode
+}
+
+func (t *treeNode) predict(row []float64) float64 {
+	for t.left != nil {
+		if row[t.feature] <= t.threshold {
+			t = t.left
+		} else {
+			t = t.right
+		}
+	}
+	return t.value
+}
+
+// growTree fits a regression tree to y over the rows of data listed in
+// rows, splitting to minimise squared error. Splits stop at maxDepth (no
+// limit if negative) or when a child would hold fewer than minLeaf rows.
+// With rng set, each split considers a random tries of the features, as
+// in a random forest.
+func growTree(y []float64, data [][]float64, rows, features []int, maxDepth, minLeaf, tries int, rng *rand.Rand) *treeNode {
+	var sum float64
+	for _, i := range rows {
+		sum += y[i]
+	}
+	node := &treeNode{value: sum / float64(len(rows))}
+	if maxDepth == 0 || len(rows) < 2*minLeaf {
+		return node
+	}
+
+	candidates := features
+	if rng != nil && tries < len(features) {
+		candidates = make([]int, tries)
+		for i, j := range rng.Perm(len(features))[:tries] {
+			candidates[i] = features[j]
+		}
+	}
+
+	// The best split maximises sum_left^2/n_left + sum_right^2/n_right,
+	// which is the same as minimising the children's squared error.
+	bestGain := sum * sum / float64(len(rows))
+	split := -1
+	var threshold float64
+	sorted := append([]int(nil), rows...)
+	for _, f := range candidates {
+		sort.Slice(sorted, func(a, b int) bool { return data[sorted[a]][f] < data[sorted[b]][f] })
+		var left float64
+		for k := 0; k+1 < len(sorted); k++ {
+			left += y[sorted[k]]
+			nl, nr := k+1, len(sorted)-k-1
+			if nl < minLeaf || nr < minLeaf || data[sorted[k]][f] == data[sorted[k+1]][f] {
+				continue
+			}
+			right := sum - left
+			if gain := left*left/float64(nl) + right*right/float64(nr); gain > bestGain+1e-12 {
+				bestGain, split = gain, f
+				threshold = (data[sorted[k]][f] + data[sorted[k+1]][f]) / 2
+			}
+		}
+	}
+	if split < 0 {
+		return node
+	}
+
+	var leftRows, rightRows []int
+	for _, i := range rows {
+		if data[i][split] <= threshold {
+			leftRows = append(leftRows, i)
+		} else {
+			rightRows = append(rightRows, i)
+		}
+	}
+	node.feature, node.threshold = split, threshold
+	node.left = growTree(y, data, leftRows, features, maxDepth-1, minLeaf, tries, rng)
+	node.right = growTree(y, data, rightRows, features, maxDepth-1, minLeaf, tries, rng)
+	return node
+}
+
+// fitForest fits a random forest of trees unpruned down to leaves of 5
+// rows, each on a bootstrap sample with a third of the features tried at
+// every split, and grows the trees concurrently on workers goroutines. It
+// returns the forest's prediction function, the mean over its trees.
+func fitForest(y []float64, data [][]float64, features []int, trees, workers int, rng *rand.Rand) func(row []float64) float64 {
+	tries := len(features) / 3
+	if tries < 1 {
+		tries = 1
+	}
+	forest := make([]*treeNode, trees)
+	seeds := make([]int64, trees)
+	for t := range seeds {
+		seeds[t] = rng.Int63()
+	}
+	forEachConcurrently(trees, workers, func(t int) {
+		r := rand.New(rand.NewSource(seeds[t]))
+		rows := make([]int, len(data))
+		for i := range rows {
+			rows[i] = r.Intn(len(data))
+		}
+		forest[t] = growTree(y, data, rows, features, -1, 5, tries, r)
+	})
+	return func(row []float64) float64 {
+		var sum float64
+		for _, t := range forest {
+			sum += t.predict(row)
+		}
+		return sum / float64(len(forest))
+	}
+}
+
+// fitBoosting fits gradient boosted regression trees for squared error:
+// stages trees of the given depth, each fitted to the residuals of those
+// before and added with the learning rate. It returns the prediction
+// function.
+func fitBoosting(y []float64, data [][]float64, features []int, stages int, rate float64, depth int) func(row []float64) float64 {
+	var base float64
+	for _, v := range y {
+		base += v
+	}
+	base /= float64(len(y))
+
+	rows := make([]int, len(data))
+	pred := make([]float64, len(data))
+	for i := range rows {
+		rows[i] = i
+		pred[i] = base
+	}
+	residual := make([]float64, len(y))
+	trees := make([]*treeNode, stages)
+	for s := range trees {
+		for i := range residual {
+			residual[i] = y[i] - pred[i]
+		}
+		trees[s] = growTree(residual, data, rows, features, depth, 5, len(features), nil)
+		for i, row := range data {
+			pred[i] += rate * trees[s].predict(row)
+		}
+	}
+	return func(row []float64) float64 {
+		p := base
+		for _, t := range trees {
+			p += rate * t.predict(row)
+		}
+		return p
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"unsafe"
+)
+
+// A dataset in -cache-dir is a float64 matrix file, laid out so it can be
+// memory-mapped and used in place: the magic bytes, the row and column
+// counts as little-endian uint64s, each column name as a uint32 length and
+// its bytes, zero padding to a multiple of 8 bytes, then the values row by
+// row as little-endian float64s.
+const matrixMagic = "BMATRIX1"
+
+// readDataCached is readData with a cache of parsed datasets in dir. The
+// cache key covers the path, size and modification time of the file, and
+// of a -join file, and every load option but the number of parse workers
+// and the download settings, so editing a file or changing an option
+// parses it again. Stdin and URLs are never cached, and an empty dir
+// disables the cache.
+func readDataCached(dir, path string, opts loadOptions) ([]string, [][]float64, error) {
+	if dir == "" || path == "-" || path == demoInput || strings.Contains(path, "://") {
+		return readData(path, opts)
+	}
+
+	cachePath, err := datasetCachePath(dir, path, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if names, data, err := loadDatasetCache(cachePath); err == nil {
+		return names, data, nil
+	}
+
+	names, data, err := readData(path, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := saveDatasetCache(cachePath, names, data); err != nil {
+		log.Printf("failed to cache %s: %v", path, err)
+	}
+	return names, data, nil
+}
+
+// datasetCachePath returns the cache file for path loaded with opts.
+func datasetCachePath(dir, path string, opts loadOptions) (string, error) {
+	var key bytes.Buffer
+	if err := writeFileKey(&key, path); err != nil {
+		return "", err
+	}
+	if opts.join != nil {
+		if err := writeFileKey(&key, opts.join.Path); err != nil {
+			return "", err
+		}
+	}
+	// Neither changes the data loaded.
+	opts.workers, opts.fetch = 0, fetchOptions{}
+	writeKey(&key, reflect.ValueOf(opts))
+
+	sum := sha256.Sum256(key.Bytes())
+	return filepath.Join(dir, hex.EncodeToString(sum[:16])+".f64"), nil
+}
+
+// writeFileKey writes the absolute path, size and modification time of a
+// file to a cache key.
+func writeFileKey(key *bytes.Buffer, path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %v", err)
+	}
+	fmt.Fprintf(key, "%q %d %d\n", abs, info.Size(), info.ModTime().UnixNano())
+	return nil
+}
+
+// writeKey writes v to a cache key in a canonical form: struct fields by
+// name, map entries in key order, pointers by what they point to and
+// interfaces by the type and value they hold, so equal options always give
+// the same key. A nil and an empty slice or map
+// write the same.
+func writeKey(key *bytes.Buffer, v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Struct:
+		key.WriteByte('{')
+		for i := 0; i < v.NumField(); i++ {
+			fmt.Fprintf(key, "%s:", v.Type().Field(i).Name)
+			writeKey(key, v.Field(i))
+			key.WriteByte(';')
+		}
+		key.WriteByte('}')
+	case reflect.Pointer:
+		if v.IsNil() {
+			key.WriteString("nil")
+			return
+		}
+		key.WriteByte('&')
+		writeKey(key, v.Elem())
+	case reflect.Interface:
+		if v.IsNil() {
+			key.WriteString("nil")
+			return
+		}
+		fmt.Fprintf(key, "%s", v.Elem().Type())
+		writeKey(key, v.Elem())
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+		key.WriteByte('[')
+		for _, k := range keys {
+			writeKey(key, k)
+			key.WriteByte('=')
+			writeKey(key, v.MapIndex(k))
+			key.WriteByte(',')
+		}
+		key.WriteByte(']')
+	case reflect.Slice, reflect.Array:
+		key.WriteByte('[')
+		for i := 0; i < v.Len(); i++ {
+			writeKey(key, v.Index(i))
+			key.WriteByte(',')
+		}
+		key.WriteByte(']')
+	default:
+		fmt.Fprintf(key, "%q", fmt.Sprint(v))
+	}
+}
+
+// loadDatasetCache memory-maps a matrix file written by saveDatasetCache.
+// On a little-endian machine the rows are slices of the mapping itself, so
+// the page cache decides which rows are resident and processes loading the
+// same file share its pages. The mapping is copy-on-write: code that
+// modifies rows in place gets private copies of the pages it touches, and
+// the file never changes.
+func loadDatasetCache(path string) ([]string, [][]float64, error) {
+	b, err := mapFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	names, values, err := decodeMatrix(b)
+	if err != nil {
+		unmapFile(b)
+		return nil, nil, fmt.Errorf("malformed dataset cache %s: %v", path, err)
+	}
+
+	cols := len(names)
+	data := make([][]float64, len(values)/cols)
+	for i := range data {
+		// The capacity stops an append to one row overwriting the next.
+		data[i] = values[i*cols : (i+1)*cols : (i+1)*cols]
+	}
+	return names, data, nil
+}
+
+// decodeMatrix splits a matrix file into its column names and values. The
+// values alias b when the machine is little-endian and they are aligned,
+// and are a decoded copy otherwise.
+func decodeMatrix(b []byte) ([]string, []float64, error) {
+	if len(b) < len(matrixMagic)+16 || string(b[:len(matrixMagic)]) != matrixMagic {
+		return nil, nil, errors.New("not a matrix file")
+	}
+	off := len(matrixMagic)
+	rows := binary.LittleEndian.Uint64(b[off:])
+	cols := binary.LittleEndian.Uint64(b[off+8:])
+	off += 16
+	if cols == 0 || cols > uint64(len(b)) {
+		return nil, nil, fmt.Errorf("bad column count %d", cols)
+	}
+
+	names := make([]string, cols)
+	for j := range names {
+		if off+4 > len(b) {
+			return nil, nil, errors.New("truncated column names")
+		}
+		n := int(binary.LittleEndian.Uint32(b[off:]))
+		off += 4
+		if n > len(b)-off {
+			return nil, nil, errors.New("truncated column names")
+		}
+		names[j] = string(b[off : off+n])
+		off += n
+	}
+	off = (off + 7) &^ 7
+	if off > len(b) {
+		return nil, nil, errors.New("truncated header")
+	}
+	if size := uint64(len(b) - off); rows > size/8/cols || size != rows*cols*8 {
+		return nil, nil, fmt.Errorf("%d bytes of values for %d rows of %d columns", size, rows, cols)
+	}
+
+	n := int(rows * cols)
+	if n == 0 {
+		return names, nil, nil
+	}
+	raw := b[off:]
+	if binary.NativeEndian.Uint16([]byte{1, 0}) == 1 && uintptr(unsafe.Pointer(&raw[0]))%8 == 0 {
+		return names, unsafe.Slice((*float64)(unsafe.Pointer(&raw[0])), n), nil
+	}
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = math.Float64frombits(binary.LittleEndian.Uint64(raw[8*i:]))
+	}
+	return names, values, nil
+}
+
+// saveDatasetCache writes the matrix file through a temporary file, so a
+// concurrent run never reads a partial cache.
+func saveDatasetCache(path string, names []string, data [][]float64) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	w.WriteString(matrixMagic)
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(len(data)))
+	w.Write(buf[:])
+	binary.LittleEndian.PutUint64(buf[:], uint64(len(names)))
+	w.Write(buf[:])
+	off := len(matrixMagic) + 16
+	for _, name := range names {
+		binary.LittleEndian.PutUint32(buf[:4], uint32(len(name)))
+		w.Write(buf[:4])
+		w.WriteString(name)
+		off += 4 + len(name)
+	}
+	for ; off%8 != 0; off++ {
+		w.WriteByte(0)
+	}
+	for _, row := range data {
+		for _, v := range row {
+			binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v))
+			w.Write(buf[:])
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
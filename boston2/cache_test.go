@@ -0,0 +1,179 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func writeTestFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestDatasetCachePathCoversLoadOptions changes each load option in turn
+// and checks it misses the cache, apart from those that don't change the
+// data loaded.
+func TestDatasetCachePathCoversLoadOptions(t *testing.T) {
+	dir := t.TempDir()
+	input := writeTestFile(t, "in.csv", "id,a,b,y\nx,1,2,3\n")
+	aux := writeTestFile(t, "aux.csv", "id,c\nx,4\n")
+
+	changes := map[string]func(o *loadOptions){
+		"format":      func(o *loadOptions) { o.format = "tsv" },
+		"columns":     func(o *loadOptions) { o.columns = []string{"a", "y"} },
+		"where":       func(o *loadOptions) { o.where = "a > 0" },
+		"derived":     func(o *loadOptions) { o.derived = map[string]string{"ab": "a * b"} },
+		"categorical": func(o *loadOptions) { o.categorical = []string{"id"} },
+		"dates":       func(o *loadOptions) { o.dates = map[string]dateSpec{"a": {Features: []string{"month"}}} },
+		"numbers":     func(o *loadOptions) { o.numbers = numberFormat{Decimal: ","} },
+		"nonFinite":   func(o *loadOptions) { o.nonFinite = "drop" },
+		"sheet":       func(o *loadOptions) { o.sheet = "Sheet2" },
+		"missing":     func(o *loadOptions) { o.missing = true },
+		"long":        func(o *loadOptions) { o.long = &longFormat{"id", "a", "b"} },
+		"join":        func(o *loadOptions) { o.join = &joinSpec{Path: aux, Key: "id", Type: "inner"} },
+	}
+	unchanged := map[string]func(o *loadOptions){
+		"workers": func(o *loadOptions) { o.workers = 8 },
+		"fetch":   func(o *loadOptions) { o.fetch = fetchOptions{retries: 5, retryDelay: time.Minute, partial: "warn"} },
+	}
+
+	typ := reflect.TypeOf(loadOptions{})
+	for i := 0; i < typ.NumField(); i++ {
+		name := typ.Field(i).Name
+		if changes[name] == nil && unchanged[name] == nil {
+			t.Errorf("loadOptions.%s has no test case; add one here", name)
+		}
+	}
+
+	base := loadOptions{format: "csv", nonFinite: "fail"}
+	basePath, err := datasetCachePath(dir, input, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := datasetCachePath(dir, input, base); again != basePath {
+		t.Fatalf("cache path of the same options changed: %s, then %s", basePath, again)
+	}
+
+	for name, change := range changes {
+		opts := base
+		change(&opts)
+		path, err := datasetCachePath(dir, input, opts)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if path == basePath {
+			t.Errorf("changing %s hits the cache", name)
+		}
+	}
+	for name, change := range unchanged {
+		opts := base
+		change(&opts)
+		if path, _ := datasetCachePath(dir, input, opts); path != basePath {
+			t.Errorf("changing %s misses the cache", name)
+		}
+	}
+}
+
+// TestDatasetCachePathCoversFiles checks that rewriting the input or the
+// -join file misses the cache.
+func TestDatasetCachePathCoversFiles(t *testing.T) {
+	dir := t.TempDir()
+	input := writeTestFile(t, "in.csv", "id,a,y\nx,1,3\n")
+	aux := writeTestFile(t, "aux.csv", "id,c\nx,4\n")
+	opts := loadOptions{join: &joinSpec{Path: aux, Key: "id", Type: "inner"}}
+
+	before, err := datasetCachePath(dir, input, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{input, aux} {
+		later := time.Now().Add(time.Hour)
+		if err := os.Chtimes(path, later, later); err != nil {
+			t.Fatal(err)
+		}
+		after, err := datasetCachePath(dir, input, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if after == before {
+			t.Errorf("touching %s hits the cache", filepath.Base(path))
+		}
+		before = after
+	}
+}
+
+// TestReadDataCached checks that a cached load returns what parsing the
+// file does.
+func TestReadDataCached(t *testing.T) {
+	dir := t.TempDir()
+	input := writeTestFile(t, "in.csv", "id,a,b,y\nx,1,2,3\nz,4,5,6.5\n")
+	opts := loadOptions{format: "auto"}
+
+	wantNames, wantData, err := readData(input, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for run := 0; run < 2; run++ {
+		names, data, err := readDataCached(dir, input, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(names, wantNames) || !reflect.DeepEqual(data, wantData) {
+			t.Errorf("run %d: got %v %v, want %v %v", run, names, data, wantNames, wantData)
+		}
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("cache holds %d files, want 1", len(entries))
+	}
+}
+
+// TestDatasetCacheMapping checks that a mapped cache round-trips, that
+// changing a loaded row leaves the file alone and that a damaged file is
+// rejected.
+func TestDatasetCacheMapping(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.f64")
+	names := []string{"a", "long column name", "y"}
+	data := [][]float64{{1, 2.5, -3}, {4, 5, 6e10}}
+	if err := saveDatasetCache(path, names, data); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gotNames, got, err := loadDatasetCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotNames, names) || !reflect.DeepEqual(got, data) {
+		t.Fatalf("got %v %v, want %v %v", gotNames, got, names, data)
+	}
+	got[0] = append(got[0], 7)
+	got[1][1] = 99
+	if got[1][0] != 4 {
+		t.Errorf("appending to a row changed the next: %v", got)
+	}
+	if now, _ := os.ReadFile(path); !reflect.DeepEqual(now, saved) {
+		t.Error("writing to a loaded row changed the cache file")
+	}
+
+	// A misaligned copy exercises the decoding path.
+	shifted := append([]byte{0}, saved...)[1:]
+	if _, values, err := decodeMatrix(shifted); err != nil || !reflect.DeepEqual(values, []float64{1, 2.5, -3, 4, 5, 6e10}) {
+		t.Errorf("decoding a misaligned copy: %v, %v", values, err)
+	}
+
+	for _, damaged := range [][]byte{saved[:len(saved)-1], saved[:20], append([]byte("BMATRIX2"), saved[8:]...)} {
+		if _, _, err := decodeMatrix(damaged); err == nil {
+			t.Errorf("decoding %d damaged bytes succeeded", len(damaged))
+		}
+	}
+}
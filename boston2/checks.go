@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// monotonicSpec is the direction, "increasing" or "decreasing", in which
+// predictions should move with a column, and the largest move the other
+// way, in units of the response, still accepted.
+type monotonicSpec struct {
+	Direction string  `json:"direction"`
+	Tolerance float64 `json:"tolerance,omitempty"`
+}
+
+// checkResult is the outcome of one sanity check on the final model.
+type checkResult struct {
+	Column string `json:"column"`
+	Check  string `json:"check"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail,omitempty"`
+}
+
+func (c checkResult) String() string {
+	status := "ok"
+	if !c.Passed {
+		status = "VIOLATED"
+	}
+	if c.Detail == "" {
+		return fmt.Sprintf("%s %s: %s", c.Column, c.Check, status)
+	}
+	return fmt.Sprintf("%s %s: %s, %s", c.Column, c.Check, status, c.Detail)
+}
+
+// checkMonotonic checks that the final model's predictions move in each
+// spec's direction as its column goes over the values observed in data.
+// A column's effect is the sum of the terms of the model's features
+// built from it: the column itself and its _bin and _ns columns. The
+// other features are held fixed, so for a single linear term this is the
+// sign of its coefficient, but a bin or spline effect can reverse over
+// part of the range; the check reports the largest such reversal.
+func checkMonotonic(names []string, data [][]float64, features []int, m *linearModel, specs map[string]monotonicSpec) ([]checkResult, error) {
+	response := len(names) - 1
+	var results []checkResult
+	for _, name := range sortedKeys(specs) {
+		spec := specs[name]
+		sign := 1.0
+		switch spec.Direction {
+		case "increasing":
+		case "decreasing":
+			sign = -1
+		default:
+			return nil, fmt.Errorf("monotonic check for %q: unknown direction %q", name, spec.Direction)
+		}
+		cols, err := selectColumns(names[:response], []string{name})
+		if err != nil {
+			return nil, fmt.Errorf("bad monotonic check: %v", err)
+		}
+		c := cols[0]
+
+		var terms []int
+		for pos, idx := range features {
+			if n := names[idx]; n == name || strings.HasPrefix(n, name+"_bin") || strings.HasPrefix(n, name+"_ns") {
+				terms = append(terms, pos)
+			}
+		}
+		res := checkResult{Column: name, Check: spec.Direction, Passed: true}
+		if len(terms) == 0 {
+			res.Detail = "not in the model"
+			results = append(results, res)
+			continue
+		}
+
+		type point struct{ x, effect float64 }
+		points := make([]point, len(data))
+		for i, row := range data {
+			var effect float64
+			for _, pos := range terms {
+				effect += m.Coeffs[pos] * row[features[pos]]
+			}
+			points[i] = point{row[c], sign * effect}
+		}
+		sort.Slice(points, func(i, j int) bool { return points[i].x < points[j].x })
+
+		// The largest reversal is the deepest fall below the highest
+		// effect seen at a smaller value of the column.
+		var worst, from, to float64
+		peak := points[0]
+		for _, p := range points[1:] {
+			if p.x > peak.x && peak.effect-p.effect > worst {
+				worst, from, to = peak.effect-p.effect, peak.x, p.x
+			}
+			if p.effect > peak.effect {
+				peak = p
+			}
+		}
+		// Rounding alone can't be a violation.
+		if worst > spec.Tolerance+1e-9*math.Abs(peak.effect) {
+			verb := "falls"
+			if sign < 0 {
+				verb = "rises"
+			}
+			res.Passed = false
+			res.Detail = fmt.Sprintf("predicted %s %s by %.4f from %s = %g to %g", names[response], verb, worst, name, from, to)
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// checkEffectSizes flags each feature of the final model that has a
+// minimum meaningful effect in minEffect and whose coefficient is
+// statistically significant, at the 5% level, yet smaller in magnitude
+// than that minimum. Features outside the model are ignored.
+func checkEffectSizes(names []string, y []float64, data [][]float64, features []int, m *linearModel, throughOrigin bool, minEffect map[string]float64) ([]checkResult, error) {
+	response := len(names) - 1
+	if _, err := selectColumns(names[:response], sortedKeys(minEffect)); err != nil {
+		return nil, fmt.Errorf("bad min_effect: %v", err)
+	}
+	se, err := standardErrors(y, data, features, m, throughOrigin)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute standard errors: %v", err)
+	}
+
+	var results []checkResult
+	for pos, idx := range features {
+		threshold, ok := minEffect[names[idx]]
+		if !ok {
+			continue
+		}
+		coeff := m.Coeffs[pos]
+		// A normal approximation to the t distribution is close enough
+		// for a flag with the sample sizes searched here.
+		p := math.Erfc(math.Abs(coeff/se[pos]) / math.Sqrt2)
+		res := checkResult{Column: names[idx], Check: fmt.Sprintf("effect >= %g", threshold), Passed: true}
+		switch {
+		case p >= 0.05:
+			res.Detail = fmt.Sprintf("coefficient %+.4f is not significant (p = %.3g)", coeff, p)
+		case math.Abs(coeff) < threshold:
+			res.Passed = false
+			res.Detail = fmt.Sprintf("coefficient %+.4f is significant (p = %.3g) but practically negligible", coeff, p)
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// standardErrors returns the standard error of each coefficient of m, a
+// least squares fit of y on features, from the diagonal of
+// sigma^2 (X'X)^-1 with sigma^2 estimated from the residuals.
+func standardErrors(y []float64, data [][]float64, features []int, m *linearModel, throughOrigin bool) ([]float64, error) {
+	p := len(features)
+	if !throughOrigin {
+		p++
+	}
+	if len(data) <= p {
+		return nil, fmt.Errorf("%d rows leave no residual degrees of freedom for %d parameters", len(data), p)
+	}
+	inv, err := inverseDiagonal(data, features, throughOrigin)
+	if err != nil {
+		return nil, err
+	}
+	var rss float64
+	buf := make([]float64, len(features))
+	for obs, row := range data {
+		yPred, err := m.Predict(featureRowInto(buf, row, features))
+		if err != nil {
+			return nil, err
+		}
+		rss += (y[obs] - yPred) * (y[obs] - yPred)
+	}
+	sigma2 := rss / float64(len(data)-p)
+
+	se := make([]float64, len(features))
+	for i := range se {
+		se[i] = math.Sqrt(sigma2 * inv[i])
+	}
+	return se, nil
+}
+
+// inverseDiagonal returns the diagonal entries of (X'X)^-1 that belong to
+// the features, where X is the design matrix of features: with a leading
+// column of ones unless the fit goes through the origin.
+func inverseDiagonal(data [][]float64, features []int, throughOrigin bool) ([]float64, error) {
+	offset := 1
+	if throughOrigin {
+		offset = 0
+	}
+	p := len(features) + offset
+	xtx := make([][]float64, p)
+	for i := range xtx {
+		xtx[i] = make([]float64, p)
+	}
+	x := make([]float64, p)
+	for _, row := range data {
+		if offset == 1 {
+			x[0] = 1
+		}
+		featureRowInto(x[offset:], row, features)
+		for i := range x {
+			for j := range x {
+				xtx[i][j] += x[i] * x[j]
+			}
+		}
+	}
+
+	inv := make([]float64, len(features))
+	unit := make([]float64, p)
+	for i := range inv {
+		// Column offset+i of the inverse, from which only the diagonal
+		// entry is kept.
+		clear(unit)
+		unit[offset+i] = 1
+		col, err := solveSymmetric(xtx, unit)
+		if err != nil {
+			return nil, err
+		}
+		inv[i] = col[offset+i]
+	}
+	return inv, nil
+}
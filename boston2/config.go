@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// runConfig is the JSON file given with -config.
+type runConfig struct {
+	// Derived defines extra explanatory columns computed while loading,
+	// e.g. {"rooms_per_tax": "rooms / tax"}. Expressions use the syntax of
+	// -where and refer to input columns, not to other derived columns.
+	Derived map[string]string `json:"derived"`
+	// Exclude lists groups of columns never to be used together, e.g.
+	// [["tax", "rad"]] skips every subset holding both tax and rad.
+	Exclude [][]string `json:"exclude"`
+	// GOGC and MemoryLimit tune the garbage collector like the GOGC and
+	// GOMEMLIMIT environment variables, e.g. {"gogc": 400,
+	// "memory_limit": "4GiB"}. Unset values leave the runtime defaults.
+	GOGC        *int   `json:"gogc,omitempty"`
+	MemoryLimit string `json:"memory_limit,omitempty"`
+	// Bins adds dummy columns for bins of continuous predictors, e.g.
+	// {"lstat": {"method": "quantile", "count": 4}}. See binFeatures.
+	Bins map[string]binSpec `json:"bins,omitempty"`
+	// Splines adds natural cubic spline basis columns for continuous
+	// predictors, e.g. {"lstat": {"knots": 4}}. See splineFeatures.
+	Splines map[string]splineSpec `json:"splines,omitempty"`
+	// TargetEncode loads categorical columns, such as the neighborhood,
+	// and replaces each level with a smoothed out-of-fold mean of the
+	// response, e.g. {"neighborhood": {"smoothing": 10}}. See targetEncode.
+	TargetEncode map[string]targetEncoding `json:"target_encode,omitempty"`
+	// Dates parses timestamp columns into calendar features, e.g.
+	// {"sold": {"layout": "2006-01-02", "features": ["month", "weekday"]}}.
+	// See dateSpec.
+	Dates map[string]dateSpec `json:"dates,omitempty"`
+	// Metadata describes columns for the reports, e.g. {"rooms":
+	// {"description": "average rooms per dwelling", "unit": "room"},
+	// "mv": {"unit": "$k"}}.
+	Metadata columnMetadata `json:"metadata,omitempty"`
+	// Numbers describes how numbers are written in the input, e.g.
+	// {"decimal": ",", "thousands": "."} for "1.234,5".
+	Numbers numberFormat `json:"numbers,omitempty"`
+	// Monotonic lists columns the final model's predictions should rise or
+	// fall with over their observed range, e.g. {"rooms": {"direction":
+	// "increasing"}}. See checkMonotonic.
+	Monotonic map[string]monotonicSpec `json:"monotonic,omitempty"`
+	// MinEffect is the smallest coefficient magnitude that matters in
+	// practice for each feature, e.g. {"crim": 0.05}. See checkEffectSizes.
+	MinEffect map[string]float64 `json:"min_effect,omitempty"`
+	// Protected lists attributes, such as chas, that no model may use but
+	// whose levels the final model is audited across for disparities.
+	// See printFairness.
+	Protected []string `json:"protected,omitempty"`
+	// Signs constrains coefficients of the lasso used by -screen lasso to
+	// be "non-negative" or "non-positive", e.g. {"rooms": "non-negative"}.
+	Signs map[string]string `json:"signs,omitempty"`
+	// Winsorize clips explanatory columns to percentiles of the training
+	// rows or to fixed bounds, e.g. {"crim": {"lower": 0.01, "upper":
+	// 0.99}, "tax": {"max": 700}}. See winsorize.
+	Winsorize map[string]winsorSpec `json:"winsorize,omitempty"`
+	// HashEncode loads categorical columns with too many levels to encode
+	// one by one and replaces each with a fixed number of hashed
+	// indicator columns, e.g. {"neighborhood": {"buckets": 8}}. See
+	// hashFeatures.
+	HashEncode map[string]hashSpec `json:"hash_encode,omitempty"`
+}
+
+// numberFormat is a locale's way of writing numbers. Decimal is the decimal
+// separator, "." by default, and Thousands the digit group separator, none
+// by default. Currency strips currency symbols such as $ and €, and Percent
+// reads "12.5%" as 0.125.
+type numberFormat struct {
+	Decimal   string `json:"decimal,omitempty"`
+	Thousands string `json:"thousands,omitempty"`
+	Currency  bool   `json:"currency,omitempty"`
+	Percent   bool   `json:"percent,omitempty"`
+}
+
+// parse reads v written in format f.
+func (f numberFormat) parse(v string) (float64, error) {
+	if f == (numberFormat{}) {
+		return strconv.ParseFloat(v, 64)
+	}
+
+	s := strings.TrimSpace(v)
+	if f.Currency {
+		s = strings.TrimSpace(strings.Map(func(r rune) rune {
+			if unicode.Is(unicode.Sc, r) {
+				return -1
+			}
+			return r
+		}, s))
+	}
+	scale := 1.0
+	if f.Percent && strings.HasSuffix(s, "%") {
+		s, scale = strings.TrimSpace(strings.TrimSuffix(s, "%")), 0.01
+	}
+	if f.Thousands != "" {
+		s = strings.ReplaceAll(s, f.Thousands, "")
+	}
+	if f.Decimal != "" && f.Decimal != "." {
+		if strings.Contains(s, ".") {
+			return 0, fmt.Errorf("invalid number %q", v)
+		}
+		s = strings.ReplaceAll(s, f.Decimal, ".")
+	}
+
+	x, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", v)
+	}
+	return x * scale, nil
+}
+
+// columnMeta is the description and unit of one column.
+type columnMeta struct {
+	Description string `json:"description,omitempty"`
+	Unit        string `json:"unit,omitempty"`
+}
+
+// columnMetadata maps column names to their descriptions and units.
+type columnMetadata map[string]columnMeta
+
+// annotate describes the coefficient of feature in a model of response,
+// e.g. "rooms: average rooms per dwelling; coefficient: +3.8000 $k per
+// room".
+func (md columnMetadata) annotate(feature, response string, coeff float64) string {
+	var b strings.Builder
+	b.WriteString(feature)
+	b.WriteString(": ")
+	if d := md[feature].Description; d != "" {
+		b.WriteString(d)
+		b.WriteString("; ")
+	}
+	fmt.Fprintf(&b, "coefficient: %+.4f", coeff)
+
+	yUnit, xUnit := md[response].Unit, md[feature].Unit
+	switch {
+	case yUnit != "" && xUnit != "":
+		fmt.Fprintf(&b, " %s per %s", yUnit, xUnit)
+	case yUnit != "":
+		fmt.Fprintf(&b, " %s per unit of %s", yUnit, feature)
+	case xUnit != "":
+		fmt.Fprintf(&b, " per %s", xUnit)
+	}
+	return b.String()
+}
+
+// dateSpec says how to read a timestamp column and which features to
+// derive from it. Layout is a Go time layout; if empty, RFC 3339,
+// 2006-01-02, 2006-01-02 15:04:05 and 01/02/2006 are tried in turn.
+// Features are any of "month" (1 to 12), "weekday" (0 for Sunday to 6) and
+// "days" (days since 1970-01-01 UTC, with a fraction for the time of day);
+// all three by default. They are loaded as columns named column_feature.
+type dateSpec struct {
+	Layout   string   `json:"layout,omitempty"`
+	Features []string `json:"features,omitempty"`
+}
+
+// targetEncoding configures the encoding of one categorical column.
+// Smoothing is the weight, in rows, of the overall mean blended into each
+// level's mean; it defaults to 10.
+type targetEncoding struct {
+	Smoothing *float64 `json:"smoothing,omitempty"`
+}
+
+// splineSpec places the knots of a natural cubic spline: at the given
+// values, or else at Knots evenly spaced quantiles of the column.
+type splineSpec struct {
+	Knots int       `json:"knots,omitempty"`
+	At    []float64 `json:"at,omitempty"`
+}
+
+// binSpec is how one column is discretized: method is "equal-width",
+// "quantile" or "supervised", and count the number of bins.
+type binSpec struct {
+	Method string `json:"method"`
+	Count  int    `json:"count"`
+}
+
+// loadConfig reads a runConfig; an empty path gives the zero config.
+func loadConfig(path string) (runConfig, error) {
+	var cfg runConfig
+	if path == "" {
+		return cfg, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to open config: %v", err)
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse config %s: %v", path, err)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// fitStats summarises a fitted model for a selection criterion.
+type fitStats struct {
+	N        int     // observations
+	Features int     // selected features
+	Coeffs   int     // estimated regression coefficients, intercept included
+	RSS      float64 // residual sum of squares
+	TSS      float64 // total sum of squares about the mean, or about zero through the origin
+	// LogDet is the log determinant of the errors' correlation matrix,
+	// zero when they are independent. With correlated errors RSS is the
+	// sum of squares of the decorrelated residuals.
+	LogDet float64
+}
+
+// totalSumOfSquares is the TSS of fitStats.
+func totalSumOfSquares(y []float64, throughOrigin bool) float64 {
+	var mean float64
+	if !throughOrigin {
+		for _, v := range y {
+			mean += v
+		}
+		mean /= float64(len(y))
+	}
+	var tss float64
+	for _, v := range y {
+		tss += (v - mean) * (v - mean)
+	}
+	return tss
+}
+
+// criterion scores a fitted model for selection; lower is better.
+type criterion interface {
+	Name() string
+	Score(s fitStats) float64
+}
+
+// gaussianAIC is the AIC of a linear model with Gaussian errors, counting
+// the regression coefficients and the error variance as parameters. It
+// matches the value R's AIC() reports for an lm fit.
+type gaussianAIC struct{}
+
+func (gaussianAIC) Name() string { return "AIC" }
+
+func (gaussianAIC) Score(s fitStats) float64 {
+	return -2*gaussianLogLikelihood(s) + 2*float64(s.Coeffs+1)
+}
+
+// aicc is AIC with the small-sample correction 2p(p+1)/(n-p-1).
+type aicc struct{}
+
+func (aicc) Name() string { return "AICc" }
+
+func (aicc) Score(s fitStats) float64 {
+	p := float64(s.Coeffs + 1)
+	n := float64(s.N)
+	if n-p-1 <= 0 {
+		return math.Inf(1)
+	}
+	return gaussianAIC{}.Score(s) + 2*p*(p+1)/(n-p-1)
+}
+
+// simpleAIC is the shortened n*log(RSS/n) + 2k form, which drops the
+// constant terms of the likelihood and counts only the selected features.
+// Rankings at a fixed n agree with gaussianAIC.
+type simpleAIC struct{}
+
+func (simpleAIC) Name() string { return "AIC (simple)" }
+
+func (simpleAIC) Score(s fitStats) float64 {
+	return float64(s.N)*math.Log(s.RSS/float64(s.N)) + s.LogDet + 2*float64(s.Features)
+}
+
+// gPrior is Bayesian model selection with the conjugate
+// normal-inverse-gamma prior of Zellner's g-prior: given sigma^2 the
+// coefficients are normal about zero with covariance g sigma^2 (X'X)^-1,
+// sigma^2 has the reference prior 1/sigma^2 and the intercept a flat one.
+// g = n gives the unit-information prior. The log marginal likelihood
+// then has a closed form in R^2. The score is -2 times it, relative to the
+// model with no features, so lower is better as for the AICs.
+type gPrior struct{}
+
+func (gPrior) Name() string { return "-2 log ML" }
+
+func (gPrior) Score(s fitStats) float64 {
+	// Degrees of freedom left after the intercept, if any.
+	n := float64(s.N - (s.Coeffs - s.Features))
+	g := float64(s.N)
+	p := float64(s.Features)
+	r2 := 1 - s.RSS/s.TSS
+	if n-p <= 0 || s.TSS == 0 {
+		return math.Inf(1)
+	}
+	return n*math.Log(1+g*(1-r2)) - (n-p)*math.Log(1+g)
+}
+
+// posterior summarises the posterior of one coefficient under gPrior.
+type posterior struct {
+	Mean, SD, Lo, Hi float64
+}
+
+// gPriorPosterior returns the posterior of each coefficient of the least
+// squares fit m of y on features under gPrior. Given the data the
+// coefficients are Student t with n-1 degrees of freedom about the OLS
+// estimates shrunk by g/(1+g); the 95% intervals use the normal quantile,
+// which is close for the sample sizes searched here.
+func gPriorPosterior(y []float64, data [][]float64, features []int, m *linearModel, throughOrigin bool) ([]posterior, error) {
+	inv, err := inverseDiagonal(data, features, throughOrigin)
+	if err != nil {
+		return nil, err
+	}
+	rss := meanSquaredError(m, y, features, data, false) * float64(len(data))
+	tss := totalSumOfSquares(y, throughOrigin)
+
+	nu := float64(len(data))
+	if !throughOrigin {
+		nu--
+	}
+	if nu <= 2 {
+		return nil, fmt.Errorf("%d rows are too few for a posterior summary", len(data))
+	}
+	g := float64(len(data))
+	shrink := g / (1 + g)
+	// The posterior scale of sigma^2: the TSS less the part of the fitted
+	// sum of squares that the shrunk coefficients explain.
+	scale := (tss - shrink*(tss-rss)) / nu
+
+	post := make([]posterior, len(features))
+	for i := range post {
+		mean := shrink * m.Coeffs[i]
+		t := math.Sqrt(shrink * scale * inv[i])
+		post[i] = posterior{Mean: mean, SD: t * math.Sqrt(nu/(nu-2)), Lo: mean - 1.96*t, Hi: mean + 1.96*t}
+	}
+	return post, nil
+}
+
+// gaussianLogLikelihood is the maximised log-likelihood of a linear model
+// with Gaussian errors, using the ML variance estimate RSS/n.
+func gaussianLogLikelihood(s fitStats) float64 {
+	n := float64(s.N)
+	return -n/2*(math.Log(2*math.Pi)+math.Log(s.RSS/n)+1) - s.LogDet/2
+}
+
+// criterionByName maps a -criterion value to its implementation.
+func criterionByName(name string) (criterion, error) {
+	switch name {
+	case "aic":
+		return gaussianAIC{}, nil
+	case "aicc":
+		return aicc{}, nil
+	case "aic-simple":
+		return simpleAIC{}, nil
+	case "bayes":
+		return gPrior{}, nil
+	}
+	return nil, fmt.Errorf("unknown criterion %q", name)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// mtcars loads R's mtcars dataset from testdata with the named columns as
+// features and mpg as the response.
+func mtcars(t testing.TB, columns ...string) ([]float64, [][]float64) {
+	t.Helper()
+	cols := append(append([]string(nil), columns...), "mpg")
+	_, data, err := readData("testdata/mtcars.csv", loadOptions{format: "csv", nonFinite: "fail", columns: cols})
+	if err != nil {
+		t.Fatal(err)
+	}
+	y := make([]float64, len(data))
+	for i, row := range data {
+		y[i] = row[len(row)-1]
+	}
+	return y, data
+}
+
+func allFeatures(k int) []int {
+	features := make([]int, k)
+	for i := range features {
+		features[i] = i
+	}
+	return features
+}
+
+// TestCriteriaMatchR checks the fitted coefficients and criteria of lm
+// fits of mtcars against reference values; see reference_test.go for where
+// they come from. Transcribed from R's published output: the coefficients,
+// which are what coef() prints; the AICs of the first two models, which
+// are what AIC() prints; and the extractAIC of the first and last models,
+// which is what step(lm(mpg ~ ., mtcars)) prints to two decimals. Derived
+// by hand: the AICs of the other two models, from the same formula applied
+// to the RSS of fits that reproduce R's coefficients; their extractAIC, as
+// n log(RSS/n) + 2 edf; and every AICc, as the AIC plus the correction
+// 2p(p+1)/(n-p-1) of MuMIn's AICc(). simpleAIC leaves the intercept out of
+// the penalty, so it is 2 less than extractAIC.
+func TestCriteriaMatchR(t *testing.T) {
+	tests := []struct {
+		formula    string
+		columns    []string
+		coeffs     []float64 // intercept first
+		coeffTol   float64
+		aic, aicc  float64
+		extractAIC float64
+		extractTol float64
+	}{
+		{"mpg ~ wt", []string{"wt"}, []float64{37.2851, -5.3445}, 1e-4, 166.0294, 166.8866, 73.2174, 1e-4},
+		{"mpg ~ wt + hp", []string{"wt", "hp"}, []float64{37.22727, -3.87783, -0.03177}, 1e-5, 156.6523, 158.1338, 63.8403, 1e-4},
+		{"mpg ~ wt + qsec + am", []string{"wt", "qsec", "am"}, []float64{9.6178, -3.9165, 1.2259, 2.9358}, 1e-4, 154.1194, 156.4271, 61.31, 0.005},
+		{
+			"mpg ~ .",
+			[]string{"cyl", "disp", "hp", "drat", "wt", "qsec", "vs", "am", "gear", "carb"},
+			[]float64{12.30337, -0.11144, 0.01334, -0.02148, 0.78711, -3.71530, 0.82104, 0.31776, 2.52023, 0.65541, -0.19942},
+			1e-5, 163.7098, 180.1309, 70.9, 0.05,
+		},
+	}
+	for _, tt := range tests {
+		y, data := mtcars(t, tt.columns...)
+		features := allFeatures(len(tt.columns))
+
+		m := trainModel(y, features, data, false)
+		got := append([]float64{m.Intercept}, m.Coeffs...)
+		if len(got) != len(tt.coeffs) {
+			t.Fatalf("%s: %d coefficients, want %d", tt.formula, len(got), len(tt.coeffs))
+		}
+		for i := range got {
+			if math.Abs(got[i]-tt.coeffs[i]) > tt.coeffTol {
+				t.Errorf("%s: coefficient %d = %.6f, want %v", tt.formula, i, got[i], tt.coeffs[i])
+			}
+		}
+
+		for _, c := range []struct {
+			crit criterion
+			want float64
+			tol  float64
+		}{
+			{gaussianAIC{}, tt.aic, 1e-4},
+			{aicc{}, tt.aicc, 1e-4},
+			{simpleAIC{}, tt.extractAIC - 2, tt.extractTol},
+		} {
+			_, score, err := fitModel(y, features, data, searchOptions{criterion: c.crit})
+			if err != nil {
+				t.Fatalf("%s: %v", tt.formula, err)
+			}
+			if math.Abs(score-c.want) > c.tol {
+				t.Errorf("%s: %s = %.4f, want %v", tt.formula, c.crit.Name(), score, c.want)
+			}
+		}
+	}
+}
+
+// TestCriteriaRankLikeR checks that each criterion prefers mpg ~ wt + qsec
+// + am, the model step() settles on, to the full model and to mpg ~ wt.
+func TestCriteriaRankLikeR(t *testing.T) {
+	models := [][]string{
+		{"wt"},
+		{"cyl", "disp", "hp", "drat", "wt", "qsec", "vs", "am", "gear", "carb"},
+	}
+	for _, crit := range []criterion{gaussianAIC{}, aicc{}, simpleAIC{}} {
+		opts := searchOptions{criterion: crit}
+		y, data := mtcars(t, "wt", "qsec", "am")
+		_, best, err := fitModel(y, allFeatures(3), data, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, columns := range models {
+			y, data := mtcars(t, columns...)
+			_, score, err := fitModel(y, allFeatures(len(columns)), data, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if score <= best {
+				t.Errorf("%s: %v scores %.4f, no worse than %.4f for wt, qsec, am", crit.Name(), columns, score, best)
+			}
+		}
+	}
+}
+
+func TestCriterionByName(t *testing.T) {
+	for name, want := range map[string]string{"aic": "AIC", "aicc": "AICc", "aic-simple": "AIC (simple)", "bayes": "-2 log ML"} {
+		c, err := criterionByName(name)
+		if err != nil || c.Name() != want {
+			t.Errorf("criterionByName(%q) = %v, %v; want %s", name, c, err, want)
+		}
+	}
+	if _, err := criterionByName("bic"); err == nil {
+		t.Error("criterionByName accepted an unknown criterion")
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"math"
+	"os"
+	"sort"
+)
+
+// describe implements the describe subcommand: per-column summary statistics
+// and the correlation matrix of every numeric column, optionally rendered as
+// a heatmap image.
+func describe(args []string) {
+	fs := flag.NewFlagSet("describe", flag.ExitOnError)
+	heatmap := fs.String("heatmap", "", "write the correlation matrix as a PNG heatmap to this file")
+	load := addLoadFlags(fs)
+	configPath := fs.String("config", "", "JSON config file")
+	fs.Parse(args)
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	loadOpts, err := load.options(cfg, "", false)
+	if err != nil {
+		log.Fatal(err)
+	}
+	names, data, err := readData(*load.input, loadOpts)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	columns := make([][]float64, len(names))
+	for j := range columns {
+		columns[j] = column(data, j)
+	}
+
+	fmt.Println("Summary Statistics:")
+	fmt.Printf("%-10s %6s %10s %10s %10s %10s %10s %10s %10s\n",
+		"Column", "Count", "Mean", "Std", "Min", "25%", "50%", "75%", "Max")
+	for j, x := range columns {
+		st := summarize(x)
+		fmt.Printf("%-10s %6d %10.4f %10.4f %10.4f %10.4f %10.4f %10.4f %10.4f\n",
+			names[j], len(x), st.Mean, st.Std, st.Min, st.Q1, st.Median, st.Q3, st.Max)
+	}
+
+	corr := correlationMatrix(columns)
+
+	fmt.Println("\nCorrelation Matrix:")
+	fmt.Printf("%-10s", "")
+	for _, name := range names {
+		fmt.Printf(" %8.8s", name)
+	}
+	fmt.Println()
+	for i, row := range corr {
+		fmt.Printf("%-10s", names[i])
+		for _, r := range row {
+			fmt.Printf(" %8.4f", r)
+		}
+		fmt.Println()
+	}
+
+	if *heatmap != "" {
+		if err := writeHeatmap(*heatmap, corr, -1, 1); err != nil {
+			log.Fatalf("failed to write heatmap: %v", err)
+		}
+		prov := newProvenance(fs, cfg, 0, names, data)
+		if err := writeJSONFile(*heatmap+".provenance.json", prov); err != nil {
+			log.Fatalf("failed to write provenance: %v", err)
+		}
+	}
+}
+
+// columnSummary holds the summary statistics of one column.
+type columnSummary struct {
+	Mean, Std                float64
+	Min, Q1, Median, Q3, Max float64
+}
+
+func summarize(x []float64) columnSummary {
+	sorted := append([]float64(nil), x...)
+	sort.Float64s(sorted)
+
+	var st columnSummary
+	for _, v := range x {
+		st.Mean += v
+	}
+	st.Mean /= float64(len(x))
+	for _, v := range x {
+		st.Std += (v - st.Mean) * (v - st.Mean)
+	}
+	if len(x) > 1 {
+		st.Std = math.Sqrt(st.Std / float64(len(x)-1))
+	}
+
+	st.Min, st.Max = sorted[0], sorted[len(sorted)-1]
+	st.Q1 = quantile(sorted, 0.25)
+	st.Median = quantile(sorted, 0.5)
+	st.Q3 = quantile(sorted, 0.75)
+	return st
+}
+
+// quantile linearly interpolates the q-th quantile of sorted values.
+func quantile(sorted []float64, q float64) float64 {
+	pos := q * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	hi := int(math.Ceil(pos))
+	return sorted[lo] + (pos-float64(lo))*(sorted[hi]-sorted[lo])
+}
+
+// correlationMatrix computes the Pearson correlation of every pair of
+// columns, one goroutine per row of the matrix.
+func correlationMatrix(columns [][]float64) [][]float64 {
+	corr := make([][]float64, len(columns))
+	done := make(chan struct{})
+
+	for i := range columns {
+		corr[i] = make([]float64, len(columns))
+		go func(i int) {
+			defer func() { done <- struct{}{} }()
+			for j := range columns {
+				if i == j {
+					corr[i][j] = 1
+				} else {
+					corr[i][j] = correlation(columns[i], columns[j])
+				}
+			}
+		}(i)
+	}
+
+	for range columns {
+		<-done
+	}
+	return corr
+}
+
+// writeHeatmap renders a matrix as a PNG, one square cell per entry, shading
+// from blue at lo through white to red at hi.
+func writeHeatmap(path string, m [][]float64, lo, hi float64) error {
+	const cell = 24
+
+	rows := len(m)
+	cols := 0
+	if rows > 0 {
+		cols = len(m[0])
+	}
+	img := image.NewRGBA(image.Rect(0, 0, cols*cell, rows*cell))
+	for i, row := range m {
+		for j, v := range row {
+			c := heatColor(v, lo, hi)
+			for py := i * cell; py < (i+1)*cell; py++ {
+				for px := j * cell; px < (j+1)*cell; px++ {
+					img.Set(px, py, c)
+				}
+			}
+		}
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func heatColor(v, lo, hi float64) color.RGBA {
+	mid := (lo + hi) / 2
+	if math.IsNaN(v) {
+		return color.RGBA{128, 128, 128, 255}
+	}
+	if v >= mid {
+		t := math.Min((v-mid)/(hi-mid), 1)
+		fade := uint8(255 * (1 - t))
+		return color.RGBA{255, fade, fade, 255}
+	}
+	t := math.Min((mid-v)/(mid-lo), 1)
+	fade := uint8(255 * (1 - t))
+	return color.RGBA{fade, fade, 255, 255}
+}
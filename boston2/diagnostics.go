@@ -0,0 +1,425 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// checkLeakage flags predictors that look like they were derived from the
+// response: Pearson or Spearman correlation with it beyond threshold, or
+// values that are constant among observations sharing a response value.
+// The warnings name the columns by names.
+func checkLeakage(names []string, y []float64, data [][]float64, numExplanatory int, threshold float64) []string {
+	var warnings []string
+	ranksY := ranks(y)
+	for j := 0; j < numExplanatory; j++ {
+		x := column(data, j)
+		if r := correlation(x, y); math.Abs(r) > threshold {
+			warnings = append(warnings, fmt.Sprintf("%s has correlation %.4f with the response; possible target leakage", names[j], r))
+			continue
+		}
+		if rho := correlation(ranks(x), ranksY); math.Abs(rho) > threshold {
+			warnings = append(warnings, fmt.Sprintf("%s has rank correlation %.4f with the response; possible target leakage", names[j], rho))
+			continue
+		}
+		if isFunctionOf(x, y) {
+			warnings = append(warnings, fmt.Sprintf("%s is constant within every group of equal response values; possible target leakage", names[j]))
+		}
+	}
+	return warnings
+}
+
+// isFunctionOf reports whether x takes a single value among observations with
+// the same y, requiring at least a tenth of the observations to share their
+// y value with another one so distinct responses don't pass trivially.
+func isFunctionOf(x, y []float64) bool {
+	seen := make(map[float64]float64)
+	count := make(map[float64]int)
+	for i, v := range y {
+		if prev, ok := seen[v]; ok && prev != x[i] {
+			return false
+		}
+		seen[v] = x[i]
+		count[v]++
+	}
+
+	var shared int
+	for _, c := range count {
+		if c > 1 {
+			shared += c
+		}
+	}
+	return shared*10 >= len(y) && len(count) > 1
+}
+
+// column copies column j out of data.
+func column(data [][]float64, j int) []float64 {
+	x := make([]float64, len(data))
+	for i, row := range data {
+		x[i] = row[j]
+	}
+	return x
+}
+
+// correlation returns the Pearson correlation of x and y, or 0 if either is
+// constant.
+func correlation(x, y []float64) float64 {
+	var meanX, meanY float64
+	for i := range x {
+		meanX += x[i]
+		meanY += y[i]
+	}
+	meanX /= float64(len(x))
+	meanY /= float64(len(y))
+
+	var sxy, sxx, syy float64
+	for i := range x {
+		dx, dy := x[i]-meanX, y[i]-meanY
+		sxy += dx * dy
+		sxx += dx * dx
+		syy += dy * dy
+	}
+	if sxx == 0 || syy == 0 {
+		return 0
+	}
+	return sxy / math.Sqrt(sxx*syy)
+}
+
+// ranks returns the rank of each value in x, averaging the ranks of ties.
+func ranks(x []float64) []float64 {
+	order := make([]int, len(x))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return x[order[a]] < x[order[b]] })
+
+	r := make([]float64, len(x))
+	for i := 0; i < len(order); {
+		j := i
+		for j+1 < len(order) && x[order[j+1]] == x[order[i]] {
+			j++
+		}
+		avg := float64(i+j)/2 + 1
+		for k := i; k <= j; k++ {
+			r[order[k]] = avg
+		}
+		i = j + 1
+	}
+	return r
+}
+
+// partialDependence evaluates the average prediction of r over data with
+// feature features[pos] replaced by each of points grid values spanning its
+// observed range.
+func partialDependence(r predictor, features []int, pos int, data [][]float64, points int) (grid, pd []float64) {
+	x := column(data, features[pos])
+	lo, hi := x[0], x[0]
+	for _, v := range x {
+		lo, hi = math.Min(lo, v), math.Max(hi, v)
+	}
+
+	grid = make([]float64, points)
+	pd = make([]float64, points)
+	for g := range grid {
+		grid[g] = lo + (hi-lo)*float64(g)/float64(points-1)
+		var sum float64
+		for _, row := range data {
+			xs := featureRow(row, features)
+			xs[pos] = grid[g]
+			yPred, _ := r.Predict(xs)
+			sum += yPred
+		}
+		pd[g] = sum / float64(len(data))
+	}
+	return grid, pd
+}
+
+// subgroupStats is a model's accuracy on the rows of one subgroup. Bias is
+// the mean of prediction minus actual, so it is positive where the model
+// over-predicts.
+type subgroupStats struct {
+	Label string
+	N     int
+	MSE   float64
+	Bias  float64
+}
+
+// subgroupPerformance splits rows by their value of x, or by quantile bins
+// of x when it has more than 10 distinct values, and returns the model's
+// MSE and bias on each part, in order of x.
+func subgroupPerformance(r predictor, features []int, rows [][]float64, response int, x []float64, bins int) []subgroupStats {
+	levels := append([]float64(nil), x...)
+	sort.Float64s(levels)
+	distinct := levels[:0]
+	for i, v := range levels {
+		if i == 0 || v != levels[i-1] {
+			distinct = append(distinct, v)
+		}
+	}
+
+	var cuts []float64
+	var labels []string
+	if len(distinct) <= 10 {
+		for i, v := range distinct {
+			if i > 0 {
+				cuts = append(cuts, v)
+			}
+			labels = append(labels, strconv.FormatFloat(v, 'g', -1, 64))
+		}
+	} else {
+		cuts = quantileCuts(x, bins)
+		edges := append(append([]float64{distinct[0]}, cuts...), distinct[len(distinct)-1])
+		for b := 0; b+1 < len(edges); b++ {
+			closing := ")"
+			if b+2 == len(edges) {
+				closing = "]"
+			}
+			labels = append(labels, fmt.Sprintf("[%.4g, %.4g%s", edges[b], edges[b+1], closing))
+		}
+	}
+
+	stats := make([]subgroupStats, len(labels))
+	for i := range stats {
+		stats[i].Label = labels[i]
+	}
+	buf := make([]float64, len(features))
+	for i, row := range rows {
+		yPred, err := r.Predict(featureRowInto(buf, row, features))
+		if err != nil {
+			yPred = math.NaN()
+		}
+		g := sort.Search(len(cuts), func(b int) bool { return cuts[b] > x[i] })
+		d := yPred - row[response]
+		stats[g].N++
+		stats[g].MSE += d * d
+		stats[g].Bias += d
+	}
+	for i := range stats {
+		if stats[i].N > 0 {
+			stats[i].MSE /= float64(stats[i].N)
+			stats[i].Bias /= float64(stats[i].N)
+		}
+	}
+	return stats
+}
+
+// protectedAttribute is the values of a protected column, held out of the
+// search, for the training and holdout rows.
+type protectedAttribute struct {
+	name           string
+	train, holdout []float64
+}
+
+// writePartialDependence writes one CSV per selected feature, and with plots
+// a matching PNG line chart, into dir.
+func writePartialDependence(dir string, plots bool, r predictor, features []int, names []string, data [][]float64) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	for pos, idx := range features {
+		grid, pd := partialDependence(r, features, pos, data, 50)
+		base := filepath.Join(dir, "pd_"+names[idx])
+
+		f, err := os.Create(base + ".csv")
+		if err != nil {
+			return err
+		}
+		w := csv.NewWriter(f)
+		w.Write([]string{names[idx], "partial_dependence"})
+		for g := range grid {
+			w.Write([]string{strconv.FormatFloat(grid[g], 'g', -1, 64), strconv.FormatFloat(pd[g], 'g', -1, 64)})
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+
+		if plots {
+			if err := writeLinePlot(base+".png", grid, pd); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// selectionFrequency returns, for each feature and each size result, the
+// fraction of that size's top models that include the feature.
+func selectionFrequency(numFeatures int, results []sizeResult) [][]float64 {
+	freq := make([][]float64, numFeatures)
+	for j := range freq {
+		freq[j] = make([]float64, len(results))
+	}
+	for s, sr := range results {
+		for _, m := range sr.Top {
+			for _, j := range m.Features {
+				freq[j][s]++
+			}
+		}
+		for j := range freq {
+			freq[j][s] /= float64(len(sr.Top))
+		}
+	}
+	return freq
+}
+
+// writeSelectionFrequency writes the feature-by-size selection frequency
+// matrix to dir as selection_frequency.csv, one row per feature and one
+// column per size, and as selection_frequency.png.
+func writeSelectionFrequency(dir string, names []string, results []sizeResult) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	freq := selectionFrequency(len(names), results)
+
+	f, err := os.Create(filepath.Join(dir, "selection_frequency.csv"))
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	header := []string{"feature"}
+	for _, sr := range results {
+		header = append(header, "size_"+strconv.Itoa(len(sr.Best.Features)))
+	}
+	w.Write(header)
+	for j, row := range freq {
+		record := []string{names[j]}
+		for _, v := range row {
+			record = append(record, strconv.FormatFloat(v, 'g', -1, 64))
+		}
+		w.Write(record)
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return writeHeatmap(filepath.Join(dir, "selection_frequency.png"), freq, 0, 1)
+}
+
+// writeLinePlot draws y against x as a simple PNG line chart with axes.
+func writeLinePlot(path string, x, y []float64) error {
+	const width, height, margin = 400, 300, 30
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for py := 0; py < height; py++ {
+		for px := 0; px < width; px++ {
+			img.Set(px, py, color.White)
+		}
+	}
+
+	axis := color.RGBA{0, 0, 0, 255}
+	drawLine(img, margin, height-margin, width-margin, height-margin, axis)
+	drawLine(img, margin, margin, margin, height-margin, axis)
+
+	xMin, xMax := x[0], x[len(x)-1]
+	yMin, yMax := y[0], y[0]
+	for _, v := range y {
+		yMin, yMax = math.Min(yMin, v), math.Max(yMax, v)
+	}
+	if xMax == xMin {
+		xMax = xMin + 1
+	}
+	if yMax == yMin {
+		yMin, yMax = yMin-1, yMax+1
+	}
+	toPixel := func(i int) (int, int) {
+		px := margin + int(float64(width-2*margin)*(x[i]-xMin)/(xMax-xMin))
+		py := height - margin - int(float64(height-2*margin)*(y[i]-yMin)/(yMax-yMin))
+		return px, py
+	}
+
+	line := color.RGBA{31, 119, 180, 255}
+	for i := 1; i < len(x); i++ {
+		x0, y0 := toPixel(i - 1)
+		x1, y1 := toPixel(i)
+		drawLine(img, x0, y0, x1, y1, line)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeFrontPlot draws the lowest CV error on the Pareto front at each
+// model size as a PNG line chart, size on the x axis.
+func writeFrontPlot(path string, front []frontierModel) error {
+	lowest := make(map[int]float64)
+	for _, m := range front {
+		if cv, ok := lowest[len(m.Features)]; !ok || m.CVError < cv {
+			lowest[len(m.Features)] = m.CVError
+		}
+	}
+	if len(lowest) == 0 {
+		return fmt.Errorf("the Pareto front is empty")
+	}
+	sizes := make([]int, 0, len(lowest))
+	for size := range lowest {
+		sizes = append(sizes, size)
+	}
+	sort.Ints(sizes)
+	x := make([]float64, len(sizes))
+	y := make([]float64, len(sizes))
+	for i, size := range sizes {
+		x[i], y[i] = float64(size), lowest[size]
+	}
+	return writeLinePlot(path, x, y)
+}
+
+// drawLine draws a line segment with Bresenham's algorithm.
+func drawLine(img *image.RGBA, x0, y0, x1, y1 int, c color.Color) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	e := dx + dy
+	for {
+		img.Set(x0, y0, c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * e
+		if e2 >= dy {
+			e += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			e += dx
+			y0 += sy
+		}
+	}
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
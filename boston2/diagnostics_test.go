@@ -0,0 +1,58 @@
+package main
+
+import (
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestCheckLeakageNamesColumns checks that each kind of leakage warning
+// names the column it is about.
+func TestCheckLeakageNamesColumns(t *testing.T) {
+	names := []string{"rooms", "copy", "exp", "band", "mv"}
+	y := []float64{1, 1, 2, 2, 3, 3, 4, 4, -5, -5}
+	data := make([][]float64, len(y))
+	for i, v := range y {
+		// band is constant within equal responses but neither linear nor
+		// monotonic in them.
+		band := []float64{0, 1, 0, 1, 0}[int(math.Abs(v))-1]
+		data[i] = []float64{float64(i % 3), 2 * v, math.Exp(2 * v), band, v}
+	}
+	warnings := checkLeakage(names, y, data, 4, 0.95)
+	want := []string{"copy has correlation", "exp has rank correlation", "band is constant"}
+	if len(warnings) != len(want) {
+		t.Fatalf("got warnings %q, want ones starting %q", warnings, want)
+	}
+	for i, w := range warnings {
+		if !strings.HasPrefix(w, want[i]) {
+			t.Errorf("warning %d is %q, want it to start %q", i, w, want[i])
+		}
+	}
+}
+
+func TestWriteFrontPlot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "front.png")
+	front := []frontierModel{
+		{Features: []int{0, 1, 2, 3}, CVError: 12, Score: 5},
+		{Features: []int{0, 1, 2, 4}, CVError: 11, Score: 6},
+		{Features: []int{0, 1, 2, 3, 4}, CVError: 10, Score: 7},
+	}
+	if err := writeFrontPlot(path, front); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := png.Decode(f); err != nil {
+		t.Errorf("plot is not a PNG: %v", err)
+	}
+
+	if err := writeFrontPlot(path, nil); err == nil {
+		t.Error("plotting an empty front succeeded")
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// modelAverage is the result of multi-model inference over a leaderboard.
+type modelAverage struct {
+	Models  []result
+	Weights []float64 // Akaike weight of each model
+	// Features lists every feature in any model, and Model holds their
+	// weighted average coefficients, counting a feature absent from a
+	// model as a zero coefficient there.
+	Features []int
+	Model    *linearModel
+	// Importance is the summed weight of the models containing each feature.
+	Importance []float64
+}
+
+// averageModels computes the Akaike weights exp(-delta/2), normalised, of
+// the leaderboard models and averages their refitted coefficients.
+func averageModels(y []float64, data [][]float64, models []result, train fitter) modelAverage {
+	avg := modelAverage{Models: models, Weights: make([]float64, len(models))}
+	if len(models) == 0 {
+		return avg
+	}
+
+	var total float64
+	for i, m := range models {
+		avg.Weights[i] = math.Exp(-(m.Score - models[0].Score) / 2)
+		total += avg.Weights[i]
+	}
+	for i := range avg.Weights {
+		avg.Weights[i] /= total
+	}
+
+	position := make(map[int]int)
+	for _, m := range models {
+		for _, idx := range m.Features {
+			if _, ok := position[idx]; !ok {
+				position[idx] = len(avg.Features)
+				avg.Features = append(avg.Features, idx)
+			}
+		}
+	}
+	sort.Ints(avg.Features)
+	for i, idx := range avg.Features {
+		position[idx] = i
+	}
+
+	avg.Model = &linearModel{Coeffs: make([]float64, len(avg.Features))}
+	avg.Importance = make([]float64, len(avg.Features))
+	for i, m := range models {
+		fit := train(y, m.Features, data)
+		if fit.Coeffs == nil {
+			continue
+		}
+		w := avg.Weights[i]
+		avg.Model.Intercept += w * fit.Intercept
+		for j, idx := range m.Features {
+			avg.Model.Coeffs[position[idx]] += w * fit.Coeffs[j]
+			avg.Importance[position[idx]] += w
+		}
+	}
+	return avg
+}
+
+// ensemble blends the predictions of several fitted models.
+type ensemble struct {
+	features [][]int
+	models   []*linearModel
+	weights  []float64
+}
+
+// newEnsemble refits each model on the training data, weighting members
+// equally or, with "criterion", by their Akaike weights.
+func newEnsemble(y []float64, data [][]float64, members []result, weighting string, train fitter) *ensemble {
+	e := &ensemble{}
+	if weighting == "criterion" {
+		e.weights = averageModels(y, data, members, train).Weights
+	}
+	for _, m := range members {
+		e.features = append(e.features, m.Features)
+		e.models = append(e.models, train(y, m.Features, data))
+		if weighting == "uniform" {
+			e.weights = append(e.weights, 1/float64(len(members)))
+		}
+	}
+	return e
+}
+
+// predict returns the weighted average of the members' predictions for an
+// observation.
+func (e *ensemble) predict(row []float64) float64 {
+	var yPred float64
+	for i, m := range e.models {
+		p, _ := m.Predict(featureRow(row, e.features[i]))
+		yPred += e.weights[i] * p
+	}
+	return yPred
+}
+
+func (e *ensemble) meanSquaredError(y []float64, data [][]float64) float64 {
+	var rss kahanSum
+	for i, row := range data {
+		rss.Add(math.Pow(y[i]-e.predict(row), 2))
+	}
+	return rss.sum / float64(len(data))
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+)
+
+// rowExpr is an expression over the columns of an input record, written in
+// Go syntax: numbers, double-quoted strings, column names, arithmetic,
+// comparisons, !, && and ||.
+type rowExpr struct {
+	node     ast.Expr
+	position map[string]int
+}
+
+// compileExpr parses src and checks that every identifier names a column.
+func compileExpr(src string, header []string) (*rowExpr, error) {
+	node, err := parser.ParseExpr(src)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &rowExpr{node: node, position: make(map[string]int, len(header))}
+	for i, name := range header {
+		e.position[name] = i
+	}
+
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Ident:
+			if _, ok := e.position[n.Name]; !ok && n.Name != "true" && n.Name != "false" && err == nil {
+				err = fmt.Errorf("unknown column %q", n.Name)
+			}
+		case *ast.BinaryExpr, *ast.UnaryExpr, *ast.ParenExpr, *ast.BasicLit:
+		case nil:
+		default:
+			if err == nil {
+				err = fmt.Errorf("unsupported expression %T", n)
+			}
+		}
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// match evaluates a boolean expression for record.
+func (e *rowExpr) match(record []string) (bool, error) {
+	v, err := e.eval(e.node, record)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expression is not a condition")
+	}
+	return b, nil
+}
+
+// value evaluates a numeric expression for record.
+func (e *rowExpr) value(record []string) (float64, error) {
+	v, err := e.eval(e.node, record)
+	if err != nil {
+		return 0, err
+	}
+	f, ok := v.(float64)
+	if !ok {
+		return 0, fmt.Errorf("expression is not a number")
+	}
+	return f, nil
+}
+
+// eval evaluates node for record. Column values that parse as numbers are
+// float64, others string; conditions are bool.
+func (e *rowExpr) eval(node ast.Expr, record []string) (any, error) {
+	switch n := node.(type) {
+	case *ast.ParenExpr:
+		return e.eval(n.X, record)
+	case *ast.BasicLit:
+		if n.Kind == token.STRING {
+			return strconv.Unquote(n.Value)
+		}
+		return strconv.ParseFloat(n.Value, 64)
+	case *ast.Ident:
+		switch n.Name {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+		field := record[e.position[n.Name]]
+		if v, err := strconv.ParseFloat(field, 64); err == nil {
+			return v, nil
+		}
+		return field, nil
+	case *ast.UnaryExpr:
+		x, err := e.eval(n.X, record)
+		if err != nil {
+			return nil, err
+		}
+		switch x := x.(type) {
+		case bool:
+			if n.Op == token.NOT {
+				return !x, nil
+			}
+		case float64:
+			switch n.Op {
+			case token.SUB:
+				return -x, nil
+			case token.ADD:
+				return x, nil
+			}
+		}
+		return nil, fmt.Errorf("invalid operand for %s", n.Op)
+	case *ast.BinaryExpr:
+		x, err := e.eval(n.X, record)
+		if err != nil {
+			return nil, err
+		}
+
+		// Short-circuit the logical operators.
+		if n.Op == token.LAND || n.Op == token.LOR {
+			xb, ok := x.(bool)
+			if !ok {
+				return nil, fmt.Errorf("%s needs conditions", n.Op)
+			}
+			if xb == (n.Op == token.LOR) {
+				return xb, nil
+			}
+			y, err := e.eval(n.Y, record)
+			if err != nil {
+				return nil, err
+			}
+			yb, ok := y.(bool)
+			if !ok {
+				return nil, fmt.Errorf("%s needs conditions", n.Op)
+			}
+			return yb, nil
+		}
+
+		y, err := e.eval(n.Y, record)
+		if err != nil {
+			return nil, err
+		}
+		return binaryOp(n.Op, x, y)
+	}
+	return nil, fmt.Errorf("unsupported expression %T", node)
+}
+
+// binaryOp applies an arithmetic or comparison operator to two numbers, or a
+// comparison to two strings.
+func binaryOp(op token.Token, x, y any) (any, error) {
+	if xf, ok := x.(float64); ok {
+		if yf, ok := y.(float64); ok {
+			switch op {
+			case token.ADD:
+				return xf + yf, nil
+			case token.SUB:
+				return xf - yf, nil
+			case token.MUL:
+				return xf * yf, nil
+			case token.QUO:
+				return xf / yf, nil
+			case token.EQL:
+				return xf == yf, nil
+			case token.NEQ:
+				return xf != yf, nil
+			case token.LSS:
+				return xf < yf, nil
+			case token.LEQ:
+				return xf <= yf, nil
+			case token.GTR:
+				return xf > yf, nil
+			case token.GEQ:
+				return xf >= yf, nil
+			}
+		}
+	}
+	if xs, ok := x.(string); ok {
+		if ys, ok := y.(string); ok {
+			switch op {
+			case token.EQL:
+				return xs == ys, nil
+			case token.NEQ:
+				return xs != ys, nil
+			case token.LSS:
+				return xs < ys, nil
+			case token.LEQ:
+				return xs <= ys, nil
+			case token.GTR:
+				return xs > ys, nil
+			case token.GEQ:
+				return xs >= ys, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("invalid operands for %s: %v and %v", op, x, y)
+}
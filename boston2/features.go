@@ -0,0 +1,611 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"sort"
+)
+
+// derivedFeature is a block of explanatory columns computed from the
+// values of a row, fitted on the training rows only so the holdout stays
+// unseen.
+type derivedFeature struct {
+	Names  []string
+	Values func(row []float64) []float64
+}
+
+// addFeatures appends the columns of features to every row of data and
+// holdout, after the existing explanatory columns and before the response.
+func addFeatures(names []string, data, holdout [][]float64, features []derivedFeature) ([]string, [][]float64, [][]float64) {
+	response := len(names) - 1
+	expand := func(rows [][]float64) [][]float64 {
+		expanded := make([][]float64, len(rows))
+		for i, row := range rows {
+			out := append([]float64(nil), row[:response]...)
+			for _, f := range features {
+				out = append(out, f.Values(row)...)
+			}
+			expanded[i] = append(out, row[response])
+		}
+		return expanded
+	}
+
+	newNames := append([]string(nil), names[:response]...)
+	for _, f := range features {
+		newNames = append(newNames, f.Names...)
+	}
+	newNames = append(newNames, names[response])
+	return newNames, expand(data), expand(holdout)
+}
+
+// pruneDecision records that one of a pair of highly correlated columns
+// was dropped in favour of the other.
+type pruneDecision struct {
+	Dropped     string  `json:"dropped"`
+	Kept        string  `json:"kept"`
+	Correlation float64 `json:"correlation"`
+}
+
+func (d pruneDecision) String() string {
+	return fmt.Sprintf("Pruned %s: correlation %.3f with %s", d.Dropped, d.Correlation, d.Kept)
+}
+
+// pruneCorrelated drops one column of each pair of explanatory columns,
+// other than the one named skip, whose absolute correlation exceeds
+// threshold. Pairs are taken from the most correlated down, skipping those
+// with a column already dropped. The keep rule "target" keeps the column
+// more correlated with the response, and "first" the one that comes first.
+// It returns the dropped columns and the decisions.
+func pruneCorrelated(names []string, data [][]float64, threshold float64, keep, skip string) ([]int, []pruneDecision) {
+	response := len(names) - 1
+	var cols []int
+	for c := 0; c < response; c++ {
+		if names[c] != skip {
+			cols = append(cols, c)
+		}
+	}
+	columns := make([][]float64, len(cols))
+	for i, c := range cols {
+		columns[i] = column(data, c)
+	}
+	corr := correlationMatrix(columns)
+	y := column(data, response)
+
+	type pair struct {
+		a, b int
+		r    float64
+	}
+	var pairs []pair
+	for i := range cols {
+		for j := i + 1; j < len(cols); j++ {
+			// NaN, from a constant column, never exceeds the threshold.
+			if r := corr[i][j]; math.Abs(r) > threshold {
+				pairs = append(pairs, pair{i, j, r})
+			}
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool { return math.Abs(pairs[i].r) > math.Abs(pairs[j].r) })
+
+	dropped := make(map[int]bool)
+	var drop []int
+	var decisions []pruneDecision
+	for _, p := range pairs {
+		if dropped[p.a] || dropped[p.b] {
+			continue
+		}
+		kept, lost := p.a, p.b
+		if keep == "target" && math.Abs(correlation(columns[p.b], y)) > math.Abs(correlation(columns[p.a], y)) {
+			kept, lost = p.b, p.a
+		}
+		dropped[lost] = true
+		drop = append(drop, cols[lost])
+		decisions = append(decisions, pruneDecision{Dropped: names[cols[lost]], Kept: names[cols[kept]], Correlation: p.r})
+	}
+	return drop, decisions
+}
+
+// nearConstantColumns returns the explanatory columns of data, other than
+// the one named skip, whose variance is below minVariance or whose most
+// common value holds more than maxDominant of the rows, with the reason
+// for each. Such columns carry little information and make fits
+// ill-conditioned.
+func nearConstantColumns(names []string, data [][]float64, minVariance, maxDominant float64, skip string) ([]int, []string) {
+	var cols []int
+	var reasons []string
+	for c := 0; c < len(names)-1; c++ {
+		if names[c] == skip {
+			continue
+		}
+		x := column(data, c)
+		var mean float64
+		for _, v := range x {
+			mean += v
+		}
+		mean /= float64(len(x))
+		var variance float64
+		for _, v := range x {
+			variance += (v - mean) * (v - mean)
+		}
+		variance /= float64(len(x))
+
+		counts := make(map[float64]int)
+		dominant, top := 0.0, 0
+		for _, v := range x {
+			counts[v]++
+			if counts[v] > top {
+				dominant, top = v, counts[v]
+			}
+		}
+		share := float64(top) / float64(len(x))
+
+		switch {
+		case variance < minVariance:
+			cols = append(cols, c)
+			reasons = append(reasons, fmt.Sprintf("variance %.4g is below %g", variance, minVariance))
+		case share > maxDominant:
+			cols = append(cols, c)
+			reasons = append(reasons, fmt.Sprintf("%.1f%% of rows are %g", 100*share, dominant))
+		}
+	}
+	return cols, reasons
+}
+
+// winsorSpec clips a column. Lower and Upper are quantiles, as fractions,
+// of its training values to winsorize at; Min and Max are fixed bounds,
+// which take the place of the quantile on their side. An unset side is
+// not clipped.
+type winsorSpec struct {
+	Lower *float64 `json:"lower,omitempty"`
+	Upper *float64 `json:"upper,omitempty"`
+	Min   *float64 `json:"min,omitempty"`
+	Max   *float64 `json:"max,omitempty"`
+}
+
+// clipBounds are the bounds a column was clipped to and how many training
+// rows were changed. An unclipped side is infinite.
+type clipBounds struct {
+	Column  string    `json:"column"`
+	Lower   jsonFloat `json:"lower"`
+	Upper   jsonFloat `json:"upper"`
+	Clipped int       `json:"clipped"`
+}
+
+func (c clipBounds) String() string {
+	return fmt.Sprintf("Clipped %s to [%.6g, %.6g]: %d training rows changed", c.Column, float64(c.Lower), float64(c.Upper), c.Clipped)
+}
+
+// winsorize clips, in place, each explanatory column in specs to bounds
+// fitted on the rows of data, and clips holdout with the same bounds, so
+// the holdout is treated as new data would be. It returns the bounds in
+// column name order.
+func winsorize(names []string, data, holdout [][]float64, specs map[string]winsorSpec) ([]clipBounds, error) {
+	response := len(names) - 1
+	cols, err := selectColumns(names[:response], sortedKeys(specs))
+	if err != nil {
+		return nil, fmt.Errorf("bad winsorize column: %v", err)
+	}
+
+	var bounds []clipBounds
+	for _, c := range cols {
+		name := names[c]
+		spec := specs[name]
+		for _, q := range []*float64{spec.Lower, spec.Upper} {
+			if q != nil && (*q < 0 || *q > 1) {
+				return nil, fmt.Errorf("winsorize %q: quantile %v is outside [0, 1]", name, *q)
+			}
+		}
+		if spec.Lower != nil && spec.Min != nil || spec.Upper != nil && spec.Max != nil {
+			return nil, fmt.Errorf("winsorize %q: give a quantile or a fixed bound for each side, not both", name)
+		}
+
+		sorted := column(data, c)
+		sort.Float64s(sorted)
+		b := clipBounds{Column: name, Lower: jsonFloat(math.Inf(-1)), Upper: jsonFloat(math.Inf(1))}
+		switch {
+		case spec.Min != nil:
+			b.Lower = jsonFloat(*spec.Min)
+		case spec.Lower != nil:
+			b.Lower = jsonFloat(quantile(sorted, *spec.Lower))
+		}
+		switch {
+		case spec.Max != nil:
+			b.Upper = jsonFloat(*spec.Max)
+		case spec.Upper != nil:
+			b.Upper = jsonFloat(quantile(sorted, *spec.Upper))
+		}
+		if b.Lower > b.Upper {
+			return nil, fmt.Errorf("winsorize %q: lower bound %g is above upper bound %g", name, float64(b.Lower), float64(b.Upper))
+		}
+
+		lo, hi := float64(b.Lower), float64(b.Upper)
+		for _, row := range data {
+			if v := math.Max(lo, math.Min(hi, row[c])); v != row[c] {
+				row[c] = v
+				b.Clipped++
+			}
+		}
+		for _, row := range holdout {
+			row[c] = math.Max(lo, math.Min(hi, row[c]))
+		}
+		bounds = append(bounds, b)
+	}
+	return bounds, nil
+}
+
+// binFeatures fits the bins in specs on data and returns, for each binned
+// column, dummies for all bins but the first, so they are not collinear
+// with the intercept. Bins are numbered from 1 in increasing order of x, and
+// the dummy for bin b of column c is named c_bin<b>. Equal-width bins split
+// the column's range evenly, quantile bins hold equal numbers of rows, and
+// supervised bins are the cuts that most reduce the response's squared
+// error, chosen greedily as in a regression tree.
+func binFeatures(names []string, data [][]float64, specs map[string]binSpec) ([]derivedFeature, error) {
+	response := len(names) - 1
+	y := column(data, response)
+
+	var binned []string
+	for name := range specs {
+		binned = append(binned, name)
+	}
+	sort.Strings(binned)
+
+	var features []derivedFeature
+	for _, name := range binned {
+		spec := specs[name]
+		cols, err := selectColumns(names[:response], []string{name})
+		if err != nil {
+			return nil, fmt.Errorf("bad bins: %v", err)
+		}
+		if spec.Count < 2 {
+			return nil, fmt.Errorf("bins for %q: need a count of at least 2, got %d", name, spec.Count)
+		}
+		c := cols[0]
+		x := column(data, c)
+
+		var cuts []float64
+		switch spec.Method {
+		case "equal-width":
+			cuts = equalWidthCuts(x, spec.Count)
+		case "quantile":
+			cuts = quantileCuts(x, spec.Count)
+		case "supervised":
+			cuts = supervisedCuts(x, y, spec.Count)
+		default:
+			return nil, fmt.Errorf("bins for %q: unknown method %q", name, spec.Method)
+		}
+
+		f := derivedFeature{
+			Values: func(row []float64) []float64 {
+				dummies := make([]float64, len(cuts))
+				for b := range cuts {
+					hi := math.Inf(1)
+					if b+1 < len(cuts) {
+						hi = cuts[b+1]
+					}
+					if row[c] >= cuts[b] && row[c] < hi {
+						dummies[b] = 1
+					}
+				}
+				return dummies
+			},
+		}
+		for b := range cuts {
+			f.Names = append(f.Names, fmt.Sprintf("%s_bin%d", name, b+2))
+		}
+		features = append(features, f)
+	}
+	return features, nil
+}
+
+// splineFeatures fits the natural cubic spline bases in specs on data. A
+// spline with K knots t1 < ... < tK adds K-2 columns to the column x
+// itself, the truncated power basis of Hastie, Tibshirani and Friedman
+// (2009, section 5.2.1):
+//
+//	d(k) - d(K-1), where d(k) = ((x-tk)+^3 - (x-tK)+^3) / (tK - tk)
+//
+// so the fit is cubic between the knots and linear beyond the boundary
+// knots. The added columns of a spline are named c_ns1, c_ns2, ... and
+// form a group: the search only considers subsets with all or none of them.
+func splineFeatures(names []string, data [][]float64, specs map[string]splineSpec) ([]derivedFeature, error) {
+	response := len(names) - 1
+
+	var splined []string
+	for name := range specs {
+		splined = append(splined, name)
+	}
+	sort.Strings(splined)
+
+	var features []derivedFeature
+	for _, name := range splined {
+		spec := specs[name]
+		cols, err := selectColumns(names[:response], []string{name})
+		if err != nil {
+			return nil, fmt.Errorf("bad splines: %v", err)
+		}
+		c := cols[0]
+
+		knots := append([]float64(nil), spec.At...)
+		if len(knots) == 0 {
+			sorted := column(data, c)
+			sort.Float64s(sorted)
+			for k := 0; k < spec.Knots; k++ {
+				q := quantile(sorted, 0.05+0.9*float64(k)/float64(spec.Knots-1))
+				if len(knots) == 0 || q > knots[len(knots)-1] {
+					knots = append(knots, q)
+				}
+			}
+		}
+		sort.Float64s(knots)
+		for k := 1; k < len(knots); k++ {
+			if knots[k] == knots[k-1] {
+				return nil, fmt.Errorf("splines for %q: repeated knot %v", name, knots[k])
+			}
+		}
+		if len(knots) < 3 {
+			return nil, fmt.Errorf("splines for %q: need at least 3 distinct knots, got %d", name, len(knots))
+		}
+
+		f := derivedFeature{
+			Values: func(row []float64) []float64 {
+				return naturalSplineBasis(row[c], knots)
+			},
+		}
+		for k := 1; k <= len(knots)-2; k++ {
+			f.Names = append(f.Names, fmt.Sprintf("%s_ns%d", name, k))
+		}
+		features = append(features, f)
+	}
+	return features, nil
+}
+
+// naturalSplineBasis returns the nonlinear natural cubic spline basis
+// functions at x for the sorted knots; see splineFeatures.
+func naturalSplineBasis(x float64, knots []float64) []float64 {
+	k := len(knots)
+	d := func(j int) float64 {
+		cube := func(v float64) float64 {
+			if v <= 0 {
+				return 0
+			}
+			return v * v * v
+		}
+		return (cube(x-knots[j]) - cube(x-knots[k-1])) / (knots[k-1] - knots[j])
+	}
+
+	last := d(k - 2)
+	basis := make([]float64, k-2)
+	for j := range basis {
+		basis[j] = d(j) - last
+	}
+	return basis
+}
+
+// categoricalColumns lists the columns loaded as categorical: those to
+// target encode and the group column of a random intercept, if any.
+func categoricalColumns(cfg runConfig, randomIntercept string) []string {
+	cols := sortedKeys(cfg.TargetEncode)
+	for _, name := range sortedKeys(cfg.HashEncode) {
+		if !containsString(cols, name) {
+			cols = append(cols, name)
+		}
+	}
+	if randomIntercept != "" && !containsString(cols, randomIntercept) {
+		cols = append(cols, randomIntercept)
+	}
+	return cols
+}
+
+// levelCode identifies a categorical level by the 32-bit FNV-1a hash of its
+// text, which a float64 holds exactly. Computing it needs no shared state,
+// so rows can be parsed concurrently.
+func levelCode(level string) float64 {
+	h := fnv.New32a()
+	io.WriteString(h, level)
+	return float64(h.Sum32())
+}
+
+// hashSpec is the number of indicator columns a hashed categorical is
+// spread over.
+type hashSpec struct {
+	Buckets int `json:"buckets"`
+}
+
+// hashFeatures encodes each categorical column in specs by the hashing
+// trick: level code h goes to bucket h mod buckets, whose column c_hash<b>
+// is +1 or -1, by the top bit of h, and every other bucket column is 0.
+// The random signs make levels that share a bucket cancel in expectation
+// rather than add up. The buckets of a column are meant to be selected
+// together, and the level code columns dropped after the buckets are
+// added; the function returns their indices for that.
+func hashFeatures(names []string, specs map[string]hashSpec, targetEncoded map[string]targetEncoding) ([]derivedFeature, []int, error) {
+	response := len(names) - 1
+	cols, err := selectColumns(names[:response], sortedKeys(specs))
+	if err != nil {
+		return nil, nil, fmt.Errorf("bad hash_encode column: %v", err)
+	}
+
+	features := make([]derivedFeature, len(cols))
+	for i, c := range cols {
+		name := names[c]
+		if _, ok := targetEncoded[name]; ok {
+			return nil, nil, fmt.Errorf("hash_encode %q: the column is also target encoded", name)
+		}
+		buckets := specs[name].Buckets
+		if buckets < 2 {
+			return nil, nil, fmt.Errorf("hash_encode %q: need at least 2 buckets, got %d", name, buckets)
+		}
+		f := derivedFeature{Names: make([]string, buckets)}
+		for b := range f.Names {
+			f.Names[b] = fmt.Sprintf("%s_hash%d", name, b)
+		}
+		c := c
+		f.Values = func(row []float64) []float64 {
+			h := uint32(row[c])
+			v := make([]float64, buckets)
+			v[h%uint32(buckets)] = 1
+			if h>>31 == 1 {
+				v[h%uint32(buckets)] = -1
+			}
+			return v
+		}
+		features[i] = f
+	}
+	return features, cols, nil
+}
+
+// targetEncode replaces, in place, the level codes of each categorical
+// column in specs with a smoothed mean of the response for that level:
+//
+//	(sum of y for the level + m * mean of y) / (rows of the level + m)
+//
+// where m is the smoothing weight. A training row's encoding is computed
+// out of fold, from the rows of the other folds (row i is in fold i%folds,
+// as in cross validation), so no row sees its own response. Holdout rows
+// are encoded from all the training rows, and levels unseen in training get
+// the overall mean.
+func targetEncode(names []string, data, holdout [][]float64, specs map[string]targetEncoding, folds int) error {
+	if folds < 2 {
+		return fmt.Errorf("target encoding needs at least 2 folds, got %d", folds)
+	}
+	response := len(names) - 1
+
+	type stats struct{ sum, n float64 }
+	for _, name := range sortedKeys(specs) {
+		cols, err := selectColumns(names[:response], []string{name})
+		if err != nil {
+			return fmt.Errorf("bad target encoding: %v", err)
+		}
+		c := cols[0]
+		m := 10.0
+		if specs[name].Smoothing != nil {
+			m = *specs[name].Smoothing
+		}
+
+		total := make(map[float64]stats)
+		byFold := make([]map[float64]stats, folds)
+		for f := range byFold {
+			byFold[f] = make(map[float64]stats)
+		}
+		foldSum := make([]float64, folds)
+		foldN := make([]float64, folds)
+		var sum float64
+		for i, row := range data {
+			level, y := row[c], row[response]
+			t := total[level]
+			total[level] = stats{t.sum + y, t.n + 1}
+			bf := byFold[i%folds][level]
+			byFold[i%folds][level] = stats{bf.sum + y, bf.n + 1}
+			foldSum[i%folds] += y
+			foldN[i%folds]++
+			sum += y
+		}
+
+		encode := func(s stats, mean float64) float64 {
+			return (s.sum + m*mean) / (s.n + m)
+		}
+		for i, row := range data {
+			f := i % folds
+			inFold := byFold[f][row[c]]
+			out := stats{total[row[c]].sum - inFold.sum, total[row[c]].n - inFold.n}
+			n := float64(len(data)) - foldN[f]
+			row[c] = encode(out, (sum-foldSum[f])/n)
+		}
+		mean := sum / float64(len(data))
+		for _, row := range holdout {
+			row[c] = encode(total[row[c]], mean)
+		}
+	}
+	return nil
+}
+
+// sortedKeys returns the keys of m in increasing order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// equalWidthCuts returns the inner edges of count bins of equal width over
+// the range of x.
+func equalWidthCuts(x []float64, count int) []float64 {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, v := range x {
+		lo, hi = math.Min(lo, v), math.Max(hi, v)
+	}
+	cuts := make([]float64, count-1)
+	for b := range cuts {
+		cuts[b] = lo + (hi-lo)*float64(b+1)/float64(count)
+	}
+	return cuts
+}
+
+// quantileCuts returns the inner edges of count bins holding equal numbers
+// of the values of x, without repeated edges.
+func quantileCuts(x []float64, count int) []float64 {
+	sorted := append([]float64(nil), x...)
+	sort.Float64s(sorted)
+	var cuts []float64
+	for b := 1; b < count; b++ {
+		q := quantile(sorted, float64(b)/float64(count))
+		if len(cuts) == 0 || q > cuts[len(cuts)-1] {
+			cuts = append(cuts, q)
+		}
+	}
+	return cuts
+}
+
+// supervisedCuts splits x into at most count bins, each time making the cut,
+// in any current bin, that most reduces the squared error of y about its
+// bin means. Cuts fall midway between distinct values of x.
+func supervisedCuts(x, y []float64, count int) []float64 {
+	order := make([]int, len(x))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return x[order[a]] < x[order[b]] })
+
+	// bins are ranges [lo, hi) of positions in order.
+	type bin struct{ lo, hi int }
+	bins := []bin{{0, len(order)}}
+	var cuts []float64
+	for len(bins) < count {
+		bestGain, bestBin, bestPos := 0.0, -1, 0
+		for bi, b := range bins {
+			var sum, sq float64
+			for _, i := range order[b.lo:b.hi] {
+				sum += y[i]
+				sq += y[i] * y[i]
+			}
+			total := sq - sum*sum/float64(b.hi-b.lo)
+
+			var leftSum, leftSq float64
+			for p := b.lo; p < b.hi-1; p++ {
+				leftSum += y[order[p]]
+				leftSq += y[order[p]] * y[order[p]]
+				if x[order[p]] == x[order[p+1]] {
+					continue
+				}
+				nl, nr := float64(p+1-b.lo), float64(b.hi-p-1)
+				rightSum, rightSq := sum-leftSum, sq-leftSq
+				sse := leftSq - leftSum*leftSum/nl + rightSq - rightSum*rightSum/nr
+				if gain := total - sse; gain > bestGain {
+					bestGain, bestBin, bestPos = gain, bi, p+1
+				}
+			}
+		}
+		if bestBin < 0 {
+			break
+		}
+		b := bins[bestBin]
+		bins = append(bins[:bestBin], append([]bin{{b.lo, bestPos}, {bestPos, b.hi}}, bins[bestBin+1:]...)...)
+		cuts = append(cuts, (x[order[bestPos-1]]+x[order[bestPos]])/2)
+	}
+	sort.Float64s(cuts)
+	return cuts
+}
@@ -0,0 +1,671 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"sync"
+
+	"github.com/sajari/regression"
+)
+
+// errSingularFit is returned by fitModel when the regression has no unique
+// solution, e.g. because the features are collinear.
+var errSingularFit = errors.New("regression has no solution")
+
+// errFitBudget is returned by fitModel when an iterative fitter ran out of
+// its fitBudget before converging.
+var errFitBudget = errors.New("fit did not converge within its budget")
+
+// fitBudget bounds one fit by an iterative fitter: at most max iterations,
+// where positive, and none after cancel is closed. A nil *fitBudget sets no
+// bounds beyond the fitter's own.
+type fitBudget struct {
+	max    int
+	cancel <-chan struct{}
+	// stopped is set when the fitter gave up before converging because
+	// of the budget.
+	stopped bool
+}
+
+// next reports whether a fitter whose own limit is limit iterations may
+// run iteration iter. When the budget refuses, the fit is marked stopped.
+func (b *fitBudget) next(iter, limit int) bool {
+	if b == nil {
+		return iter < limit
+	}
+	if b.max > 0 && b.max < limit {
+		limit = b.max
+	}
+	if iter >= limit || b.cancelled() {
+		b.stopped = true
+		return false
+	}
+	return true
+}
+
+// cancelled reports whether the fit should stop now.
+func (b *fitBudget) cancelled() bool {
+	if b == nil || b.cancel == nil {
+		return false
+	}
+	select {
+	case <-b.cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+func (b *fitBudget) stoppedShort() bool {
+	return b != nil && b.stopped
+}
+
+func fitModel(y []float64, features []int, data [][]float64, opts searchOptions) (mse, score float64, err error) {
+	var mixed mixedFit
+	var r *linearModel
+	if opts.randomIntercept {
+		r, mixed = fitRandomIntercept(y, features, data, opts.groupColumn, opts.throughOrigin, opts.budget)
+	} else {
+		r = opts.train(y, features, data)
+	}
+	if opts.budget.stoppedShort() {
+		return 0, 0, errFitBudget
+	}
+	if r.Coeffs == nil {
+		return 0, 0, errSingularFit
+	}
+
+	// Calculate MSE
+	mse = meanSquaredError(r, y, features, data, opts.compensated)
+
+	// Calculate the selection criterion
+	coeffs := len(features)
+	if !opts.throughOrigin {
+		coeffs++
+	}
+	stats := fitStats{
+		N:        len(data),
+		Features: len(features),
+		Coeffs:   coeffs,
+		RSS:      mse * float64(len(data)),
+		TSS:      totalSumOfSquares(y, opts.throughOrigin),
+	}
+	if opts.randomIntercept {
+		// The variance ratio is one more parameter.
+		stats.Coeffs++
+		stats.RSS, stats.LogDet = mixed.RSS, mixed.LogDet
+	}
+	score = opts.criterion.Score(stats)
+
+	return mse, score, nil
+}
+
+// predictor is a fitted model that predicts the response from the values of
+// its selected features.
+type predictor interface {
+	Predict(x []float64) (float64, error)
+}
+
+// trainModel fits a regression of y on the selected features of data. The
+// regression package always fits an intercept, so throughOrigin fits solve
+// the normal equations directly instead. With no features the model
+// predicts the mean of y, or zero through the origin.
+func trainModel(y []float64, features []int, data [][]float64, throughOrigin bool) *linearModel {
+	if len(features) == 0 {
+		if throughOrigin {
+			return &linearModel{Coeffs: []float64{}}
+		}
+		var mean float64
+		for _, v := range y {
+			mean += v
+		}
+		return &linearModel{Intercept: mean / float64(len(y)), Coeffs: []float64{}}
+	}
+	if throughOrigin {
+		return fitThroughOrigin(y, features, data)
+	}
+
+	r := new(regression.Regression)
+
+	// Set the observed variable
+	r.SetObserved("mv")
+
+	// Add the selected features to the regression model
+	for i, idx := range features {
+		r.SetVar(i, strconv.Itoa(idx))
+	}
+
+	// Train on one row of selected feature values per observation. The
+	// rows are carved from pooled scratch space; r is dropped once the
+	// coefficients are read, so they can be reused by the next fit.
+	s := getScratch()
+	defer putScratch(s)
+	k := len(features)
+	flat := s.floats(&s.rows, len(data)*k)
+	for i, row := range data {
+		x := flat[i*k : (i+1)*k : (i+1)*k]
+		r.Train(regression.DataPoint(y[i], featureRowInto(x, row, features)))
+	}
+
+	// Run the regression
+	if err := r.Run(); err != nil {
+		return &linearModel{}
+	}
+
+	coeffs := r.GetCoeffs()
+	return &linearModel{Intercept: coeffs[0], Coeffs: coeffs[1:]}
+}
+
+// linearModel holds the coefficients of a fitted regression. A fit that
+// failed has no coefficients, and its predictions return an error.
+type linearModel struct {
+	Intercept float64
+	Coeffs    []float64
+}
+
+func (m *linearModel) Predict(x []float64) (float64, error) {
+	if m.Coeffs == nil {
+		return 0, errors.New("regression has no solution")
+	}
+	yPred := m.Intercept
+	for j, v := range x {
+		yPred += m.Coeffs[j] * v
+	}
+	return yPred, nil
+}
+
+// fitThroughOrigin solves the normal equations X'X b = X'y for the selected
+// features without an intercept column. A singular X'X leaves the model
+// without coefficients, so its predictions fail.
+func fitThroughOrigin(y []float64, features []int, data [][]float64) *linearModel {
+	s := getScratch()
+	defer putScratch(s)
+
+	k := len(features)
+	cells := s.floats(&s.xtx, k*k)
+	xtx := make([][]float64, k)
+	for i := range xtx {
+		xtx[i] = cells[i*k : (i+1)*k]
+		clear(xtx[i])
+	}
+	xty := s.floats(&s.xty, k)
+	clear(xty)
+	buf := s.floats(&s.row, k)
+
+	for obs, row := range data {
+		x := featureRowInto(buf, row, features)
+		for i := 0; i < k; i++ {
+			for j := 0; j <= i; j++ {
+				xtx[i][j] += x[i] * x[j]
+			}
+			xty[i] += x[i] * y[obs]
+		}
+	}
+	for i := 0; i < k; i++ {
+		for j := 0; j < i; j++ {
+			xtx[j][i] = xtx[i][j]
+		}
+	}
+
+	coeffs, err := solveSymmetric(xtx, xty)
+	if err != nil {
+		return &linearModel{}
+	}
+	return &linearModel{Coeffs: coeffs}
+}
+
+// mixedFit is what a random intercept fit adds to its fixed effects: the
+// ratio of the group variance to the residual variance, the sum of squares
+// of the decorrelated residuals, and the log determinant of the errors'
+// correlation matrix.
+type mixedFit struct {
+	Lambda float64
+	RSS    float64
+	LogDet float64
+}
+
+// fitRandomIntercept fits the mixed model y = X b + u[g] + e, with a
+// normal random intercept u for each level g of column group and normal
+// errors e. The variance ratio lambda = var(u) / var(e) is estimated by
+// REML, maximising the restricted likelihood over log lambda by golden
+// section search, with lambda = 0 (no group effect) also considered. For a
+// given lambda the fixed effects are the GLS estimates, computed as OLS on
+// data quasi-demeaned within each group. The model returned predicts with
+// the fixed effects only, so it applies to rows from unseen groups.
+func fitRandomIntercept(y []float64, features []int, data [][]float64, group int, throughOrigin bool, budget *fitBudget) (*linearModel, mixedFit) {
+	level := make(map[float64]int)
+	of := make([]int, len(data))
+	var sizes []int
+	for i, row := range data {
+		g, ok := level[row[group]]
+		if !ok {
+			g = len(sizes)
+			level[row[group]] = g
+			sizes = append(sizes, 0)
+		}
+		of[i] = g
+		sizes[g]++
+	}
+
+	offset := 1
+	if throughOrigin {
+		offset = 0
+	}
+	p := len(features) + offset
+	if len(data) <= p {
+		return &linearModel{}, mixedFit{}
+	}
+
+	// Group means of the design columns and the response.
+	sums := make([][]float64, len(sizes))
+	for g := range sums {
+		sums[g] = make([]float64, p+1)
+	}
+	x := make([]float64, p+1)
+	design := func(i int) []float64 {
+		if offset == 1 {
+			x[0] = 1
+		}
+		featureRowInto(x[offset:p], data[i], features)
+		x[p] = y[i]
+		return x
+	}
+	for i := range data {
+		for j, v := range design(i) {
+			sums[of[i]][j] += v
+		}
+	}
+
+	// fit returns the GLS coefficients for lambda, the decorrelated RSS,
+	// the log determinants of the correlation matrix and of X*'X*.
+	fit := func(lambda float64) (beta []float64, rss, logDetV, logDetX float64, ok bool) {
+		theta := make([]float64, len(sizes))
+		for g, n := range sizes {
+			theta[g] = 1 - 1/math.Sqrt(1+float64(n)*lambda)
+			logDetV += math.Log(1 + float64(n)*lambda)
+		}
+		xtx := make([][]float64, p)
+		for j := range xtx {
+			xtx[j] = make([]float64, p)
+		}
+		xty := make([]float64, p)
+		var yty float64
+		for i := range data {
+			z := design(i)
+			g := of[i]
+			for j := range z {
+				z[j] -= theta[g] * sums[g][j] / float64(sizes[g])
+			}
+			for a := 0; a < p; a++ {
+				for b := 0; b <= a; b++ {
+					xtx[a][b] += z[a] * z[b]
+				}
+				xty[a] += z[a] * z[p]
+			}
+			yty += z[p] * z[p]
+		}
+		for a := 0; a < p; a++ {
+			for b := 0; b < a; b++ {
+				xtx[b][a] = xtx[a][b]
+			}
+		}
+		l, err := cholesky(xtx)
+		if err != nil {
+			return nil, 0, 0, 0, false
+		}
+		beta = solveCholesky(l, xty)
+		rss = yty
+		for a := range beta {
+			rss -= beta[a] * xty[a]
+		}
+		for a := range l {
+			logDetX += 2 * math.Log(l[a][a])
+		}
+		return beta, math.Max(rss, 0), logDetV, logDetX, true
+	}
+	reml := func(lambda float64) float64 {
+		_, rss, logDetV, logDetX, ok := fit(lambda)
+		if !ok {
+			return math.Inf(-1)
+		}
+		return -0.5 * (float64(len(data)-p)*math.Log(rss) + logDetV + logDetX)
+	}
+
+	// Golden section search for the maximum over log lambda.
+	const steps = 40
+	lo, hi := -12.0, 8.0
+	phi := (math.Sqrt(5) - 1) / 2
+	a, b := hi-phi*(hi-lo), lo+phi*(hi-lo)
+	fa, fb := reml(math.Exp(a)), reml(math.Exp(b))
+	for i := 0; i < steps; i++ {
+		if budget.cancelled() {
+			return &linearModel{}, mixedFit{}
+		}
+		if fa > fb {
+			hi, b, fb = b, a, fa
+			a = hi - phi*(hi-lo)
+			fa = reml(math.Exp(a))
+		} else {
+			lo, a, fa = a, b, fb
+			b = lo + phi*(hi-lo)
+			fb = reml(math.Exp(b))
+		}
+	}
+	lambda := math.Exp((lo + hi) / 2)
+	if reml(0) >= reml(lambda) {
+		lambda = 0
+	}
+
+	beta, rss, logDetV, _, ok := fit(lambda)
+	if !ok {
+		return &linearModel{}, mixedFit{}
+	}
+	m := &linearModel{Coeffs: beta[offset:]}
+	if offset == 1 {
+		m.Intercept = beta[0]
+	}
+	return m, mixedFit{Lambda: lambda, RSS: rss, LogDet: logDetV}
+}
+
+// fitTLS fits y on the selected features by total least squares, which,
+// unlike ordinary least squares, allows for measurement error in the
+// predictors as well as the response: it minimises the sum of squared
+// perpendicular distances to the fitted hyperplane. The coefficients come
+// from the eigenvector of the smallest eigenvalue of Z'Z, where Z holds
+// the centred features and response (uncentred through the origin).
+// Errors are assumed to have the same variance in every column, so the
+// columns should be on comparable scales. A fit whose hyperplane is
+// parallel to the response axis has no coefficients.
+func fitTLS(y []float64, features []int, data [][]float64, throughOrigin bool, budget *fitBudget) *linearModel {
+	k := len(features)
+	if k == 0 {
+		return trainModel(y, features, data, throughOrigin)
+	}
+
+	means := make([]float64, k+1)
+	if !throughOrigin {
+		for i, row := range data {
+			for j, idx := range features {
+				means[j] += row[idx]
+			}
+			means[k] += y[i]
+		}
+		for j := range means {
+			means[j] /= float64(len(data))
+		}
+	}
+
+	ztz := make([][]float64, k+1)
+	for i := range ztz {
+		ztz[i] = make([]float64, k+1)
+	}
+	z := make([]float64, k+1)
+	for obs, row := range data {
+		for j, idx := range features {
+			z[j] = row[idx] - means[j]
+		}
+		z[k] = y[obs] - means[k]
+		for i := range z {
+			for j := 0; j <= i; j++ {
+				ztz[i][j] += z[i] * z[j]
+			}
+		}
+	}
+	for i := range ztz {
+		for j := 0; j < i; j++ {
+			ztz[j][i] = ztz[i][j]
+		}
+	}
+
+	values, vectors := symmetricEigen(ztz, budget)
+	if budget.stoppedShort() {
+		return &linearModel{}
+	}
+	smallest := 0
+	for i, v := range values {
+		if v < values[smallest] {
+			smallest = i
+		}
+	}
+	v := vectors[smallest]
+	if math.Abs(v[k]) < 1e-12 {
+		return &linearModel{}
+	}
+
+	m := &linearModel{Intercept: means[k], Coeffs: make([]float64, k)}
+	for j := range m.Coeffs {
+		m.Coeffs[j] = -v[j] / v[k]
+		m.Intercept -= m.Coeffs[j] * means[j]
+	}
+	return m
+}
+
+// symmetricEigen returns the eigenvalues of a symmetric matrix a and the
+// matching unit eigenvectors, vectors[i] for values[i], by the cyclic
+// Jacobi method, with at most 100 sweeps or those budget allows. a is left
+// unchanged.
+func symmetricEigen(a [][]float64, budget *fitBudget) (values []float64, vectors [][]float64) {
+	n := len(a)
+	m := make([][]float64, n)
+	v := make([][]float64, n)
+	for i := range m {
+		m[i] = append([]float64(nil), a[i]...)
+		v[i] = make([]float64, n)
+		v[i][i] = 1
+	}
+
+	for sweep := 0; ; sweep++ {
+		var off, total float64
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				total += m[i][j] * m[i][j]
+				if i != j {
+					off += m[i][j] * m[i][j]
+				}
+			}
+		}
+		if off <= 1e-24*total || !budget.next(sweep, 100) {
+			break
+		}
+		for p := 0; p < n; p++ {
+			for q := p + 1; q < n; q++ {
+				if m[p][q] == 0 {
+					continue
+				}
+				// Rotate rows and columns p and q to zero m[p][q].
+				theta := (m[q][q] - m[p][p]) / (2 * m[p][q])
+				t := math.Copysign(1, theta) / (math.Abs(theta) + math.Sqrt(theta*theta+1))
+				c := 1 / math.Sqrt(t*t+1)
+				s := t * c
+				for k := 0; k < n; k++ {
+					mkp, mkq := m[k][p], m[k][q]
+					m[k][p], m[k][q] = c*mkp-s*mkq, s*mkp+c*mkq
+				}
+				for k := 0; k < n; k++ {
+					mpk, mqk := m[p][k], m[q][k]
+					m[p][k], m[q][k] = c*mpk-s*mqk, s*mpk+c*mqk
+				}
+				for k := 0; k < n; k++ {
+					vkp, vkq := v[k][p], v[k][q]
+					v[k][p], v[k][q] = c*vkp-s*vkq, s*vkp+c*vkq
+				}
+			}
+		}
+	}
+
+	values = make([]float64, n)
+	vectors = make([][]float64, n)
+	for i := range values {
+		values[i] = m[i][i]
+		vectors[i] = make([]float64, n)
+		for k := range vectors[i] {
+			vectors[i][k] = v[k][i]
+		}
+	}
+	return values, vectors
+}
+
+// solveSymmetric solves a x = b for a symmetric positive definite matrix a
+// by Cholesky decomposition. a and b are left unchanged.
+func solveSymmetric(a [][]float64, b []float64) ([]float64, error) {
+	l, err := cholesky(a)
+	if err != nil {
+		return nil, err
+	}
+	return solveCholesky(l, b), nil
+}
+
+// cholesky returns the lower triangular l with l l' = a, for a symmetric
+// positive definite matrix a.
+func cholesky(a [][]float64) ([][]float64, error) {
+	n := len(a)
+	l := make([][]float64, n)
+	for i := range l {
+		l[i] = make([]float64, n)
+		for j := 0; j <= i; j++ {
+			sum := a[i][j]
+			for k := 0; k < j; k++ {
+				sum -= l[i][k] * l[j][k]
+			}
+			if i == j {
+				if sum <= 0 {
+					return nil, errors.New("matrix is not positive definite")
+				}
+				l[i][i] = math.Sqrt(sum)
+			} else {
+				l[i][j] = sum / l[j][j]
+			}
+		}
+	}
+	return l, nil
+}
+
+// solveCholesky solves l l' x = b given the Cholesky factor l.
+func solveCholesky(l [][]float64, b []float64) []float64 {
+	n := len(l)
+	// Forward substitution for L z = b, then back substitution for L' x = z.
+	x := make([]float64, n)
+	for i := 0; i < n; i++ {
+		sum := b[i]
+		for k := 0; k < i; k++ {
+			sum -= l[i][k] * x[k]
+		}
+		x[i] = sum / l[i][i]
+	}
+	for i := n - 1; i >= 0; i-- {
+		sum := x[i]
+		for k := i + 1; k < n; k++ {
+			sum -= l[k][i] * x[k]
+		}
+		x[i] = sum / l[i][i]
+	}
+	return x
+}
+
+// meanSquaredError returns the mean squared prediction error of r on data,
+// or NaN if r can't predict, e.g. because its fit was singular.
+func meanSquaredError(r predictor, y []float64, features []int, data [][]float64, compensated bool) float64 {
+	s := getScratch()
+	defer putScratch(s)
+	buf := s.floats(&s.row, len(features))
+	pred := s.floats(&s.pred, len(data))
+
+	for i, row := range data {
+		yPred, err := r.Predict(featureRowInto(buf, row, features))
+		if err != nil {
+			return math.NaN()
+		}
+		pred[i] = yPred
+	}
+	if !compensated {
+		return sumSquaredDiff(y[:len(data)], pred) / float64(len(data))
+	}
+	var rss kahanSum
+	for i, p := range pred {
+		rss.Add(math.Pow(y[i]-p, 2))
+	}
+	return rss.sum / float64(len(data))
+}
+
+// featureRow picks the selected feature values out of an observation.
+func featureRow(row []float64, features []int) []float64 {
+	return featureRowInto(make([]float64, len(features)), row, features)
+}
+
+// featureRowInto is featureRow writing into x, which must have one element
+// per feature.
+func featureRowInto(x, row []float64, features []int) []float64 {
+	for j, idx := range features {
+		x[j] = row[idx]
+	}
+	return x
+}
+
+// fitScratch is reusable space for one fit: the feature rows handed to the
+// regression package, one feature row, the predictions whose residuals
+// make up the RSS, and the normal equations of a fit through the origin. Fits take one from scratchPool and return it when
+// done, so a worker's steady state allocates no per-row slices.
+type fitScratch struct {
+	rows, row, pred []float64
+	xtx, xty        []float64
+}
+
+var scratchPool = sync.Pool{New: func() any { return new(fitScratch) }}
+
+func getScratch() *fitScratch { return scratchPool.Get().(*fitScratch) }
+
+func putScratch(s *fitScratch) { scratchPool.Put(s) }
+
+// floats returns (*buf)[:n], growing *buf first if it is too small.
+func (s *fitScratch) floats(buf *[]float64, n int) []float64 {
+	if cap(*buf) < n {
+		*buf = make([]float64, n)
+	}
+	*buf = (*buf)[:n]
+	return *buf
+}
+
+// cvError estimates the out-of-sample MSE of a subset by k-fold cross
+// validation. Observation i belongs to fold i%folds, so every subset is
+// scored on the same folds.
+func cvError(y []float64, features []int, data [][]float64, opts searchOptions) float64 {
+	folds := opts.folds
+	var sse float64
+	for fold := 0; fold < folds; fold++ {
+		var trainY, testY []float64
+		var trainData, testData [][]float64
+		for i, row := range data {
+			if i%folds == fold {
+				testY = append(testY, y[i])
+				testData = append(testData, row)
+			} else {
+				trainY = append(trainY, y[i])
+				trainData = append(trainData, row)
+			}
+		}
+		if len(testData) == 0 {
+			continue
+		}
+
+		r := opts.train(trainY, features, trainData)
+		sse += meanSquaredError(r, testY, features, testData, opts.compensated) * float64(len(testData))
+	}
+	return sse / float64(len(data))
+}
+
+// kahanSum accumulates float64 values with Kahan compensated summation.
+// A subset's RSS is summed by one worker in row order, so it doesn't
+// depend on the number of workers; compensation only shrinks the rounding
+// error of long sums, which could otherwise decide between close criteria.
+type kahanSum struct {
+	sum float64
+	c   float64
+}
+
+func (k *kahanSum) Add(v float64) {
+	y := v - k.c
+	t := k.sum + y
+	k.c = (t - k.sum) - y
+	k.sum = t
+}
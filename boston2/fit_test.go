@@ -0,0 +1,81 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// exactProblem is a response that is an exact linear function of columns
+// 0, 2 and 3 of five, through the origin or not.
+func exactProblem(n int, throughOrigin bool) ([]float64, [][]float64) {
+	y := make([]float64, n)
+	data := make([][]float64, n)
+	for i := range data {
+		x := float64(i)
+		data[i] = []float64{x, math.Sin(x), x * x / 10, math.Cos(2 * x), 1 / (1 + x), 0}
+		y[i] = 1.5*data[i][0] - 3*data[i][2] + 0.5*data[i][3]
+		if !throughOrigin {
+			y[i] += 2
+		}
+		data[i][5] = y[i]
+	}
+	return y, data
+}
+
+// TestFitModelTrainsOnObservations checks that fits recover an exact
+// linear relation, which needs them to train on one data point per
+// observation rather than one per feature, and that the MSE averages over
+// every observation.
+func TestFitModelTrainsOnObservations(t *testing.T) {
+	features := []int{0, 2, 3}
+	for _, throughOrigin := range []bool{false, true} {
+		for _, compensated := range []bool{false, true} {
+			y, data := exactProblem(30, throughOrigin)
+			opts := searchOptions{criterion: gaussianAIC{}, throughOrigin: throughOrigin, compensated: compensated}
+			if mse, _, err := fitModel(y, features, data, opts); err != nil || mse > 1e-18 {
+				t.Errorf("through origin %v, compensated %v: MSE of an exact fit = %g, %v; want 0", throughOrigin, compensated, mse, err)
+			}
+
+			// One observation off by 3 adds 9/n to the MSE of the fitted model.
+			m := opts.train(y, features, data)
+			y[7] += 3
+			if got, want := meanSquaredError(m, y, features, data, compensated), 9.0/30; math.Abs(got-want) > 1e-12 {
+				t.Errorf("through origin %v, compensated %v: MSE with one residual of 3 = %g, want %g", throughOrigin, compensated, got, want)
+			}
+		}
+	}
+}
+
+// BenchmarkFit fits one subset of a 500-row problem per iteration, with
+// and without an intercept. The unpooled variants empty scratchPool before
+// each fit, as if there were no pool, to show the allocations it saves.
+func BenchmarkFit(b *testing.B) {
+	const numExplanatory = 12
+	y, data := syntheticProblem(rand.New(rand.NewSource(1)), 500, numExplanatory)
+	features := []int{0, 2, 3, 5, 7, 8, 11}
+	for _, bm := range []struct {
+		name          string
+		throughOrigin bool
+		pooled        bool
+	}{
+		{"intercept/pooled", false, true},
+		{"intercept/unpooled", false, false},
+		{"origin/pooled", true, true},
+		{"origin/unpooled", true, false},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			opts := searchOptions{criterion: gaussianAIC{}, throughOrigin: bm.throughOrigin}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if !bm.pooled {
+					scratchPool = sync.Pool{New: func() any { return new(fitScratch) }}
+				}
+				if _, _, err := fitModel(y, features, data, opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
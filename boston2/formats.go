@@ -0,0 +1,301 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// detectFormat guesses the input format from the file extension, ignoring a
+// compression suffix.
+func detectFormat(path string) string {
+	path = strings.TrimSuffix(strings.TrimSuffix(path, ".gz"), ".zst")
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".tsv", ".tab":
+		return "tsv"
+	case ".jsonl", ".ndjson":
+		return "jsonl"
+	case ".xlsx":
+		return "xlsx"
+	}
+	return "csv"
+}
+
+// sniffFormat guesses the format of a stream from its first line: an opening
+// brace means JSON lines, a tab without commas means TSV.
+func sniffFormat(r *bufio.Reader) string {
+	peek, _ := r.Peek(4096)
+	line := peek
+	if i := bytes.IndexByte(peek, '\n'); i >= 0 {
+		line = peek[:i]
+	}
+	line = bytes.TrimSpace(line)
+	switch {
+	case bytes.HasPrefix(peek, []byte("PK\x03\x04")):
+		return "xlsx"
+	case bytes.HasPrefix(line, []byte("{")):
+		return "jsonl"
+	case bytes.IndexByte(line, '\t') >= 0 && bytes.IndexByte(line, ',') < 0:
+		return "tsv"
+	}
+	return "csv"
+}
+
+// readJSONLines reads one JSON object per line. The keys of the first object,
+// in order, become the header; every later object must have the same keys.
+// Values are returned as strings so they go through the same parsing as
+// delimited input.
+func readJSONLines(r io.Reader) ([]string, [][]string, error) {
+	var (
+		header  []string
+		index   map[string]int
+		records [][]string
+	)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		keys, values, err := decodeObject(scanner.Bytes())
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse JSON on line %d: %v", line, err)
+		}
+
+		if header == nil {
+			header = keys
+			index = make(map[string]int, len(keys))
+			for i, k := range keys {
+				index[k] = i
+			}
+			records = append(records, values)
+			continue
+		}
+
+		if len(keys) != len(header) {
+			return nil, nil, fmt.Errorf("line %d has %d keys, want %d", line, len(keys), len(header))
+		}
+		record := make([]string, len(header))
+		for i, k := range keys {
+			j, ok := index[k]
+			if !ok {
+				return nil, nil, fmt.Errorf("line %d has unknown key %q", line, k)
+			}
+			record[j] = values[i]
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read JSON lines: %v", err)
+	}
+	if header == nil {
+		return nil, nil, fmt.Errorf("failed to read header: no JSON objects")
+	}
+	return header, records, nil
+}
+
+// readXLSX reads one worksheet of an Excel workbook: the named sheet, or
+// the first. Its first row is the header and the rows after it become
+// records of the header's width, with missing cells empty. Only the
+// parts of the Office Open XML format needed for plain cell values are
+// read: the workbook's sheet list, the shared strings and the sheet cells.
+func readXLSX(r io.Reader, sheet string) ([]string, [][]string, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read xlsx: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open xlsx: %v", err)
+	}
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	decode := func(name string, v any) error {
+		f, ok := files[name]
+		if !ok {
+			return fmt.Errorf("xlsx has no %s", name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		return xml.NewDecoder(rc).Decode(v)
+	}
+
+	var workbook struct {
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+			ID   string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := decode("xl/workbook.xml", &workbook); err != nil {
+		return nil, nil, fmt.Errorf("failed to read xlsx workbook: %v", err)
+	}
+	var rels struct {
+		Rels []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := decode("xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, nil, fmt.Errorf("failed to read xlsx workbook: %v", err)
+	}
+
+	var id string
+	for _, s := range workbook.Sheets {
+		if sheet == "" || s.Name == sheet {
+			id = s.ID
+			break
+		}
+	}
+	if id == "" {
+		return nil, nil, fmt.Errorf("xlsx has no sheet %q", sheet)
+	}
+	var target string
+	for _, rel := range rels.Rels {
+		if rel.ID == id {
+			target = rel.Target
+		}
+	}
+	if strings.HasPrefix(target, "/") {
+		target = strings.TrimPrefix(target, "/")
+	} else {
+		target = "xl/" + target
+	}
+
+	var shared []string
+	if _, ok := files["xl/sharedStrings.xml"]; ok {
+		var sst struct {
+			Items []struct {
+				Text string `xml:"t"`
+				Runs []struct {
+					Text string `xml:"t"`
+				} `xml:"r"`
+			} `xml:"si"`
+		}
+		if err := decode("xl/sharedStrings.xml", &sst); err != nil {
+			return nil, nil, fmt.Errorf("failed to read xlsx strings: %v", err)
+		}
+		for _, si := range sst.Items {
+			text := si.Text
+			for _, run := range si.Runs {
+				text += run.Text
+			}
+			shared = append(shared, text)
+		}
+	}
+
+	var ws struct {
+		Rows []struct {
+			Cells []struct {
+				Ref    string `xml:"r,attr"`
+				Type   string `xml:"t,attr"`
+				Value  string `xml:"v"`
+				Inline string `xml:"is>t"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	if err := decode(target, &ws); err != nil {
+		return nil, nil, fmt.Errorf("failed to read xlsx sheet: %v", err)
+	}
+
+	var rows [][]string
+	for _, row := range ws.Rows {
+		var record []string
+		for i, c := range row.Cells {
+			col := i
+			if c.Ref != "" {
+				col = cellColumn(c.Ref)
+			}
+			for len(record) <= col {
+				record = append(record, "")
+			}
+			switch c.Type {
+			case "s":
+				n, err := strconv.Atoi(c.Value)
+				if err != nil || n < 0 || n >= len(shared) {
+					return nil, nil, fmt.Errorf("xlsx cell %s has a bad string index %q", c.Ref, c.Value)
+				}
+				record[col] = shared[n]
+			case "inlineStr":
+				record[col] = c.Inline
+			default:
+				record[col] = c.Value
+			}
+		}
+		rows = append(rows, record)
+	}
+	if len(rows) == 0 {
+		return nil, nil, fmt.Errorf("failed to read header: xlsx sheet is empty")
+	}
+
+	header := rows[0]
+	records := rows[1:]
+	for i, record := range records {
+		for len(record) < len(header) {
+			record = append(record, "")
+		}
+		records[i] = record[:len(header)]
+	}
+	return header, records, nil
+}
+
+// cellColumn returns the 0-based column of a cell reference such as "AB12".
+func cellColumn(ref string) int {
+	col := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		col = col*26 + int(r-'A'+1)
+	}
+	return col - 1
+}
+
+// decodeObject returns the keys of a flat JSON object in order, with each
+// value as a string: numbers keep their literal form.
+func decodeObject(line []byte) ([]string, []string, error) {
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, nil, errors.New("expected an object")
+	}
+
+	var keys, values []string
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		key := tok.(string)
+
+		var v any
+		if err := dec.Decode(&v); err != nil {
+			return nil, nil, err
+		}
+		switch v := v.(type) {
+		case json.Number:
+			values = append(values, v.String())
+		case string:
+			values = append(values, v)
+		default:
+			return nil, nil, fmt.Errorf("value of %q is not a number or string", key)
+		}
+		keys = append(keys, key)
+	}
+	return keys, values, nil
+}
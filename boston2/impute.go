@@ -0,0 +1,209 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// dropMissingResponse drops the rows of data and holdout whose response,
+// the last column, is NaN, and returns how many training rows it dropped.
+func dropMissingResponse(data, holdout [][]float64) ([][]float64, [][]float64, int) {
+	keep := func(rows [][]float64) [][]float64 {
+		var kept [][]float64
+		for _, row := range rows {
+			if !math.IsNaN(row[len(row)-1]) {
+				kept = append(kept, row)
+			}
+		}
+		return kept
+	}
+	kept := keep(data)
+	return kept, keep(holdout), len(data) - len(kept)
+}
+
+// missingIndicators returns, for each column of cols with a NaN in data,
+// an indicator column c_missing that is 1 where the value is missing and 0
+// elsewhere, so that a model can use the missingness itself. They must be
+// added before imputation fills the NaNs.
+func missingIndicators(names []string, data [][]float64, cols []int) []derivedFeature {
+	var features []derivedFeature
+	for _, c := range cols {
+		for _, row := range data {
+			if math.IsNaN(row[c]) {
+				c := c
+				features = append(features, derivedFeature{
+					Names: []string{names[c] + "_missing"},
+					Values: func(row []float64) []float64 {
+						if math.IsNaN(row[c]) {
+							return []float64{1}
+						}
+						return []float64{0}
+					},
+				})
+				break
+			}
+		}
+	}
+	return features
+}
+
+// imputeChained fills, in place, the NaN values of columns cols of data
+// and holdout by chained equations. Missing values start
+// at the mean of the column's observed training values. Each iteration
+// then regresses every column with missing values on the other columns of
+// cols, over the training rows where it is observed, and
+// replaces its missing values with the predictions, using the values
+// imputed so far for the other columns. With no iterations this is mean
+// imputation. The holdout is filled the same way with the models fitted
+// on the training rows, so it is treated as new data would be; the
+// response is never a predictor, for the same reason. It returns the
+// number of training values filled in each column of cols.
+func imputeChained(data, holdout [][]float64, cols []int, iterations int) []int {
+	filled := make([]int, len(cols))
+	missing := make(map[int][]int)
+	missingHoldout := make(map[int][]int)
+	var incomplete []int
+	for k, c := range cols {
+		var sum float64
+		var observed int
+		for i, row := range data {
+			if math.IsNaN(row[c]) {
+				missing[c] = append(missing[c], i)
+			} else {
+				sum += row[c]
+				observed++
+			}
+		}
+		for i, row := range holdout {
+			if math.IsNaN(row[c]) {
+				missingHoldout[c] = append(missingHoldout[c], i)
+			}
+		}
+		if len(missing[c]) == 0 && len(missingHoldout[c]) == 0 {
+			continue
+		}
+		mean := math.NaN()
+		if observed > 0 {
+			mean = sum / float64(observed)
+		}
+		for _, i := range missing[c] {
+			data[i][c] = mean
+		}
+		for _, i := range missingHoldout[c] {
+			holdout[i][c] = mean
+		}
+		filled[k] = len(missing[c])
+		if observed > 0 {
+			incomplete = append(incomplete, c)
+		}
+	}
+
+	// fill replaces the missing values of rows in column c with the
+	// predictions of m on the other columns.
+	fill := func(m *linearModel, rows [][]float64, indices []int, c int, others []int) {
+		buf := make([]float64, len(others))
+		for _, i := range indices {
+			if v, err := m.Predict(featureRowInto(buf, rows[i], others)); err == nil {
+				rows[i][c] = v
+			}
+		}
+	}
+
+	others := func(c int) []int {
+		var rest []int
+		for _, j := range cols {
+			if j != c {
+				rest = append(rest, j)
+			}
+		}
+		return rest
+	}
+
+	models := make(map[int]*linearModel)
+	for iter := 0; iter < iterations; iter++ {
+		for _, c := range incomplete {
+			isMissing := make(map[int]bool, len(missing[c]))
+			for _, i := range missing[c] {
+				isMissing[i] = true
+			}
+			var y []float64
+			var rows [][]float64
+			for i, row := range data {
+				if !isMissing[i] {
+					y = append(y, row[c])
+					rows = append(rows, row)
+				}
+			}
+			m := trainModel(y, others(c), rows, false)
+			if m.Coeffs == nil {
+				continue
+			}
+			models[c] = m
+			fill(m, data, missing[c], c, others(c))
+		}
+	}
+
+	for iter := 0; iter < iterations; iter++ {
+		for _, c := range incomplete {
+			if m, ok := models[c]; ok {
+				fill(m, holdout, missingHoldout[c], c, others(c))
+			}
+		}
+	}
+	return filled
+}
+
+// applyNonFinite applies a -non-finite policy to the rows of data and
+// holdout after loading, as the loader does while parsing.
+func applyNonFinite(names []string, data, holdout [][]float64, policy string) ([][]float64, [][]float64, error) {
+	if policy == "keep" {
+		return data, holdout, nil
+	}
+	apply := func(rows [][]float64) ([][]float64, error) {
+		var kept [][]float64
+	rows:
+		for _, row := range rows {
+			for j, v := range row {
+				if math.IsNaN(v) || math.IsInf(v, 0) {
+					if policy == "drop" {
+						continue rows
+					}
+					return nil, fmt.Errorf("column %q is %v; use -non-finite drop or keep to accept such rows", names[j], v)
+				}
+			}
+			kept = append(kept, row)
+		}
+		return kept, nil
+	}
+	data, err := apply(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	holdout, err = apply(holdout)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(data) == 0 {
+		return nil, nil, errors.New("no rows left after dropping non-finite values")
+	}
+	return data, holdout, nil
+}
+
+// dropColumns returns names, data and holdout without the columns in drop.
+func dropColumns(names []string, data, holdout [][]float64, drop []int) ([]string, [][]float64, [][]float64) {
+	if len(drop) == 0 {
+		return names, data, holdout
+	}
+	dropped := make(map[int]bool)
+	for _, c := range drop {
+		dropped[c] = true
+	}
+	var keep []int
+	for j := range names {
+		if !dropped[j] {
+			keep = append(keep, j)
+		}
+	}
+	return featureNames(names, keep), projectColumns(data, keep), projectColumns(holdout, keep)
+}
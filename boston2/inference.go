@@ -0,0 +1,417 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// knockoffResult is the outcome of the knockoff filter: the statistic of
+// each feature, the data-dependent threshold and the features selected.
+type knockoffResult struct {
+	W         []float64
+	Threshold float64
+	Selected  []int
+}
+
+// knockoffFilter selects among the first numExplanatory columns of data
+// with model-X knockoffs, controlling the false discovery rate at fdr.
+//
+// Knockoff copies are drawn from the Gaussian equicorrelated construction,
+// with the covariance of the standardized columns estimated from the data:
+// given X, the knockoffs are normal with mean X - X inv(S) D and covariance
+// 2D - D inv(S) D, where S is the correlation matrix and D = s I for
+// s = min(1, 2 * smallest eigenvalue of S). Each feature's statistic is the
+// lasso entry penalty of the original minus that of its knockoff, fitted on
+// both together, and the knockoff+ threshold is applied to the statistics.
+// The guarantee holds as far as the columns are jointly Gaussian.
+func knockoffFilter(y []float64, data [][]float64, numExplanatory int, fdr float64, seed int64, block, workers int) (knockoffResult, error) {
+	r := standardize(y)
+	if r == nil {
+		return knockoffResult{}, errors.New("the response is constant")
+	}
+	p := numExplanatory
+	x := make([][]float64, p)
+	for j := range x {
+		if x[j] = standardize(column(data, j)); x[j] == nil {
+			return knockoffResult{}, fmt.Errorf("column %d is constant", j)
+		}
+	}
+
+	n := float64(len(y))
+	sigma := gramMatrix(x, block, workers)
+	for j := range sigma {
+		for k := range sigma[j] {
+			sigma[j][k] /= n
+		}
+	}
+	values, _ := symmetricEigen(sigma, nil)
+	smallest := values[0]
+	for _, v := range values {
+		smallest = math.Min(smallest, v)
+	}
+	// Shrink s slightly so that the knockoff covariance stays positive
+	// definite rather than singular.
+	sEqui := 0.999 * math.Min(1, 2*smallest)
+	if sEqui <= 0 {
+		return knockoffResult{}, errors.New("the columns are collinear")
+	}
+
+	l, err := cholesky(sigma)
+	if err != nil {
+		return knockoffResult{}, fmt.Errorf("failed to factor the correlation matrix: %v", err)
+	}
+	inverse := make([][]float64, p)
+	for j := range inverse {
+		e := make([]float64, p)
+		e[j] = 1
+		inverse[j] = solveCholesky(l, e)
+	}
+	cov := make([][]float64, p)
+	for j := range cov {
+		cov[j] = make([]float64, p)
+		for k := range cov[j] {
+			cov[j][k] = -sEqui * sEqui * inverse[j][k]
+		}
+		cov[j][j] += 2 * sEqui
+	}
+	c, err := cholesky(cov)
+	if err != nil {
+		return knockoffResult{}, fmt.Errorf("failed to factor the knockoff covariance: %v", err)
+	}
+
+	rng := rand.New(rand.NewSource(seed))
+	knockoffs := make([][]float64, p)
+	for j := range knockoffs {
+		knockoffs[j] = make([]float64, len(y))
+	}
+	row := make([]float64, p)
+	z := make([]float64, p)
+	for i := range y {
+		for j := range row {
+			row[j] = x[j][i]
+			z[j] = rng.NormFloat64()
+		}
+		for j := 0; j < p; j++ {
+			v := row[j] - sEqui*dot(inverse[j], row)
+			for k := 0; k <= j; k++ {
+				v += c[j][k] * z[k]
+			}
+			knockoffs[j][i] = v
+		}
+	}
+	for j := range knockoffs {
+		knockoffs[j] = standardize(knockoffs[j])
+	}
+
+	entry := lassoEntry(r, append(x, knockoffs...), nil)
+	res := knockoffResult{W: make([]float64, p), Threshold: math.Inf(1)}
+	var candidates []float64
+	for j := range res.W {
+		res.W[j] = entry[j] - entry[p+j]
+		if res.W[j] != 0 {
+			candidates = append(candidates, math.Abs(res.W[j]))
+		}
+	}
+	sort.Float64s(candidates)
+	for _, t := range candidates {
+		var positive, negative int
+		for _, w := range res.W {
+			if w >= t {
+				positive++
+			} else if w <= -t {
+				negative++
+			}
+		}
+		if float64(1+negative)/float64(positive) <= fdr {
+			res.Threshold = t
+			break
+		}
+	}
+	for j, w := range res.W {
+		if w >= res.Threshold {
+			res.Selected = append(res.Selected, j)
+		}
+	}
+	return res, nil
+}
+
+// splitEstimate is the inference on one coefficient of the model selected
+// by splitInference.
+type splitEstimate struct {
+	Feature      int
+	Coeff, SE    float64
+	Lower, Upper float64
+	P            float64
+}
+
+// splitInference makes inference that accounts for the selection step by
+// data splitting: the search runs on a random fraction of the rows, and
+// the model it selects is refitted by least squares on the remaining rows
+// alone. The selection is independent of those rows, so the usual
+// confidence intervals and p-values, at the 95% level by a normal
+// approximation, are valid for the selected model.
+func splitInference(y []float64, data [][]float64, numExplanatory int, opts searchOptions, fraction float64, seed int64) ([]splitEstimate, error) {
+	rows := rand.New(rand.NewSource(seed)).Perm(len(data))
+	cut := int(math.Round(fraction * float64(len(data))))
+	selectY, selectData := make([]float64, cut), make([][]float64, cut)
+	inferY, inferData := make([]float64, len(data)-cut), make([][]float64, len(data)-cut)
+	for i, r := range rows {
+		if i < cut {
+			selectY[i], selectData[i] = y[r], data[r]
+		} else {
+			inferY[i-cut], inferData[i-cut] = y[r], data[r]
+		}
+	}
+
+	features := bestOf(search(selectY, selectData, numExplanatory, opts.quiet())).Features
+	m := trainModel(inferY, features, inferData, opts.throughOrigin)
+	if m.Coeffs == nil && len(features) > 0 {
+		return nil, errors.New("failed to refit the selected model on the held-out rows")
+	}
+	se, err := standardErrors(inferY, inferData, features, m, opts.throughOrigin)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute standard errors: %v", err)
+	}
+
+	const z = 1.959964 // two-sided 95% normal quantile
+	estimates := make([]splitEstimate, len(features))
+	for i, idx := range features {
+		c := m.Coeffs[i]
+		estimates[i] = splitEstimate{
+			Feature: idx,
+			Coeff:   c,
+			SE:      se[i],
+			Lower:   c - z*se[i],
+			Upper:   c + z*se[i],
+			P:       math.Erfc(math.Abs(c/se[i]) / math.Sqrt2),
+		}
+	}
+	return estimates, nil
+}
+
+// inclusionResult is the outcome of gibbsInclusion.
+type inclusionResult struct {
+	Chains, Sweeps, BurnIn int
+	// Probability is each feature's posterior inclusion probability over
+	// all chains, and Spread the largest minus the smallest estimate from a
+	// single chain; a wide spread means the chains haven't converged.
+	Probability []float64
+	Spread      []float64
+	// Top is the most visited model and Share the fraction of counted
+	// sweeps spent in it.
+	Top   []int
+	Share float64
+}
+
+// gibbsInclusion samples subsets of the first numExplanatory features from
+// their posterior under gPrior with a uniform prior over subsets, by Gibbs
+// sampling each inclusion indicator in turn given the others. Unlike the
+// exhaustive search it visits subsets of every size. Chains run
+// concurrently, each from its own random start and seed, and each caches
+// the scores of the subsets it has fitted. Subsets that opts.filter rejects
+// are scored like failed fits, so chains never move into them. The
+// g-prior needs a least squares fit with independent errors.
+func gibbsInclusion(y []float64, data [][]float64, numExplanatory int, opts searchOptions, chains, sweeps, burnIn int, seed int64) inclusionResult {
+	opts.criterion = gPrior{}
+
+	type chainResult struct {
+		counts []int
+		visits map[string]int
+	}
+	results := make([]chainResult, chains)
+	done := make(chan struct{})
+	for c := 0; c < chains; c++ {
+		go func(c int) {
+			defer func() { done <- struct{}{} }()
+			rng := rand.New(rand.NewSource(seed + int64(c)))
+			scores := make(map[string]float64)
+			score := func(in []bool) float64 {
+				key := inclusionKey(in)
+				if s, ok := scores[key]; ok {
+					return s
+				}
+				var features []int
+				for j, ok := range in {
+					if ok {
+						features = append(features, j)
+					}
+				}
+				s := math.Inf(1)
+				if opts.filter == nil || opts.filter(features) {
+					if _, fs, err := fitModel(y, features, data, opts); err == nil {
+						s = fs
+					}
+				}
+				scores[key] = s
+				return s
+			}
+
+			in := make([]bool, numExplanatory)
+			for j := range in {
+				in[j] = rng.Intn(2) == 1
+			}
+			res := chainResult{counts: make([]int, numExplanatory), visits: make(map[string]int)}
+			for sweep := 0; sweep < sweeps; sweep++ {
+				for j := range in {
+					in[j] = false
+					without := score(in)
+					in[j] = true
+					with := score(in)
+					// Scores are -2 log marginal likelihoods, so the odds
+					// of inclusion are exp((without - with) / 2).
+					p := 1 / (1 + math.Exp((with-without)/2))
+					if math.IsNaN(p) {
+						p = 0
+					}
+					in[j] = rng.Float64() < p
+				}
+				if sweep < burnIn {
+					continue
+				}
+				for j, ok := range in {
+					if ok {
+						res.counts[j]++
+					}
+				}
+				res.visits[inclusionKey(in)]++
+			}
+			results[c] = res
+		}(c)
+	}
+	for c := 0; c < chains; c++ {
+		<-done
+	}
+
+	counted := sweeps - burnIn
+	r := inclusionResult{
+		Chains:      chains,
+		Sweeps:      sweeps,
+		BurnIn:      burnIn,
+		Probability: make([]float64, numExplanatory),
+		Spread:      make([]float64, numExplanatory),
+	}
+	for j := range r.Probability {
+		lo, hi := 1.0, 0.0
+		for _, res := range results {
+			p := float64(res.counts[j]) / float64(counted)
+			r.Probability[j] += p / float64(chains)
+			lo, hi = math.Min(lo, p), math.Max(hi, p)
+		}
+		r.Spread[j] = hi - lo
+	}
+	visits := make(map[string]int)
+	for _, res := range results {
+		for key, n := range res.visits {
+			visits[key] += n
+		}
+	}
+	var topKey string
+	for _, key := range sortedKeys(visits) {
+		if visits[key] > visits[topKey] {
+			topKey = key
+		}
+	}
+	for j := 0; j < len(topKey); j++ {
+		if topKey[j] == '1' {
+			r.Top = append(r.Top, j)
+		}
+	}
+	r.Share = float64(visits[topKey]) / float64(counted*chains)
+	return r
+}
+
+// inclusionKey encodes a set of inclusion indicators as a string of 0s and
+// 1s, for use as a map key.
+func inclusionKey(in []bool) string {
+	b := make([]byte, len(in))
+	for j, ok := range in {
+		b[j] = '0'
+		if ok {
+			b[j] = '1'
+		}
+	}
+	return string(b)
+}
+
+// permutationTest reruns the search on permutations copies of y, each
+// shuffled independently of the features, and returns the best score of
+// each: the criterion's null distribution when no feature is related to
+// the response. The permutations run concurrently, sharing opts.workers
+// goroutines, with the search's side outputs turned off. The columns must
+// not have been chosen or encoded using y, which shuffling would not undo.
+func permutationTest(y []float64, data [][]float64, numExplanatory int, opts searchOptions, permutations int, seed int64) []float64 {
+	opts = opts.quiet()
+
+	workers := opts.workers
+	opts.workers = 1
+
+	null := make([]float64, permutations)
+	forEachConcurrently(permutations, workers, func(p int) {
+		rng := rand.New(rand.NewSource(seed + int64(p) + 1))
+		shuffled := append([]float64(nil), y...)
+		rng.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+		null[p] = bestOf(search(shuffled, data, numExplanatory, opts)).Score
+	})
+	return null
+}
+
+// stabilitySelection reruns the search on subsamples random subsamples of
+// a fraction of the rows, drawn without replacement, and returns how often
+// each of the first numExplanatory features is in the best model. The
+// subsamples are searched concurrently, as by permutationTest, and as
+// there the columns must not have been chosen or encoded using y.
+func stabilitySelection(y []float64, data [][]float64, numExplanatory int, opts searchOptions, subsamples int, fraction float64, seed int64) []float64 {
+	opts = opts.quiet()
+	workers := opts.workers
+	opts.workers = 1
+
+	size := int(math.Round(fraction * float64(len(data))))
+	selected := make([][]int, subsamples)
+	forEachConcurrently(subsamples, workers, func(b int) {
+		rng := rand.New(rand.NewSource(seed + int64(b) + 1))
+		rows := rng.Perm(len(data))[:size]
+		subY := make([]float64, size)
+		subData := make([][]float64, size)
+		for i, r := range rows {
+			subY[i], subData[i] = y[r], data[r]
+		}
+		selected[b] = bestOf(search(subY, subData, numExplanatory, opts)).Features
+	})
+
+	freq := make([]float64, numExplanatory)
+	for _, features := range selected {
+		for _, j := range features {
+			freq[j]++
+		}
+	}
+	for j := range freq {
+		freq[j] /= float64(subsamples)
+	}
+	return freq
+}
+
+// writeStability writes the selection frequency of each feature, and
+// whether it is in the stable set, as CSV.
+func writeStability(path string, names []string, freq []float64, threshold float64) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"feature", "frequency", "stable"})
+	for j, v := range freq {
+		w.Write([]string{names[j], strconv.FormatFloat(v, 'g', -1, 64), strconv.FormatBool(v >= threshold)})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// TestGibbsInclusionRespectsFilter checks that the chains never visit a
+// subset the search's filter rejects.
+func TestGibbsInclusionRespectsFilter(t *testing.T) {
+	const numExplanatory = 8
+	y, data := syntheticProblem(rand.New(rand.NewSource(3)), 80, numExplanatory)
+	opts := searchOptions{criterion: gaussianAIC{}}
+
+	free := gibbsInclusion(y, data, numExplanatory, opts, 2, 200, 50, 1)
+	if free.Probability[3] < 0.9 {
+		t.Fatalf("feature 3 drives the response but has inclusion probability %.3f", free.Probability[3])
+	}
+
+	opts.filter = allFilters([]subsetFilter{
+		func(features []int) bool {
+			for _, f := range features {
+				if f == 3 {
+					return false
+				}
+			}
+			return true
+		},
+		sizeFilter(3),
+	})
+	r := gibbsInclusion(y, data, numExplanatory, opts, 2, 200, 50, 1)
+	if r.Probability[3] != 0 {
+		t.Errorf("filtered feature 3 has inclusion probability %.3f", r.Probability[3])
+	}
+	if len(r.Top) > 3 {
+		t.Errorf("most visited model %v is larger than the size filter allows", r.Top)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+// dot returns the dot product of x and the first len(x) values of y.
+//
+// dot and sumSquaredDiff are the inner loops of the Gram matrices, the
+// lasso and L0 paths and the residual sums of squares. On amd64 they run
+// as SSE2 assembly, elsewhere, or built with the purego tag, as the
+// unrolled Go loops below. Both add four running sums, of the values at
+// positions 0, 1, 2 and 3 mod 4, combine them as (s0+s2) + (s1+s3) and
+// then add the tail of fewer than four values, so on amd64 the two give
+// identical results.
+func dot(x, y []float64) float64 {
+	if len(y) < len(x) {
+		panic("dot: y is shorter than x")
+	}
+	return dotKernel(x, y)
+}
+
+// sumSquaredDiff returns the sum of (x[i] - y[i])^2 over x and the first
+// len(x) values of y.
+func sumSquaredDiff(x, y []float64) float64 {
+	if len(y) < len(x) {
+		panic("sumSquaredDiff: y is shorter than x")
+	}
+	return sumSquaredDiffKernel(x, y)
+}
+
+func dotGeneric(x, y []float64) float64 {
+	y = y[:len(x)]
+	var s0, s1, s2, s3 float64
+	i := 0
+	for ; i+4 <= len(x); i += 4 {
+		s0 += x[i] * y[i]
+		s1 += x[i+1] * y[i+1]
+		s2 += x[i+2] * y[i+2]
+		s3 += x[i+3] * y[i+3]
+	}
+	s := (s0 + s2) + (s1 + s3)
+	for ; i < len(x); i++ {
+		s += x[i] * y[i]
+	}
+	return s
+}
+
+func sumSquaredDiffGeneric(x, y []float64) float64 {
+	y = y[:len(x)]
+	var s0, s1, s2, s3 float64
+	i := 0
+	for ; i+4 <= len(x); i += 4 {
+		d0, d1, d2, d3 := x[i]-y[i], x[i+1]-y[i+1], x[i+2]-y[i+2], x[i+3]-y[i+3]
+		s0 += d0 * d0
+		s1 += d1 * d1
+		s2 += d2 * d2
+		s3 += d3 * d3
+	}
+	s := (s0 + s2) + (s1 + s3)
+	for ; i < len(x); i++ {
+		d := x[i] - y[i]
+		s += d * d
+	}
+	return s
+}
@@ -0,0 +1,12 @@
+//go:build !purego
+
+package main
+
+// Implemented in kernels_amd64.s. SSE2 is part of the amd64 baseline, so
+// there is no CPU feature check. Both need len(y) >= len(x).
+
+//go:noescape
+func dotKernel(x, y []float64) float64
+
+//go:noescape
+func sumSquaredDiffKernel(x, y []float64) float64
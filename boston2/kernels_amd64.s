@@ -0,0 +1,100 @@
+//go:build !purego
+
+#include "textflag.h"
+
+// func dotKernel(x, y []float64) float64
+//
+// X0 holds the running sums of positions 0 and 1 mod 4, X1 those of
+// positions 2 and 3, matching dotGeneric's s0, s1 and s2, s3.
+TEXT ·dotKernel(SB), NOSPLIT, $0-56
+	MOVQ x_base+0(FP), SI
+	MOVQ x_len+8(FP), CX
+	MOVQ y_base+24(FP), DI
+	XORPD X0, X0
+	XORPD X1, X1
+	MOVQ CX, BX
+	ANDQ $-4, BX
+	XORQ AX, AX
+	CMPQ BX, $0
+	JE   reduce
+
+loop:
+	MOVUPD (SI)(AX*8), X2
+	MOVUPD (DI)(AX*8), X3
+	MULPD  X3, X2
+	ADDPD  X2, X0
+	MOVUPD 16(SI)(AX*8), X4
+	MOVUPD 16(DI)(AX*8), X5
+	MULPD  X5, X4
+	ADDPD  X4, X1
+	ADDQ   $4, AX
+	CMPQ   AX, BX
+	JL     loop
+
+reduce:
+	// (s0+s2) + (s1+s3)
+	ADDPD    X1, X0
+	MOVAPD   X0, X1
+	UNPCKHPD X1, X1
+	ADDSD    X1, X0
+
+tail:
+	CMPQ  AX, CX
+	JGE   done
+	MOVSD (SI)(AX*8), X2
+	MULSD (DI)(AX*8), X2
+	ADDSD X2, X0
+	INCQ  AX
+	JMP   tail
+
+done:
+	MOVSD X0, ret+48(FP)
+	RET
+
+// func sumSquaredDiffKernel(x, y []float64) float64
+TEXT ·sumSquaredDiffKernel(SB), NOSPLIT, $0-56
+	MOVQ x_base+0(FP), SI
+	MOVQ x_len+8(FP), CX
+	MOVQ y_base+24(FP), DI
+	XORPD X0, X0
+	XORPD X1, X1
+	MOVQ CX, BX
+	ANDQ $-4, BX
+	XORQ AX, AX
+	CMPQ BX, $0
+	JE   reduce
+
+loop:
+	MOVUPD (SI)(AX*8), X2
+	MOVUPD (DI)(AX*8), X3
+	SUBPD  X3, X2
+	MULPD  X2, X2
+	ADDPD  X2, X0
+	MOVUPD 16(SI)(AX*8), X4
+	MOVUPD 16(DI)(AX*8), X5
+	SUBPD  X5, X4
+	MULPD  X4, X4
+	ADDPD  X4, X1
+	ADDQ   $4, AX
+	CMPQ   AX, BX
+	JL     loop
+
+reduce:
+	ADDPD    X1, X0
+	MOVAPD   X0, X1
+	UNPCKHPD X1, X1
+	ADDSD    X1, X0
+
+tail:
+	CMPQ  AX, CX
+	JGE   done
+	MOVSD (SI)(AX*8), X2
+	SUBSD (DI)(AX*8), X2
+	MULSD X2, X2
+	ADDSD X2, X0
+	INCQ  AX
+	JMP   tail
+
+done:
+	MOVSD X0, ret+48(FP)
+	RET
@@ -0,0 +1,7 @@
+//go:build !amd64 || purego
+
+package main
+
+func dotKernel(x, y []float64) float64 { return dotGeneric(x, y) }
+
+func sumSquaredDiffKernel(x, y []float64) float64 { return sumSquaredDiffGeneric(x, y) }
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// TestKernels checks the kernels against plain loops and, bit for bit,
+// against the generic Go versions, for every tail length and for inputs
+// that don't start on a 16-byte boundary.
+func TestKernels(t *testing.T) {
+	rng := rand.New(rand.NewSource(6))
+	buf := make([]float64, 200)
+	for i := range buf {
+		buf[i] = rng.NormFloat64() * 10
+	}
+	for n := 0; n <= 67; n++ {
+		for _, off := range []int{0, 1} {
+			x, y := buf[off:off+n], buf[100+off:100+off+n+3]
+
+			var wantDot, wantSSD float64
+			for i := range x {
+				wantDot += x[i] * y[i]
+				wantSSD += (x[i] - y[i]) * (x[i] - y[i])
+			}
+			scale := 1e-12 * (1 + float64(n)*100)
+			if got := dot(x, y); math.Abs(got-wantDot) > scale {
+				t.Errorf("dot of %d values at offset %d = %v, want %v", n, off, got, wantDot)
+			}
+			if got := sumSquaredDiff(x, y); math.Abs(got-wantSSD) > scale*10 {
+				t.Errorf("sumSquaredDiff of %d values at offset %d = %v, want %v", n, off, got, wantSSD)
+			}
+			if got, want := dot(x, y), dotGeneric(x, y); got != want {
+				t.Errorf("dot of %d values at offset %d = %v, generic loop gives %v", n, off, got, want)
+			}
+			if got, want := sumSquaredDiff(x, y), sumSquaredDiffGeneric(x, y); got != want {
+				t.Errorf("sumSquaredDiff of %d values at offset %d = %v, generic loop gives %v", n, off, got, want)
+			}
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("dot with a short y did not panic")
+		}
+	}()
+	dot(buf[:10], buf[:9])
+}
+
+// dotSimple is the plain loop the kernels replaced, for the benchmarks.
+func dotSimple(x, y []float64) float64 {
+	var s float64
+	for i := range x {
+		s += x[i] * y[i]
+	}
+	return s
+}
+
+func sumSquaredDiffSimple(x, y []float64) float64 {
+	var s float64
+	for i := range x {
+		d := x[i] - y[i]
+		s += d * d
+	}
+	return s
+}
+
+var kernelSink float64
+
+// BenchmarkKernels compares the kernels with the generic unrolled loops
+// and with the plain loops, on a fit-sized and a Gram-column-sized input.
+func BenchmarkKernels(b *testing.B) {
+	rng := rand.New(rand.NewSource(7))
+	for _, n := range []int{500, 100000} {
+		x, y := make([]float64, n), make([]float64, n)
+		for i := range x {
+			x[i], y[i] = rng.NormFloat64(), rng.NormFloat64()
+		}
+		for _, k := range []struct {
+			name string
+			f    func(x, y []float64) float64
+		}{
+			{"dot/kernel", dot},
+			{"dot/generic", dotGeneric},
+			{"dot/simple", dotSimple},
+			{"ssd/kernel", sumSquaredDiff},
+			{"ssd/generic", sumSquaredDiffGeneric},
+			{"ssd/simple", sumSquaredDiffSimple},
+		} {
+			b.Run(fmt.Sprintf("%s/n=%d", k.name, n), func(b *testing.B) {
+				b.SetBytes(int64(16 * n))
+				for i := 0; i < b.N; i++ {
+					kernelSink = k.f(x, y)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// l0Search is an alternative to search for problems with too many
+// features to enumerate. For each subset size it looks for the best subset
+// by iterative hard thresholding, the projected gradient method for least
+// squares under an L0 constraint, refined by local swaps, from starts
+// random initial subsets. The starts of every size run concurrently on
+// opts.workers goroutines. It returns the same per-size results as search,
+// but the subsets found are local optima with no guarantee of being the
+// best, and there are no fronts or leaderboards. When fewer than
+// sparseDensity of the entries are nonzero, as with many binned or hashed
+// indicator columns, the Gram matrix is computed from compressed rows.
+func l0Search(y []float64, data [][]float64, numExplanatory int, opts searchOptions, starts int, seed int64) []sizeResult {
+	const minSize = 4
+	if numExplanatory < minSize {
+		return nil
+	}
+
+	// Iterative hard thresholding only needs the Gram matrix and the
+	// correlations of the standardized columns with the response.
+	n := float64(len(y))
+	var mean float64
+	for _, v := range y {
+		mean += v / n
+	}
+	yc := make([]float64, len(y))
+	for i, v := range y {
+		yc[i] = v - mean
+	}
+	var gram [][]float64
+	var corr []float64
+	if nonzeroFraction(data, numExplanatory) < sparseDensity {
+		gram, corr = newCSR(data, numExplanatory).standardizedMoments(yc, opts.workers)
+	} else {
+		gram, corr = denseMoments(data, yc, numExplanatory, opts.gramBlock, opts.workers)
+	}
+	step := 1 / largestEigenvalue(gram)
+
+	sizes := numExplanatory - minSize + 1
+	found := make([]result, sizes*starts)
+	forEachConcurrently(len(found), opts.workers, func(t int) {
+		size := minSize + t/starts
+		rng := rand.New(rand.NewSource(seed + int64(t) + 1))
+		support := hardThreshold(gram, corr, rng.Perm(numExplanatory)[:size], step)
+		found[t] = swapSearch(y, data, support, numExplanatory, opts)
+	})
+
+	var all []sizeResult
+	for size := 0; size < sizes; size++ {
+		best := result{Score: math.Inf(1)}
+		for _, r := range found[size*starts : (size+1)*starts] {
+			if better(r, best) {
+				best = r
+			}
+		}
+		if best.Features != nil {
+			all = append(all, sizeResult{Best: best})
+		}
+	}
+	return all
+}
+
+// denseMoments returns the Gram matrix over n of the standardized first
+// numExplanatory columns of data and their inner products with the
+// centered response yc over n, with zeros for constant columns.
+func denseMoments(data [][]float64, yc []float64, numExplanatory, block, workers int) ([][]float64, []float64) {
+	n := float64(len(yc))
+	x := make([][]float64, numExplanatory)
+	for j := range x {
+		x[j] = standardize(column(data, j))
+	}
+	gram := gramMatrix(x, block, workers)
+	corr := make([]float64, numExplanatory)
+	for j := range gram {
+		for k := range gram[j] {
+			gram[j][k] /= n
+		}
+		if x[j] != nil {
+			corr[j] = dot(x[j], yc) / n
+		}
+	}
+	return gram, corr
+}
+
+// writeMIQP writes best subset selection of size features among the first
+// numExplanatory columns of data as a mixed-integer quadratic program in
+// CPLEX LP format, which most MIQP solvers read, so that an external solver
+// can prove the optimal subset.
+//
+// The columns are standardized, or with throughOrigin only scaled to unit
+// root mean square: coefficient b<j> is the coefficient of column j of the
+// original data times its scale, listed in the file's header. The objective
+// is the residual sum of squares up to a constant; binary z<j> is 1 if
+// column j is in the model, and the big-M constraints -M z<j> <= b<j> <= M
+// z<j> tie the two. M is twice the largest coefficient of the full model,
+// a common heuristic: a subset whose coefficients exceed it is missed.
+func writeMIQP(path string, names []string, y []float64, data [][]float64, numExplanatory, size int, throughOrigin bool, block, workers int) error {
+	p := numExplanatory
+	n := float64(len(y))
+	center := func(v []float64) ([]float64, float64) {
+		var mean float64
+		if !throughOrigin {
+			for _, x := range v {
+				mean += x / n
+			}
+		}
+		c := make([]float64, len(v))
+		for i, x := range v {
+			c[i] = x - mean
+		}
+		return c, mean
+	}
+
+	x := make([][]float64, p)
+	scale := make([]float64, p)
+	for j := range x {
+		x[j], _ = center(column(data, j))
+		scale[j] = math.Sqrt(dot(x[j], x[j]) / n)
+		if scale[j] == 0 {
+			return fmt.Errorf("column %s is constant", names[j])
+		}
+		for i := range x[j] {
+			x[j][i] /= scale[j]
+		}
+	}
+	yc, _ := center(y)
+
+	gram := gramMatrix(x, block, workers)
+	corr := make([]float64, p)
+	for j := range corr {
+		corr[j] = dot(x[j], yc)
+	}
+	full, err := solveSymmetric(gram, corr)
+	if err != nil {
+		return fmt.Errorf("failed to fit the full model for the big-M bound: %v", err)
+	}
+	var bigM float64
+	for _, b := range full {
+		bigM = math.Max(bigM, 2*math.Abs(b))
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "\\ Best subset selection of %d of %d features of %s\n", size, p, names[len(names)-1])
+	for j := range x {
+		fmt.Fprintf(&buf, "\\ b%d = coefficient of %s * %s\n", j+1, names[j], strconv.FormatFloat(scale[j], 'g', -1, 64))
+	}
+	// Some readers limit the length of a line, so long expressions are
+	// written a few terms per line.
+	writeTerms := func(terms []string) {
+		for i, t := range terms {
+			if i > 0 && i%6 == 0 {
+				buf.WriteString("\n   ")
+			}
+			buf.WriteString(" " + t)
+		}
+	}
+	var linear, quadratic []string
+	for j, c := range corr {
+		linear = append(linear, fmt.Sprintf("%+g b%d", -2*c, j+1))
+	}
+	for j := range gram {
+		quadratic = append(quadratic, fmt.Sprintf("%+g b%d ^ 2", 2*gram[j][j], j+1))
+		for k := j + 1; k < p; k++ {
+			quadratic = append(quadratic, fmt.Sprintf("%+g b%d * b%d", 4*gram[j][k], j+1, k+1))
+		}
+	}
+	fmt.Fprintln(&buf, "Minimize")
+	fmt.Fprint(&buf, " obj:")
+	writeTerms(linear)
+	// LP format halves the bracketed quadratic terms.
+	fmt.Fprint(&buf, "\n    + [")
+	writeTerms(quadratic)
+	fmt.Fprintln(&buf, " ] / 2")
+
+	fmt.Fprintln(&buf, "Subject To")
+	fmt.Fprint(&buf, " size:")
+	var indicators []string
+	for j := range x {
+		indicators = append(indicators, fmt.Sprintf("+ z%d", j+1))
+	}
+	writeTerms(indicators)
+	fmt.Fprintf(&buf, " <= %d\n", size)
+	for j := range x {
+		fmt.Fprintf(&buf, " upper%d: b%d - %g z%d <= 0\n", j+1, j+1, bigM, j+1)
+		fmt.Fprintf(&buf, " lower%d: b%d + %g z%d >= 0\n", j+1, j+1, bigM, j+1)
+	}
+	fmt.Fprintln(&buf, "Bounds")
+	for j := range x {
+		fmt.Fprintf(&buf, " b%d free\n", j+1)
+	}
+	fmt.Fprintln(&buf, "Binaries")
+	for j := range x {
+		fmt.Fprintf(&buf, " z%d\n", j+1)
+	}
+	fmt.Fprintln(&buf, "End")
+	return os.WriteFile(path, buf.Bytes(), 0o644)
+}
+
+// hardThreshold runs iterative hard thresholding for least squares on
+// standardized columns with Gram matrix gram and response correlations
+// corr, keeping len(start) coefficients and starting from the fit on the
+// columns in start. It returns the columns of the final support in
+// increasing order.
+func hardThreshold(gram [][]float64, corr []float64, start []int, step float64) []int {
+	const maxIterations = 200
+	k := len(start)
+	beta := make([]float64, len(corr))
+	support := append([]int(nil), start...)
+	sort.Ints(support)
+	polish(gram, corr, support, beta)
+
+	for iter := 0; iter < maxIterations; iter++ {
+		// A gradient step on the residual sum of squares, then keep the
+		// k largest coefficients.
+		next := make([]float64, len(beta))
+		for j := range next {
+			g := corr[j]
+			for l, b := range beta {
+				g -= gram[j][l] * b
+			}
+			next[j] = beta[j] + step*g
+		}
+		order := make([]int, len(next))
+		for j := range order {
+			order[j] = j
+		}
+		sort.SliceStable(order, func(a, b int) bool { return math.Abs(next[order[a]]) > math.Abs(next[order[b]]) })
+		kept := append([]int(nil), order[:k]...)
+		sort.Ints(kept)
+
+		same := true
+		for i := range kept {
+			if kept[i] != support[i] {
+				same = false
+			}
+		}
+		for j := range beta {
+			beta[j] = 0
+		}
+		for _, j := range kept {
+			beta[j] = next[j]
+		}
+		support = kept
+		if same {
+			break
+		}
+		polish(gram, corr, support, beta)
+	}
+	return support
+}
+
+// polish sets the coefficients of beta on support to their least squares
+// values and the rest to zero. beta is left as it was if the columns of
+// support are collinear.
+func polish(gram [][]float64, corr []float64, support []int, beta []float64) {
+	a := make([][]float64, len(support))
+	b := make([]float64, len(support))
+	for i, j := range support {
+		a[i] = make([]float64, len(support))
+		for l, k := range support {
+			a[i][l] = gram[j][k]
+		}
+		b[i] = corr[j]
+	}
+	coeffs, err := solveSymmetric(a, b)
+	if err != nil {
+		return
+	}
+	for j := range beta {
+		beta[j] = 0
+	}
+	for i, j := range support {
+		beta[j] = coeffs[i]
+	}
+}
+
+// swapSearch improves a subset by local search: it repeatedly replaces one
+// feature with one outside the subset while that improves the criterion,
+// and returns the best model reached. Subsets rejected by opts.filter are
+// skipped.
+func swapSearch(y []float64, data [][]float64, features []int, numExplanatory int, opts searchOptions) result {
+	evaluate := func(features []int) result {
+		if opts.filter != nil && !opts.filter(features) {
+			return result{Score: math.Inf(1)}
+		}
+		mse, score, err := fitModel(y, features, data, opts)
+		if err != nil {
+			return result{Score: math.Inf(1)}
+		}
+		return result{Features: features, Score: score, MSE: mse}
+	}
+
+	best := evaluate(features)
+	current := features
+	for improved := true; improved; {
+		improved = false
+		in := make(map[int]bool, len(current))
+		for _, j := range current {
+			in[j] = true
+		}
+	swaps:
+		for i := range current {
+			for l := 0; l < numExplanatory; l++ {
+				if in[l] {
+					continue
+				}
+				candidate := append([]int(nil), current...)
+				candidate[i] = l
+				sort.Ints(candidate)
+				if r := evaluate(candidate); better(r, best) {
+					best, current, improved = r, candidate, true
+					break swaps
+				}
+			}
+		}
+	}
+	return best
+}
+
+// gramMatrix returns the matrix of cross products of columns, x'x, or
+// zero for a nil column. The matrix is computed in tiles of block by block
+// columns, and each tile is accumulated over chunks of rows sized so that
+// the chunks of its columns fit together in a typical 256 KiB L2 cache.
+// Tiles on or above the diagonal are computed concurrently on workers
+// goroutines and mirrored below it.
+func gramMatrix(x [][]float64, block, workers int) [][]float64 {
+	const cacheFloats = 256 << 10 / 8
+	p := len(x)
+	g := make([][]float64, p)
+	for j := range g {
+		g[j] = make([]float64, p)
+	}
+	if block < 1 {
+		block = 1
+	}
+	var n int
+	for _, c := range x {
+		if c != nil {
+			n = len(c)
+			break
+		}
+	}
+	chunk := cacheFloats / (2 * block)
+	if chunk < 1 {
+		chunk = 1
+	}
+
+	type tile struct{ i0, j0 int }
+	var tiles []tile
+	for i0 := 0; i0 < p; i0 += block {
+		for j0 := i0; j0 < p; j0 += block {
+			tiles = append(tiles, tile{i0, j0})
+		}
+	}
+	// Each tile writes its own entries of g, so the tiles need no locking.
+	forEachConcurrently(len(tiles), workers, func(t int) {
+		i0, j0 := tiles[t].i0, tiles[t].j0
+		i1, j1 := min(i0+block, p), min(j0+block, p)
+		for r0 := 0; r0 < n; r0 += chunk {
+			r1 := min(r0+chunk, n)
+			for i := i0; i < i1; i++ {
+				if x[i] == nil {
+					continue
+				}
+				xi := x[i][r0:r1]
+				for j := max(j0, i); j < j1; j++ {
+					if x[j] != nil {
+						g[i][j] += dot(xi, x[j][r0:r1])
+					}
+				}
+			}
+		}
+	})
+	for i := range g {
+		for j := 0; j < i; j++ {
+			g[i][j] = g[j][i]
+		}
+	}
+	return g
+}
+
+// largestEigenvalue returns the largest eigenvalue of a symmetric positive
+// semi-definite matrix by power iteration.
+func largestEigenvalue(a [][]float64) float64 {
+	v := make([]float64, len(a))
+	for i := range v {
+		v[i] = 1
+	}
+	var lambda float64
+	for iter := 0; iter < 1000; iter++ {
+		w := make([]float64, len(a))
+		for i := range a {
+			w[i] = dot(a[i], v)
+		}
+		norm := math.Sqrt(dot(w, w))
+		if norm == 0 {
+			return 1
+		}
+		for i := range w {
+			w[i] /= norm
+		}
+		v = w
+		if math.Abs(norm-lambda) <= 1e-10*norm {
+			return norm
+		}
+		lambda = norm
+	}
+	return lambda
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestWriteMIQP writes a small problem and checks the sections of the LP
+// file, the size constraint, and that the big-M bound is twice the largest
+// full-model coefficient on the scale of the program's variables.
+func TestWriteMIQP(t *testing.T) {
+	const numExplanatory, size = 4, 2
+	y, data := syntheticProblem(rand.New(rand.NewSource(2)), 40, numExplanatory)
+	names := []string{"x1", "x2", "x3", "x4", "y"}
+	path := filepath.Join(t.TempDir(), "best.lp")
+	if err := writeMIQP(path, names, y, data, numExplanatory, size, false, 2, 1); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lp := string(b)
+
+	last := -1
+	for _, section := range []string{"\nMinimize\n", "\nSubject To\n", "\nBounds\n", "\nBinaries\n", "\nEnd\n"} {
+		i := strings.Index(lp, section)
+		if i <= last {
+			t.Fatalf("section %q missing or out of order in\n%s", strings.TrimSpace(section), lp)
+		}
+		last = i
+	}
+	if !strings.HasSuffix(lp, "End\n") {
+		t.Error("the file doesn't end with End")
+	}
+	if !strings.Contains(lp, fmt.Sprintf("<= %d\n", size)) {
+		t.Errorf("no size constraint <= %d in\n%s", size, lp)
+	}
+	for j := 1; j <= numExplanatory; j++ {
+		for _, want := range []string{fmt.Sprintf(" b%d free\n", j), fmt.Sprintf(" z%d\n", j)} {
+			if !strings.Contains(lp, want) {
+				t.Errorf("missing %q", strings.TrimSpace(want))
+			}
+		}
+	}
+
+	// The header gives each column's scale; the full fit's coefficients
+	// times their scales are the program's b<j>.
+	full := trainModel(y, allFeatures(numExplanatory), data, false)
+	var wantM float64
+	for j, c := range full.Coeffs {
+		prefix := fmt.Sprintf("\\ b%d = coefficient of %s * ", j+1, names[j])
+		i := strings.Index(lp, prefix)
+		if i < 0 {
+			t.Fatalf("no scale for %s in the header", names[j])
+		}
+		line := lp[i+len(prefix):]
+		scale, err := strconv.ParseFloat(line[:strings.IndexByte(line, '\n')], 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantM = math.Max(wantM, 2*math.Abs(c*scale))
+	}
+	for j := 1; j <= numExplanatory; j++ {
+		var m float64
+		prefix := fmt.Sprintf(" upper%d: b%d - ", j, j)
+		i := strings.Index(lp, prefix)
+		if i < 0 {
+			t.Fatalf("no upper bound constraint for b%d", j)
+		}
+		if _, err := fmt.Sscanf(lp[i+len(prefix):], "%g", &m); err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(m-wantM) > 1e-4*wantM {
+			t.Errorf("big-M of b%d is %g, want %g", j, m, wantM)
+		}
+		if !strings.Contains(lp, fmt.Sprintf(" lower%d: b%d + %g z%d >= 0\n", j, j, m, j)) {
+			t.Errorf("no matching lower bound constraint for b%d", j)
+		}
+	}
+}
+
+// TestL0SearchMatchesExhaustive checks that on a problem small enough to
+// enumerate, l0Search finds the best subset of every size, with and
+// without a filter, and that it never returns a subset the filter rejects.
+func TestL0SearchMatchesExhaustive(t *testing.T) {
+	const numExplanatory = 8
+	y, data := syntheticProblem(rand.New(rand.NewSource(4)), 100, numExplanatory)
+	names := []string{"x0", "x1", "x2", "x3", "x4", "x5", "x6", "x7", "y"}
+	// x3 has the largest effect, so excluding it changes the answers.
+	noX3, err := excludeFilter(names[:numExplanatory], [][]string{{"x3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, filter := range []subsetFilter{nil, noX3} {
+		opts := searchOptions{criterion: gaussianAIC{}, workers: 2, unitSize: 16, filter: filter}
+		want := search(y, data, numExplanatory, opts)
+		got := l0Search(y, data, numExplanatory, opts, 6, 1)
+		if len(got) != len(want) {
+			t.Fatalf("filter %v: %d sizes, want %d", filter != nil, len(got), len(want))
+		}
+		for i := range want {
+			g, w := got[i].Best, want[i].Best
+			if fmt.Sprint(g.Features) != fmt.Sprint(w.Features) {
+				t.Errorf("filter %v: best of size %d is %v, want %v", filter != nil, len(w.Features), g.Features, w.Features)
+			}
+			if filter != nil && !filter(g.Features) {
+				t.Errorf("l0Search returned %v, which the filter rejects", g.Features)
+			}
+		}
+	}
+}
+
+// TestPoorStart checks that hard thresholding and local swaps each climb
+// from the worst start, the features without an effect, to the best subset
+// of its size.
+func TestPoorStart(t *testing.T) {
+	const numExplanatory = 8
+	y, data := syntheticProblem(rand.New(rand.NewSource(4)), 100, numExplanatory)
+	opts := searchOptions{criterion: gaussianAIC{}, workers: 1, unitSize: 16}
+	want := search(y, data, numExplanatory, opts)[0].Best
+	start := []int{4, 5, 6, 7}
+
+	got := swapSearch(y, data, start, numExplanatory, opts)
+	if fmt.Sprint(got.Features) != fmt.Sprint(want.Features) || math.Abs(got.Score-want.Score) > 1e-9 {
+		t.Errorf("swapSearch reached %v (%.4f), want %v (%.4f)", got.Features, got.Score, want.Features, want.Score)
+	}
+
+	var mean float64
+	for _, v := range y {
+		mean += v / float64(len(y))
+	}
+	yc := make([]float64, len(y))
+	for i, v := range y {
+		yc[i] = v - mean
+	}
+	gram, corr := denseMoments(data, yc, numExplanatory, 4, 1)
+	support := hardThreshold(gram, corr, start, 1/largestEigenvalue(gram))
+	if fmt.Sprint(support) != fmt.Sprint(want.Features) {
+		t.Errorf("hardThreshold reached %v, want %v", support, want.Features)
+	}
+}
@@ -0,0 +1,1001 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	_ "embed"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cc1358/Week-6-Assignment-Exploring-Concurrency/dataset"
+	"github.com/klauspost/compress/zstd"
+)
+
+// loadFlags are the flags choosing and shaping the input, shared by the
+// search and the describe subcommand so both load a file the same way.
+type loadFlags struct {
+	input, format, sheet, long *string
+	join, joinKey, joinType    *string
+	columns, where             *string
+	workers, retries           *int
+	retryDelay                 *time.Duration
+	partialRead, nonFinite     *string
+}
+
+func addLoadFlags(fs *flag.FlagSet) *loadFlags {
+	return &loadFlags{
+		input:       fs.String("input", "housing1.csv", "input file or http(s) URL, optionally compressed with gzip (.gz) or zstd (.zst), or - for stdin"),
+		format:      fs.String("input-format", "auto", "input format: csv, tsv, jsonl, xlsx or auto to detect from the file name"),
+		sheet:       fs.String("sheet", "", "worksheet to read from an xlsx file (default: the first)"),
+		long:        fs.String("long", "", "the input is in long format with these comma-separated id, variable and value columns, e.g. id,variable,value; it is pivoted to one row per id, with -columns ordering the variables"),
+		join:        fs.String("join", "", "merge the columns of this CSV file onto the input by -join-key"),
+		joinKey:     fs.String("join-key", "neighborhood", "key column present in both the input and the -join file"),
+		joinType:    fs.String("join-type", "inner", "join semantics: inner keeps only the input rows with a match in -join; left keeps every row, with NaN in the joined fields of the unmatched ones, so it needs -impute to fill them or -non-finite drop or keep"),
+		columns:     fs.String("columns", "", "comma-separated columns to load, response last (default: all but the first)"),
+		where:       fs.String("where", "", `keep only rows matching this expression, e.g. "tax < 500 && chas == 0"`),
+		workers:     fs.Int("parse-workers", 1, "number of goroutines parsing a CSV or TSV file"),
+		retries:     fs.Int("retries", 3, "times to retry a failed download of an http(s) -input"),
+		retryDelay:  fs.Duration("retry-delay", time.Second, "initial wait before retrying a download; doubled, with jitter, on each retry"),
+		partialRead: fs.String("partial-read", "fail", "when every download attempt fails: fail, or warn and use the complete lines received"),
+		nonFinite:   fs.String("non-finite", "fail", "rows with NaN or Inf values: fail, drop or keep"),
+	}
+}
+
+// options checks the flags and returns the loadOptions they describe, with
+// the derived, categorical, timestamp and number settings of cfg. group, if
+// set, is a column loaded as categorical for a random intercept. missing
+// loads missing values as NaN for imputation, whatever -non-finite says.
+func (f *loadFlags) options(cfg runConfig, group string, missing bool) (loadOptions, error) {
+	switch *f.nonFinite {
+	case "fail", "drop", "keep":
+	default:
+		return loadOptions{}, fmt.Errorf("unknown non-finite policy %q", *f.nonFinite)
+	}
+	if *f.partialRead != "fail" && *f.partialRead != "warn" {
+		return loadOptions{}, fmt.Errorf("unknown partial read policy %q", *f.partialRead)
+	}
+	long, err := parseLongFormat(*f.long)
+	if err != nil {
+		return loadOptions{}, err
+	}
+	var join *joinSpec
+	if *f.join != "" {
+		if *f.joinType != "left" && *f.joinType != "inner" {
+			return loadOptions{}, fmt.Errorf("unknown -join-type %q: want left or inner", *f.joinType)
+		}
+		join = &joinSpec{Path: *f.join, Key: *f.joinKey, Type: *f.joinType}
+		if join.Type == "left" && !missing && *f.nonFinite == "fail" {
+			return loadOptions{}, fmt.Errorf("-join-type left leaves NaN in the joined fields of unmatched rows, which -non-finite fail rejects")
+		}
+	}
+	nonFinite := *f.nonFinite
+	if missing {
+		nonFinite = "keep"
+	}
+	return loadOptions{
+		format:      *f.format,
+		workers:     *f.workers,
+		columns:     splitList(*f.columns),
+		where:       *f.where,
+		derived:     cfg.Derived,
+		categorical: categoricalColumns(cfg, group),
+		dates:       cfg.Dates,
+		numbers:     cfg.Numbers,
+		nonFinite:   nonFinite,
+		missing:     missing,
+		fetch:       fetchOptions{retries: *f.retries, retryDelay: *f.retryDelay, partial: *f.partialRead},
+		sheet:       *f.sheet,
+		long:        long,
+		join:        join,
+	}, nil
+}
+
+// loadOptions controls how readData reads the input file.
+type loadOptions struct {
+	// format is "csv", "tsv", "jsonl" or "auto" to pick one from the file
+	// extension.
+	format string
+	// workers is the number of goroutines parsing a delimited file.
+	workers int
+	// columns, if set, names the numeric columns to load, in order; the
+	// last one is the response.
+	columns []string
+	// where, if set, is a boolean expression over the input columns; rows
+	// for which it is false are dropped while loading.
+	where string
+	// derived maps the names of extra explanatory columns to expressions
+	// over the input columns.
+	derived map[string]string
+	// categorical names input columns loaded, after the derived columns,
+	// as level codes: the 32-bit FNV-1a hash of the text. The codes only
+	// identify levels and are meant to be target encoded.
+	categorical []string
+	// dates maps timestamp columns to the calendar features loaded from
+	// them, after the categorical columns.
+	dates map[string]dateSpec
+	// numbers is the locale of the numeric fields.
+	numbers numberFormat
+	// nonFinite is what to do with a row holding NaN or an infinity, in the
+	// input or computed by a derived column: "fail" (the default), "drop"
+	// the row, or "keep" it.
+	nonFinite string
+	// fetch controls how an http:// or https:// input is downloaded.
+	fetch fetchOptions
+	// sheet names the worksheet of an xlsx file; empty means the first.
+	sheet string
+	// missing reads empty, NA and N/A fields of the numeric columns as
+	// NaN, for imputation, instead of failing to parse them.
+	missing bool
+	// long, if set, says the input is in long format and names its
+	// columns; see pivotWider.
+	long *longFormat
+	// join, if set, merges the columns of a second CSV file onto the
+	// input; see joinRecords.
+	join *joinSpec
+}
+
+// joinSpec describes a merge of an auxiliary CSV file onto the input by a
+// key column present in both.
+type joinSpec struct {
+	Path string
+	Key  string
+	// Type is "left", to keep every input row, or "inner", to keep only
+	// the rows whose key is in the auxiliary file.
+	Type string
+}
+
+// joinRecords merges the auxiliary file of join onto records by the key
+// column. Its columns other than the key go just before the last column,
+// the response, so the default column selection loads them as explanatory
+// columns. An auxiliary column named like an input column is renamed with
+// the file's base name as a suffix, e.g. rooms_demographics. With a left
+// join, the auxiliary fields of an unmatched row are NaN, for imputation
+// or -non-finite drop or keep; loadFlags.options rejects a left join that
+// would fail on them. A key repeated in the auxiliary file is an error.
+func joinRecords(header []string, records [][]string, join joinSpec) ([]string, [][]string, error) {
+	key := -1
+	for i, name := range header {
+		if name == join.Key {
+			key = i
+		}
+	}
+	if key < 0 {
+		return nil, nil, fmt.Errorf("input has no join key column %q", join.Key)
+	}
+
+	f, err := os.Open(join.Path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open join file: %v", err)
+	}
+	defer f.Close()
+	aux, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read join file %s: %v", join.Path, err)
+	}
+	if len(aux) == 0 {
+		return nil, nil, fmt.Errorf("join file %s is empty", join.Path)
+	}
+	auxKey := -1
+	for i, name := range aux[0] {
+		if name == join.Key {
+			auxKey = i
+		}
+	}
+	if auxKey < 0 {
+		return nil, nil, fmt.Errorf("join file %s has no key column %q", join.Path, join.Key)
+	}
+
+	taken := make(map[string]bool)
+	for _, name := range header {
+		taken[name] = true
+	}
+	suffix := strings.TrimSuffix(filepath.Base(join.Path), filepath.Ext(join.Path))
+	var added []string
+	var auxCols []int
+	for i, name := range aux[0] {
+		if i == auxKey {
+			continue
+		}
+		renamed := name
+		if taken[renamed] {
+			renamed = name + "_" + suffix
+			for n := 2; taken[renamed]; n++ {
+				renamed = fmt.Sprintf("%s_%s%d", name, suffix, n)
+			}
+		}
+		taken[renamed] = true
+		added = append(added, renamed)
+		auxCols = append(auxCols, i)
+	}
+
+	byKey := make(map[string][]string, len(aux)-1)
+	for line, row := range aux[1:] {
+		k := row[auxKey]
+		if _, dup := byKey[k]; dup {
+			return nil, nil, fmt.Errorf("join file %s row %d repeats key %q", join.Path, line+2, k)
+		}
+		byKey[k] = row
+	}
+
+	last := len(header) - 1
+	joined := append(append(append([]string(nil), header[:last]...), added...), header[last])
+	var out [][]string
+	for _, record := range records {
+		extra := make([]string, len(auxCols))
+		row, ok := byKey[record[key]]
+		if !ok && join.Type == "inner" {
+			continue
+		}
+		for i, c := range auxCols {
+			if ok {
+				extra[i] = row[c]
+			} else {
+				extra[i] = "NaN"
+			}
+		}
+		merged := append(append(append([]string(nil), record[:last]...), extra...), record[last])
+		out = append(out, merged)
+	}
+	return joined, out, nil
+}
+
+// longFormat names the columns of a long-format dataset: one row per
+// observation and variable, holding the observation's id, the variable's
+// name and its value.
+type longFormat struct {
+	ID, Variable, Value string
+}
+
+// parseLongFormat parses the -long flag: the id, variable and value column
+// names, separated by commas. An empty spec means the input is wide.
+func parseLongFormat(spec string) (*longFormat, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	parts := splitList(spec)
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("-long wants id,variable,value column names, got %q", spec)
+	}
+	return &longFormat{ID: parts[0], Variable: parts[1], Value: parts[2]}, nil
+}
+
+// pivotWider turns long-format records into one record per id under a
+// header of the id column followed by the variables, in the order each id
+// and variable first appears. The id column takes the place of the
+// skipped first column of a wide file. A variable missing for an id is an
+// error, as is a repeated id and variable pair.
+func pivotWider(header []string, records [][]string, long longFormat) ([]string, [][]string, error) {
+	col := make(map[string]int)
+	for i, name := range header {
+		col[name] = i
+	}
+	var idx [3]int
+	for i, name := range []string{long.ID, long.Variable, long.Value} {
+		j, ok := col[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("long-format input has no column %q", name)
+		}
+		idx[i] = j
+	}
+
+	var ids, variables []string
+	rowOf := make(map[string]int)
+	colOf := make(map[string]int)
+	var cells []map[int]string
+	for line, record := range records {
+		id, variable, value := record[idx[0]], record[idx[1]], record[idx[2]]
+		r, ok := rowOf[id]
+		if !ok {
+			r = len(ids)
+			rowOf[id] = r
+			ids = append(ids, id)
+			cells = append(cells, make(map[int]string))
+		}
+		c, ok := colOf[variable]
+		if !ok {
+			c = len(variables)
+			colOf[variable] = c
+			variables = append(variables, variable)
+		}
+		if _, dup := cells[r][c]; dup {
+			return nil, nil, fmt.Errorf("long-format row %d repeats %s %q, %s %q", line+2, long.ID, id, long.Variable, variable)
+		}
+		cells[r][c] = value
+	}
+
+	wide := make([][]string, len(ids))
+	for r, id := range ids {
+		wide[r] = make([]string, len(variables)+1)
+		wide[r][0] = id
+		for c, variable := range variables {
+			v, ok := cells[r][c]
+			if !ok {
+				return nil, nil, fmt.Errorf("long-format input has no %s for %s %q", variable, long.ID, id)
+			}
+			wide[r][c+1] = v
+		}
+	}
+	return append([]string{long.ID}, variables...), wide, nil
+}
+
+// fetchOptions is the retry and partial-failure policy for remote inputs.
+type fetchOptions struct {
+	// retries is how many times a failed download is retried, waiting
+	// about retryDelay, doubled after each attempt and jittered, between
+	// attempts.
+	retries    int
+	retryDelay time.Duration
+	// partial is "fail" to give up when every attempt fails, or "warn" to
+	// go on, with a warning, with the complete lines of the longest
+	// partial download.
+	partial string
+}
+
+// fetchURL downloads url, retrying network errors, server errors and 429
+// responses according to opts.
+func fetchURL(url string, opts fetchOptions) ([]byte, error) {
+	client := &http.Client{Timeout: 5 * time.Minute}
+	delay := opts.retryDelay
+	var partial []byte
+	var lastErr error
+	for attempt := 0; attempt <= opts.retries; attempt++ {
+		if attempt > 0 {
+			// Sleep between half and all of the current delay so that
+			// scheduled jobs retrying together spread out.
+			wait := delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+			log.Printf("failed to fetch %s: %v; retrying in %v", url, lastErr, wait)
+			time.Sleep(wait)
+			delay *= 2
+		}
+
+		body, retry, err := fetchOnce(client, url)
+		if err == nil {
+			return body, nil
+		}
+		if len(body) > len(partial) {
+			partial = body
+		}
+		lastErr = err
+		if !retry {
+			break
+		}
+	}
+
+	if opts.partial == "warn" && len(partial) > 0 {
+		if i := bytes.LastIndexByte(partial, '\n'); i >= 0 {
+			log.Printf("warning: using the first %d bytes of %s after: %v", i+1, url, lastErr)
+			return partial[:i+1], nil
+		}
+	}
+	return nil, fmt.Errorf("failed to fetch %s: %v", url, lastErr)
+}
+
+// fetchOnce makes one attempt at downloading url. It returns whatever body
+// was read before any error, and whether the error is worth retrying.
+func fetchOnce(client *http.Client, url string) (body []byte, retry bool, err error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		retry = resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return nil, retry, errors.New(resp.Status)
+	}
+	body, err = io.ReadAll(resp.Body)
+	return body, true, err
+}
+
+// demoData is a synthetic 120-row sample with the columns of housing1.csv,
+// so -demo runs the whole pipeline without any input file.
+//
+//go:embed demo.csv
+var demoData string
+
+// demoInput is the input path that stands for demoData.
+const demoInput = "<demo>"
+
+// readData reads a dataset whose first column (neighborhood) is skipped and
+// whose remaining columns are numeric, the last being the response. It
+// returns the names of the numeric columns and one row per observation.
+// The path may be an http:// or https:// URL, downloaded according to
+// opts.fetch. Files ending in .gz or .zst are decompressed on the fly. With more than
+// one worker an uncompressed CSV or TSV file's rows are parsed concurrently.
+func readData(path string, opts loadOptions) ([]string, [][]float64, error) {
+	var (
+		err    error
+		file   *os.File
+		src    io.Reader
+		format = opts.format
+	)
+
+	// Compressed streams and stdin can't be split into byte ranges, so they
+	// are always parsed by a single goroutine.
+	workers := opts.workers
+	if path == demoInput {
+		src, workers, path = strings.NewReader(demoData), 1, "demo.csv"
+	} else if path == "-" {
+		// Without a file name, compression and format are sniffed from the
+		// first bytes of the stream.
+		stdin := bufio.NewReader(os.Stdin)
+		magic, _ := stdin.Peek(4)
+		switch {
+		case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+			path = "-.gz"
+		case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+			path = "-.zst"
+		}
+		src, workers = stdin, 1
+	} else if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		body, err := fetchURL(path, opts.fetch)
+		if err != nil {
+			return nil, nil, err
+		}
+		// Drop any query so the extension still names the compression.
+		if i := strings.IndexAny(path, "?#"); i >= 0 {
+			path = path[:i]
+		}
+		src, workers = bytes.NewReader(body), 1
+	} else {
+		file, err = os.Open(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open file: %v", err)
+		}
+		defer file.Close()
+		src = file
+	}
+
+	switch {
+	case strings.HasSuffix(path, ".gz"):
+		gz, err := gzip.NewReader(src)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open gzip stream: %v", err)
+		}
+		defer gz.Close()
+		src, workers = gz, 1
+	case strings.HasSuffix(path, ".zst"):
+		zr, err := zstd.NewReader(src)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open zstd stream: %v", err)
+		}
+		defer zr.Close()
+		src, workers = zr, 1
+	}
+
+	if format == "auto" {
+		if file != nil {
+			format = detectFormat(path)
+		} else {
+			buffered := bufio.NewReader(src)
+			format = sniffFormat(buffered)
+			src = buffered
+		}
+	}
+
+	var (
+		header  []string
+		names   []string
+		parse   rowParser
+		data    [][]float64
+		records [][]string
+	)
+	// Formats read whole, and long-format input of any format, are parsed
+	// from records after the switch.
+	whole := opts.long != nil || opts.join != nil
+	switch format {
+	case "csv", "tsv":
+		reader := csv.NewReader(src)
+		if format == "tsv" {
+			reader.Comma = '\t'
+		}
+
+		header, err = reader.Read()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read header: %v", err)
+		}
+		header = append([]string(nil), header...)
+		if whole {
+			if records, err = reader.ReadAll(); err != nil {
+				return nil, nil, fmt.Errorf("failed to read %s: %v", strings.ToUpper(format), err)
+			}
+			break
+		}
+		if parse, names, err = newRowParser(header, opts); err != nil {
+			return nil, nil, err
+		}
+
+		if workers > 1 {
+			info, err := file.Stat()
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to stat file: %v", err)
+			}
+			data, err = parseParallel(file, reader.InputOffset(), info.Size(), reader.Comma, len(header), parse, workers)
+			if err != nil {
+				return nil, nil, err
+			}
+		} else {
+			reader.ReuseRecord = true
+			for {
+				record, err := reader.Read()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return nil, nil, fmt.Errorf("failed to read %s: %v", strings.ToUpper(format), err)
+				}
+				row, keep, err := parse(record)
+				if err != nil {
+					return nil, nil, err
+				}
+				if keep {
+					data = append(data, row)
+				}
+			}
+		}
+	case "jsonl", "xlsx":
+		whole = true
+		if format == "jsonl" {
+			header, records, err = readJSONLines(src)
+		} else {
+			header, records, err = readXLSX(src, opts.sheet)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	default:
+		return nil, nil, fmt.Errorf("unknown input format %q", format)
+	}
+
+	if whole {
+		if opts.long != nil {
+			if header, records, err = pivotWider(header, records, *opts.long); err != nil {
+				return nil, nil, err
+			}
+		}
+		if opts.join != nil {
+			if header, records, err = joinRecords(header, records, *opts.join); err != nil {
+				return nil, nil, err
+			}
+		}
+		if parse, names, err = newRowParser(header, opts); err != nil {
+			return nil, nil, err
+		}
+		for _, record := range records {
+			row, keep, err := parse(record)
+			if err != nil {
+				return nil, nil, err
+			}
+			if keep {
+				data = append(data, row)
+			}
+		}
+	}
+
+	// Check if any records were read
+	if len(data) == 0 {
+		return nil, nil, fmt.Errorf("no data in the input file")
+	}
+
+	ds, err := dataset.FromRows(data, names)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ds.Names, ds.Rows, nil
+}
+
+// rowParser turns one input record into a row of floats, reporting whether
+// the row is kept.
+type rowParser func(record []string) (row []float64, keep bool, err error)
+
+// newRowParser builds the rowParser for a file with the given header,
+// applying the column selection and row filter of opts, and returns the
+// names of the columns it produces.
+func newRowParser(header []string, opts loadOptions) (rowParser, []string, error) {
+	cols, err := selectColumns(header, opts.columns)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(opts.columns) == 0 {
+		// Categorical and timestamp columns aren't numbers, so the
+		// default selection leaves them to their own loaders.
+		special := make(map[string]bool)
+		for _, name := range opts.categorical {
+			special[name] = true
+		}
+		for name := range opts.dates {
+			special[name] = true
+		}
+		numeric := cols[:0]
+		for _, c := range cols {
+			if !special[header[c]] {
+				numeric = append(numeric, c)
+			}
+		}
+		cols = numeric
+	}
+	names := make([]string, len(cols))
+	for i, c := range cols {
+		names[i] = header[c]
+	}
+
+	var where *rowExpr
+	if opts.where != "" {
+		if where, err = compileExpr(opts.where, header); err != nil {
+			return nil, nil, fmt.Errorf("invalid -where expression: %v", err)
+		}
+	}
+
+	// Derived columns go, in name order, between the selected explanatory
+	// columns and the response.
+	derivedNames := make([]string, 0, len(opts.derived))
+	for name := range opts.derived {
+		derivedNames = append(derivedNames, name)
+	}
+	sort.Strings(derivedNames)
+	derived := make([]*rowExpr, len(derivedNames))
+	for i, name := range derivedNames {
+		if derived[i], err = compileExpr(opts.derived[name], header); err != nil {
+			return nil, nil, fmt.Errorf("invalid expression for derived column %q: %v", name, err)
+		}
+	}
+	var categorical []int
+	if len(opts.categorical) > 0 {
+		if categorical, err = selectColumns(header, opts.categorical); err != nil {
+			return nil, nil, fmt.Errorf("bad categorical column: %v", err)
+		}
+	}
+	dates, dateNames, err := newDateColumns(header, opts.dates)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(names) == 0 {
+		return nil, nil, fmt.Errorf("no columns to load: the header has only %d", len(header))
+	}
+	response := names[len(names)-1]
+	names = append(append(names[:len(names)-1:len(names)-1], derivedNames...), opts.categorical...)
+	names = append(append(names, dateNames...), response)
+
+	localized := opts.numbers != (numberFormat{})
+	parse := func(record []string) ([]float64, bool, error) {
+		if opts.missing {
+			record = markMissing(record, cols)
+		}
+		if localized {
+			record = normalizeNumbers(record, opts.numbers, categorical, dates)
+		}
+		if where != nil {
+			keep, err := where.match(record)
+			if err != nil || !keep {
+				return nil, false, err
+			}
+		}
+		row, err := parseRow(record, cols)
+		if err != nil || len(derived) == 0 && len(categorical) == 0 && len(dates) == 0 {
+			return row, err == nil, err
+		}
+
+		response := row[len(row)-1]
+		row = row[:len(row)-1]
+		for i, e := range derived {
+			v, err := e.value(record)
+			if err != nil {
+				return nil, false, fmt.Errorf("derived column %q: %v", derivedNames[i], err)
+			}
+			row = append(row, v)
+		}
+		for _, c := range categorical {
+			row = append(row, levelCode(record[c]))
+		}
+		for _, d := range dates {
+			if row, err = d.appendFeatures(row, record); err != nil {
+				return nil, false, err
+			}
+		}
+		return append(row, response), true, nil
+	}
+	if opts.nonFinite != "keep" {
+		parseAll := parse
+		parse = func(record []string) ([]float64, bool, error) {
+			row, keep, err := parseAll(record)
+			if err != nil || !keep {
+				return row, keep, err
+			}
+			for j, v := range row {
+				if math.IsNaN(v) || math.IsInf(v, 0) {
+					if opts.nonFinite == "drop" {
+						return nil, false, nil
+					}
+					return nil, false, fmt.Errorf("column %q is %v; use -non-finite drop or keep to accept such rows", names[j], v)
+				}
+			}
+			return row, true, nil
+		}
+	}
+	return parse, names, nil
+}
+
+// markMissing returns record with the empty, NA and N/A fields among cols
+// replaced by NaN. record itself is not changed.
+func markMissing(record []string, cols []int) []string {
+	var marked []string
+	for _, c := range cols {
+		switch strings.TrimSpace(record[c]) {
+		case "", "NA", "N/A":
+			if marked == nil {
+				marked = append([]string(nil), record...)
+			}
+			marked[c] = "NaN"
+		}
+	}
+	if marked == nil {
+		return record
+	}
+	return marked
+}
+
+// normalizeNumbers returns a copy of record with every field that is a
+// number in format f rewritten in Go syntax, so -where, derived columns and
+// parseRow all see plain numbers. Categorical and timestamp fields are left
+// alone.
+func normalizeNumbers(record []string, f numberFormat, categorical []int, dates []dateColumn) []string {
+	normalized := append([]string(nil), record...)
+	for i, field := range record {
+		if v, err := f.parse(field); err == nil {
+			normalized[i] = strconv.FormatFloat(v, 'g', -1, 64)
+		}
+	}
+	for _, c := range categorical {
+		normalized[c] = record[c]
+	}
+	for _, d := range dates {
+		normalized[d.col] = record[d.col]
+	}
+	return normalized
+}
+
+// dateColumn reads one timestamp column into calendar features.
+type dateColumn struct {
+	name     string
+	col      int
+	layouts  []string
+	features []string
+}
+
+// newDateColumns resolves the timestamp columns of specs in header, in name
+// order, and returns them with the names of the features they produce.
+func newDateColumns(header []string, specs map[string]dateSpec) ([]dateColumn, []string, error) {
+	var dates []dateColumn
+	var names []string
+	for _, name := range sortedKeys(specs) {
+		spec := specs[name]
+		cols, err := selectColumns(header, []string{name})
+		if err != nil {
+			return nil, nil, fmt.Errorf("bad date column: %v", err)
+		}
+		d := dateColumn{name: name, col: cols[0], layouts: []string{spec.Layout}, features: spec.Features}
+		if spec.Layout == "" {
+			d.layouts = []string{time.RFC3339, "2006-01-02", "2006-01-02 15:04:05", "01/02/2006"}
+		}
+		if len(d.features) == 0 {
+			d.features = []string{"month", "weekday", "days"}
+		}
+		for _, f := range d.features {
+			switch f {
+			case "month", "weekday", "days":
+			default:
+				return nil, nil, fmt.Errorf("date column %q: unknown feature %q", name, f)
+			}
+			names = append(names, name+"_"+f)
+		}
+		dates = append(dates, d)
+	}
+	return dates, names, nil
+}
+
+// appendFeatures parses the column's timestamp in record and appends its
+// features to row.
+func (d dateColumn) appendFeatures(row []float64, record []string) ([]float64, error) {
+	var t time.Time
+	var err error
+	for _, layout := range d.layouts {
+		if t, err = time.Parse(layout, strings.TrimSpace(record[d.col])); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("date column %q: failed to parse %q", d.name, record[d.col])
+	}
+
+	for _, f := range d.features {
+		switch f {
+		case "month":
+			row = append(row, float64(t.Month()))
+		case "weekday":
+			row = append(row, float64(t.Weekday()))
+		case "days":
+			row = append(row, float64(t.Unix())/86400)
+		}
+	}
+	return row, nil
+}
+
+// selectColumns returns the positions in header of the named columns, or of
+// every column after the first (neighborhood) when names is empty.
+func selectColumns(header, names []string) ([]int, error) {
+	if len(names) == 0 {
+		cols := make([]int, len(header)-1)
+		for i := range cols {
+			cols[i] = i + 1
+		}
+		return cols, nil
+	}
+
+	position := make(map[string]int, len(header))
+	for i, name := range header {
+		position[name] = i
+	}
+	cols := make([]int, len(names))
+	for i, name := range names {
+		c, ok := position[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		cols[i] = c
+	}
+	return cols, nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseRow converts the fields at positions cols of a record to floats.
+func parseRow(record []string, cols []int) ([]float64, error) {
+	floats := make([]float64, len(cols))
+	for i, c := range cols {
+		val, err := strconv.ParseFloat(record[c], 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse float: %v", err)
+		}
+		floats[i] = val
+	}
+	return floats, nil
+}
+
+// parseParallel splits the bytes of file between start and end into one
+// range per worker, each beginning at a line boundary, and parses the ranges
+// concurrently. Rows come back in file order. Quoted fields spanning lines
+// are not supported, since a range could start inside one.
+func parseParallel(file *os.File, start, end int64, comma rune, fields int, parse rowParser, workers int) ([][]float64, error) {
+	bounds := []int64{start}
+	for i := 1; i < workers; i++ {
+		off, err := nextLine(file, start+(end-start)*int64(i)/int64(workers), end)
+		if err != nil {
+			return nil, fmt.Errorf("failed to split CSV: %v", err)
+		}
+		if off > bounds[len(bounds)-1] && off < end {
+			bounds = append(bounds, off)
+		}
+	}
+	bounds = append(bounds, end)
+
+	chunks := make([][][]float64, len(bounds)-1)
+	errs := make([]error, len(chunks))
+	done := make(chan struct{})
+
+	for i := range chunks {
+		go func(i int) {
+			defer func() { done <- struct{}{} }()
+
+			reader := csv.NewReader(io.NewSectionReader(file, bounds[i], bounds[i+1]-bounds[i]))
+			reader.Comma = comma
+			reader.FieldsPerRecord = fields
+			reader.ReuseRecord = true
+
+			for {
+				record, err := reader.Read()
+				if err == io.EOF {
+					return
+				}
+				if err != nil {
+					errs[i] = fmt.Errorf("failed to read CSV: %v", err)
+					return
+				}
+				row, keep, err := parse(record)
+				if err != nil {
+					errs[i] = err
+					return
+				}
+				if keep {
+					chunks[i] = append(chunks[i], row)
+				}
+			}
+		}(i)
+	}
+	for range chunks {
+		<-done
+	}
+
+	var data [][]float64
+	for i, chunk := range chunks {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		data = append(data, chunk...)
+	}
+	return data, nil
+}
+
+// nextLine returns the offset just past the first newline at or after off,
+// or end if there is none.
+func nextLine(file *os.File, off, end int64) (int64, error) {
+	buf := make([]byte, 4096)
+	for off < end {
+		n, err := file.ReadAt(buf, off)
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			return off + int64(i) + 1, nil
+		}
+		off += int64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return end, nil
+}
+
+// subsampleRows returns a random fraction of the rows of data, kept in their
+// original order.
+func subsampleRows(data [][]float64, fraction float64, rng *rand.Rand) [][]float64 {
+	n := int(math.Round(fraction * float64(len(data))))
+	keep := rng.Perm(len(data))[:n]
+	sort.Ints(keep)
+
+	rows := make([][]float64, n)
+	for i, idx := range keep {
+		rows[i] = data[idx]
+	}
+	return rows
+}
+
+// splitHoldout moves a random fraction of the rows of data into a holdout
+// set. Both parts keep the original row order.
+func splitHoldout(data [][]float64, fraction float64, rng *rand.Rand) (train, holdout [][]float64) {
+	n := int(math.Round(fraction * float64(len(data))))
+	held := make([]bool, len(data))
+	for _, idx := range rng.Perm(len(data))[:n] {
+		held[idx] = true
+	}
+
+	for i, row := range data {
+		if held[i] {
+			holdout = append(holdout, row)
+		} else {
+			train = append(train, row)
+		}
+	}
+	return train, holdout
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+var parseBenchRows = flag.Int("parse-bench-rows", 200000, "rows in the CSV file BenchmarkParse reads; 10000000 makes it about 1.5 GB")
+
+// writeTestCSV writes a CSV file of rows rows in the layout readData
+// expects: an id column, then cols numeric columns.
+func writeTestCSV(tb testing.TB, rows, cols int) string {
+	tb.Helper()
+	path := filepath.Join(tb.TempDir(), "large.csv")
+	f, err := os.Create(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	w := bufio.NewWriter(f)
+	fmt.Fprint(w, "id")
+	for j := 0; j < cols; j++ {
+		fmt.Fprintf(w, ",x%d", j)
+	}
+	fmt.Fprintln(w)
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < rows; i++ {
+		fmt.Fprintf(w, "r%d", i)
+		for j := 0; j < cols; j++ {
+			fmt.Fprintf(w, ",%.6g", rng.NormFloat64()*100)
+		}
+		fmt.Fprintln(w)
+	}
+	if err := w.Flush(); err != nil {
+		tb.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		tb.Fatal(err)
+	}
+	return path
+}
+
+// TestReadDataParallel checks that splitting a file between workers gives
+// the rows a single reader does, in the same order.
+func TestReadDataParallel(t *testing.T) {
+	path := writeTestCSV(t, 1001, 5)
+	wantNames, want, err := readData(path, loadOptions{format: "csv", workers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, workers := range []int{2, 3, 8, 2000} {
+		names, got, err := readData(path, loadOptions{format: "csv", workers: workers})
+		if err != nil {
+			t.Fatalf("%d workers: %v", workers, err)
+		}
+		if !reflect.DeepEqual(names, wantNames) || !reflect.DeepEqual(got, want) {
+			t.Errorf("%d workers: rows differ from a single reader's", workers)
+		}
+	}
+}
+
+// BenchmarkParse reads a generated CSV file with one parse worker and with
+// one per CPU, at least two. Set -parse-bench-rows for a multi-GB file.
+func BenchmarkParse(b *testing.B) {
+	path := writeTestCSV(b, *parseBenchRows, 14)
+	info, err := os.Stat(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, workers := range []int{1, max(2, runtime.NumCPU())} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			b.SetBytes(info.Size())
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := readData(path, loadOptions{format: "csv", workers: workers}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,838 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/cc1358/Week-6-Assignment-Exploring-Concurrency/progress"
+)
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "describe" {
+		describe(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "report" {
+		showReport(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		diffReports(os.Args[2:])
+		return
+	}
+
+	compensated := flag.Bool("compensated", false, "use Kahan summation for the residual sum of squares")
+	criterionName := flag.String("criterion", "aic", "selection criterion: aic, aicc, aic-simple or bayes (g-prior log marginal likelihood)")
+	gramBlock := flag.Int("gram-block", 64, "columns per cache tile when computing X'X for the L0, knockoff and MIQP paths")
+	miqpPath := flag.String("miqp", "", "write best subset selection of -miqp-size features as a mixed-integer quadratic program in LP format to this file, then exit without searching")
+	miqpSize := flag.Int("miqp-size", 4, "number of features the -miqp program selects")
+	miqpSolver := flag.String("miqp-solver", "", "after writing -miqp, run this solver command on it, with {} replaced by the file name, e.g. \"scip -f {}\"")
+	l0Starts := flag.Int("l0-starts", 0, "instead of enumerating every subset, find the best of each size by iterative hard thresholding and local swaps from this many random starts, run in parallel (0 enumerates)")
+	fitMethod := flag.String("fitter", "ols", "fitting method: ols (ordinary least squares) or tls (total least squares, for noisy predictors)")
+	randomIntercept := flag.String("random-intercept", "", "fit a random intercept for each level of this categorical column, e.g. neighborhood, which is then never a feature")
+	intercept := flag.Bool("intercept", true, "fit an intercept; -intercept=false forces the regression through the origin")
+	pareto := flag.Bool("pareto", false, "report the Pareto front of model size, CV error and criterion")
+	paretoPlot := flag.String("pareto-plot", "", "with -pareto, also write a PNG plot of the lowest CV MSE on the front at each model size to this file")
+	folds := flag.Int("folds", 5, "number of cross-validation folds used by -pareto, -baselines and target encoding, at least 2")
+	mcmcChains := flag.Int("mcmc-chains", 0, "also run this many parallel Gibbs sampling chains over feature inclusion and report posterior inclusion probabilities (0 disables)")
+	mcmcSweeps := flag.Int("mcmc-sweeps", 2000, "Gibbs sweeps over all features per -mcmc-chains chain")
+	mcmcBurnIn := flag.Int("mcmc-burn-in", 500, "initial sweeps of each chain discarded before counting")
+	treeBaselines := flag.Bool("tree-baselines", false, "with -baselines, also score a random forest and gradient boosted trees as black-box references")
+	baselines := flag.Bool("baselines", false, "compare the selected model's CV error with the mean, best single feature and full models")
+	sample := flag.Float64("sample", 1, "fraction of rows to keep, drawn at random, for quick exploratory runs")
+	seed := flag.Int64("seed", 1, "random seed")
+	load := addLoadFlags(flag.CommandLine)
+	demo := flag.Bool("demo", false, "use the built-in sample dataset instead of -input")
+	imputeMethod := flag.String("impute", "none", "fill missing values (empty, NA, N/A or NaN): none, mean (training column means) or mice (chained regressions on the other columns)")
+	addIndicators := flag.Bool("missing-indicators", false, "with -impute, add a 0/1 column c_missing for each column c with missing training values, selected only together with c")
+	imputeIterations := flag.Int("impute-iterations", 10, "rounds of chained equations for -impute mice")
+	configPath := flag.String("config", "", "JSON config file")
+	cacheDir := flag.String("cache-dir", "", "cache the parsed input in this directory as a matrix file, memory-mapped on later runs while the file and load options are unchanged")
+	leakThreshold := flag.Float64("leak-threshold", 0.95, "warn about predictors whose |correlation| with the response exceeds this (0 disables)")
+	splitFraction := flag.Float64("split-inference", 0, "report post-selection confidence intervals by data splitting: select on this fraction of the rows and estimate on the rest (0 disables)")
+	knockoffs := flag.Bool("knockoffs", false, "select features with the model-X knockoff filter, controlling the false discovery rate at -fdr")
+	fdr := flag.Float64("fdr", 0.1, "target false discovery rate of -knockoffs")
+	stabilitySubsamples := flag.Int("stability", 0, "stability selection: rerun the selection on this many random subsamples, in parallel, and report each feature's selection frequency (0 disables)")
+	stabilityFraction := flag.Float64("stability-fraction", 0.5, "fraction of the rows in each -stability subsample")
+	stabilityThreshold := flag.Float64("stability-threshold", 0.6, "selection frequency at which -stability counts a feature as stable")
+	stabilityTable := flag.String("stability-table", "", "write the -stability frequency table to this CSV file")
+	permutations := flag.Int("permutation-test", 0, "rerun the selection on this many response-permuted datasets, in parallel, and report an empirical p-value for the best model (0 disables)")
+	transformSearch := flag.Bool("transform-search", false, "also search log, sqrt and Box-Cox transformations of the response")
+	workers := flag.Int("workers", runtime.NumCPU(), "number of goroutines fitting models")
+	unitSize := flag.Int("unit-size", 256, "number of combinations per work unit")
+	profilePath := flag.String("latency-profile", "", "read per-size fit latencies from this file to size work units by expected time rather than count, and update it after the search")
+	maxCorrelation := flag.Float64("max-correlation", 1, "drop one of each pair of explanatory columns whose absolute correlation exceeds this before the search (1 keeps all)")
+	correlationKeep := flag.String("correlation-keep", "target", "which column of a -max-correlation pair to keep: target (the one more correlated with the response) or first")
+	minVariance := flag.Float64("min-variance", 0, "remove explanatory columns whose variance is below this before the search (0 keeps all)")
+	maxDominant := flag.Float64("max-dominant", 1, "remove explanatory columns whose most common value holds more than this fraction of the rows (1 keeps all)")
+	minRowsPerFeature := flag.Float64("min-rows-per-feature", 5, "skip, with a warning, subset sizes k for which there are fewer than this many rows per feature (n < ratio*k); 0 disables")
+	fitTimeout := flag.Duration("fit-timeout", 0, "skip, and log, any subset whose fit takes longer than this (0 disables)")
+	fitIterations := flag.Int("fit-iterations", 0, "skip, and log, any subset an iterative fitter (-fitter tls) doesn't converge on in this many iterations (0: the fitter's own limit)")
+	progressFormat := flag.String("progress", "none", "report search progress and ETA on stderr: none, console or json (one object per line)")
+	progressInterval := flag.Duration("progress-interval", time.Second, "minimum time between -progress reports")
+	checkpointPath := flag.String("checkpoint", "", "save search progress to this file as it runs")
+	checkpointInterval := flag.Duration("checkpoint-interval", 30*time.Second, "minimum time between -checkpoint saves")
+	resume := flag.Bool("resume", false, "skip the combinations already done in an existing -checkpoint file")
+	verify := flag.Bool("verify", false, "check the concurrent search against a naive sequential search on synthetic problems, then exit")
+	checkInvariants := flag.Bool("check-invariants", false, "debug: verify every combination is evaluated exactly once")
+	top := flag.Int("top", 10, "number of best models kept on the leaderboard for -model-average and -selection-frequency")
+	modelAverage := flag.Bool("model-average", false, "report Akaike weights and model-averaged coefficients over the leaderboard")
+	useAveraged := flag.Bool("use-averaged", false, "use the model-averaged coefficients as the final model; implies -model-average")
+	holdoutFraction := flag.Float64("holdout", 0, "fraction of rows held out, at random, to evaluate the final model")
+	ensembleSize := flag.Int("ensemble", 0, "evaluate on the holdout a blend of the top N models; needs -holdout")
+	ensembleWeighting := flag.String("ensemble-weights", "uniform", "ensemble weighting: uniform or criterion (Akaike weights)")
+	pdDir := flag.String("partial-dependence", "", "write partial dependence CSVs for the selected features to this directory")
+	frequencyDir := flag.String("selection-frequency", "", "write how often each feature is in each size's top models, as CSV and heatmap, to this directory")
+	skippedPath := flag.String("skipped", "", "write every subset skipped without a result (filtered, timed out, out of iterations or singular), with the reason, to this CSV file")
+	allModels := flag.String("all-models", "", "write every evaluated model, best criterion first, to this CSV file")
+	spillRows := flag.Int("spill-rows", 1<<20, "models -all-models holds in memory before spilling a sorted run to disk")
+	subgroupColumn := flag.String("subgroups", "", "report the final model's MSE and bias for each level of this column")
+	subgroupBins := flag.Int("subgroup-bins", 4, "quantile bins used by -subgroups for a column with more than 10 distinct values")
+	pdPlots := flag.Bool("pd-plots", false, "also write a PNG plot next to each partial dependence CSV")
+	screenMethod := flag.String("screen", "", "shortlist predictors before the exhaustive search: lasso, correlation or sis")
+	screenSize := flag.Int("screen-size", 0, "number of predictors -screen keeps (default 15, or n/log(n) for sis)")
+	notifyURL := flag.String("notify-url", "", "POST a JSON summary to this URL when the run completes or fails")
+	notifyEmail := flag.String("notify-email", "", "email a summary to this address when the run completes or fails")
+	smtpAddr := flag.String("smtp-addr", "localhost:25", "SMTP server used by -notify-email")
+	outputFormat := flag.String("output-format", "text", "result format on stdout: text or json; with json the text report goes to stderr")
+	flag.Parse()
+
+	if *notifyURL != "" || *notifyEmail != "" {
+		runNotifier = &notifier{url: *notifyURL, email: *notifyEmail, smtpAddr: *smtpAddr, start: time.Now()}
+		for _, path := range []string{*allModels, *pdDir, *frequencyDir, *paretoPlot} {
+			if path != "" {
+				runNotifier.artifacts = append(runNotifier.artifacts, path)
+			}
+		}
+	}
+
+	// With JSON output, stdout carries only the JSON document so the tool
+	// can feed a pipeline; the human-readable report moves to stderr.
+	var out io.Writer = os.Stdout
+	switch *outputFormat {
+	case "text":
+	case "json":
+		out = os.Stderr
+	default:
+		fatalf("unknown output format %q", *outputFormat)
+	}
+
+	crit, err := criterionByName(*criterionName)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if *fitMethod != "ols" && *fitMethod != "tls" {
+		fatalf("unknown fitter %q", *fitMethod)
+	}
+	if *randomIntercept != "" {
+		if *fitMethod == "tls" {
+			fatalf("-random-intercept can't be combined with -fitter tls")
+		}
+		if _, ok := crit.(gPrior); ok {
+			fatalf("-random-intercept can't be combined with -criterion bayes")
+		}
+	}
+
+	if *verify {
+		opts := searchOptions{compensated: *compensated, throughOrigin: !*intercept, criterion: crit, top: *top, totalLeastSquares: *fitMethod == "tls"}
+		if err := verifySearch(opts, *seed); err != nil {
+			fatalf("verify: %v", err)
+		}
+		fmt.Println("verify: concurrent and sequential searches agree")
+		return
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if err := applyGCConfig(cfg); err != nil {
+		fatalf("%v", err)
+	}
+
+	start := time.Now() // Start measuring CPU time
+
+	// Read CSV
+	if *demo {
+		*load.input = demoInput
+	}
+	// Missing values are loaded as NaN and imputed after the holdout split;
+	// -non-finite then applies to whatever imputation leaves.
+	switch *imputeMethod {
+	case "none", "mean", "mice":
+	default:
+		fatalf("unknown -impute method %q: want none, mean or mice", *imputeMethod)
+	}
+	if *addIndicators && *imputeMethod == "none" {
+		fatalf("-missing-indicators needs -impute")
+	}
+	loadOpts, err := load.options(cfg, *randomIntercept, *imputeMethod != "none")
+	if err != nil {
+		fatalf("%v", err)
+	}
+	names, data, err := readDataCached(*cacheDir, *load.input, loadOpts)
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	prov := newProvenance(flag.CommandLine, cfg, *seed, names, data)
+
+	if *sample <= 0 || *sample > 1 {
+		fatalf("-sample must be in (0, 1], got %v", *sample)
+	}
+	if *sample < 1 {
+		data = subsampleRows(data, *sample, rand.New(rand.NewSource(*seed)))
+		if len(data) == 0 {
+			fatalf("-sample %v keeps no rows", *sample)
+		}
+	}
+
+	if *holdoutFraction < 0 || *holdoutFraction >= 1 {
+		fatalf("-holdout must be in [0, 1), got %v", *holdoutFraction)
+	}
+	if *ensembleSize > 0 && *holdoutFraction == 0 {
+		fatalf("-ensemble needs -holdout")
+	}
+	if *ensembleWeighting != "uniform" && *ensembleWeighting != "criterion" {
+		fatalf("unknown ensemble weighting %q", *ensembleWeighting)
+	}
+	if *mcmcChains > 0 && *mcmcBurnIn >= *mcmcSweeps {
+		fatalf("-mcmc-burn-in %d leaves no sweeps of %d to count", *mcmcBurnIn, *mcmcSweeps)
+	}
+	if *mcmcChains > 0 && (*fitMethod == "tls" || *randomIntercept != "") {
+		fatalf("-mcmc-chains scores subsets by the g-prior of a least squares fit and can't be used with -fitter tls or -random-intercept")
+	}
+	if *splitFraction < 0 || *splitFraction >= 1 {
+		fatalf("-split-inference must be in (0, 1), got %v", *splitFraction)
+	}
+	if *splitFraction > 0 && (*fitMethod == "tls" || *randomIntercept != "") {
+		fatalf("-split-inference refits by least squares and can't be used with -fitter tls or -random-intercept")
+	}
+	if *knockoffs && (*fdr <= 0 || *fdr >= 1) {
+		fatalf("-fdr must be in (0, 1), got %v", *fdr)
+	}
+	if *stabilitySubsamples > 0 && (*stabilityFraction <= 0 || *stabilityFraction >= 1) {
+		fatalf("-stability-fraction must be in (0, 1), got %v", *stabilityFraction)
+	}
+	// Both rerun only the search, so columns chosen or encoded using the
+	// real response would bias the null distribution and the selection
+	// frequencies.
+	if *permutations > 0 || *stabilitySubsamples > 0 {
+		switch {
+		case *screenMethod != "":
+			fatalf("-permutation-test and -stability can't be used with -screen, which chooses columns using the response")
+		case *maxCorrelation < 1 && *correlationKeep == "target":
+			fatalf("-permutation-test and -stability can't be used with -max-correlation with -correlation-keep target, which chooses columns using the response; use -correlation-keep first")
+		case len(cfg.TargetEncode) > 0:
+			fatalf("-permutation-test and -stability can't be used with target_encode, which encodes columns using the response")
+		}
+	}
+	if _, ok := crit.(gPrior); ok && *transformSearch {
+		fatalf("-transform-search compares log-likelihoods across scales and can't be used with -criterion bayes")
+	}
+	if *folds < 2 {
+		fatalf("-folds must be at least 2, got %d", *folds)
+	}
+	if *paretoPlot != "" && !*pareto {
+		fatalf("-pareto-plot needs -pareto")
+	}
+	if *miqpSolver != "" && len(strings.Fields(*miqpSolver)) == 0 {
+		fatalf("-miqp-solver names no command")
+	}
+	var holdout [][]float64
+	if *holdoutFraction > 0 {
+		data, holdout = splitHoldout(data, *holdoutFraction, rand.New(rand.NewSource(*seed)))
+		if len(data) == 0 || len(holdout) == 0 {
+			fatalf("-holdout %v leaves an empty training or holdout set", *holdoutFraction)
+		}
+	}
+
+	var groups [][]string
+	if *imputeMethod != "none" {
+		iterations := *imputeIterations
+		if *imputeMethod == "mean" {
+			iterations = 0
+		}
+		var dropped int
+		data, holdout, dropped = dropMissingResponse(data, holdout)
+		if dropped > 0 {
+			fmt.Fprintf(out, "Dropped %d rows with a missing response\n", dropped)
+		}
+		if len(data) == 0 {
+			fatalf("no training rows have a response")
+		}
+		// Level codes only identify categories, so they are neither
+		// imputed nor predictors.
+		categorical := categoricalColumns(cfg, *randomIntercept)
+		var cols []int
+		for j := 0; j < len(names)-1; j++ {
+			if !containsString(categorical, names[j]) {
+				cols = append(cols, j)
+			}
+		}
+		if *addIndicators {
+			// The indicators are appended after the explanatory columns,
+			// so cols still holds the same columns.
+			features := missingIndicators(names, data, cols)
+			names, data, holdout = addFeatures(names, data, holdout, features)
+			for _, f := range features {
+				groups = append(groups, []string{strings.TrimSuffix(f.Names[0], "_missing"), f.Names[0]})
+			}
+		}
+		filled := imputeChained(data, holdout, cols, iterations)
+		for k, n := range filled {
+			if n > 0 {
+				fmt.Fprintf(out, "Imputed %d missing values of %s (%s)\n", n, names[cols[k]], *imputeMethod)
+			}
+		}
+		data, holdout, err = applyNonFinite(names, data, holdout, *load.nonFinite)
+		if err != nil {
+			fatalf("%v", err)
+		}
+	}
+
+	var clips []clipBounds
+	if len(cfg.Winsorize) > 0 {
+		clips, err = winsorize(names, data, holdout, cfg.Winsorize)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		for _, c := range clips {
+			fmt.Fprintln(out, c)
+		}
+	}
+
+	if len(cfg.TargetEncode) > 0 {
+		if err := targetEncode(names, data, holdout, cfg.TargetEncode, *folds); err != nil {
+			fatalf("%v", err)
+		}
+	}
+	if len(cfg.Bins) > 0 {
+		features, err := binFeatures(names, data, cfg.Bins)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		names, data, holdout = addFeatures(names, data, holdout, features)
+	}
+	if len(cfg.Splines) > 0 {
+		features, err := splineFeatures(names, data, cfg.Splines)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		names, data, holdout = addFeatures(names, data, holdout, features)
+		for _, f := range features {
+			groups = append(groups, f.Names)
+		}
+	}
+	if len(cfg.HashEncode) > 0 {
+		features, codes, err := hashFeatures(names, cfg.HashEncode, cfg.TargetEncode)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		names, data, holdout = addFeatures(names, data, holdout, features)
+		names, data, holdout = dropColumns(names, data, holdout, codes)
+		for _, f := range features {
+			groups = append(groups, f.Names)
+		}
+	}
+
+	// Protected attributes are set aside before the search, so no model
+	// can use them, and kept only to audit the final model.
+	var protected []protectedAttribute
+	if len(cfg.Protected) > 0 {
+		cols, err := selectColumns(names[:len(names)-1], cfg.Protected)
+		if err != nil {
+			fatalf("bad protected column: %v", err)
+		}
+		for i, c := range cols {
+			protected = append(protected, protectedAttribute{name: cfg.Protected[i], train: column(data, c), holdout: column(holdout, c)})
+		}
+		names, data, holdout = dropColumns(names, data, holdout, cols)
+	}
+
+	if *minVariance > 0 || *maxDominant < 1 {
+		drop, reasons := nearConstantColumns(names, data, *minVariance, *maxDominant, *randomIntercept)
+		for i, c := range drop {
+			fmt.Fprintf(out, "Removed near-constant column %s: %s\n", names[c], reasons[i])
+		}
+		names, data, holdout = dropColumns(names, data, holdout, drop)
+	}
+	var pruned []pruneDecision
+	if *maxCorrelation < 1 {
+		if *correlationKeep != "target" && *correlationKeep != "first" {
+			fatalf("unknown -correlation-keep rule %q: want target or first", *correlationKeep)
+		}
+		var drop []int
+		drop, pruned = pruneCorrelated(names, data, *maxCorrelation, *correlationKeep, *randomIntercept)
+		for _, d := range pruned {
+			fmt.Fprintln(out, d)
+		}
+		names, data, holdout = dropColumns(names, data, holdout, drop)
+	}
+
+	// The group column of a random intercept moves to the end of the
+	// explanatory columns, where the search never reaches it.
+	groupColumn := -1
+	if *randomIntercept != "" {
+		cols, err := selectColumns(names[:len(names)-1], []string{*randomIntercept})
+		if err != nil {
+			fatalf("bad -random-intercept column: %v", err)
+		}
+		var order []int
+		for j := 0; j < len(names)-1; j++ {
+			if j != cols[0] {
+				order = append(order, j)
+			}
+		}
+		order = append(order, cols[0], len(names)-1)
+		names = featureNames(names, order)
+		data = projectColumns(data, order)
+		holdout = projectColumns(holdout, order)
+		groupColumn = len(names) - 2
+	}
+
+	responseIndex := len(data[0]) - 1
+	y := make([]float64, len(data))
+	for i, row := range data {
+		y[i] = row[responseIndex]
+	}
+
+	numExplanatory := len(data[0]) - 1
+	if groupColumn >= 0 {
+		numExplanatory--
+	}
+
+	if *leakThreshold > 0 {
+		for _, w := range checkLeakage(names, y, data, numExplanatory, *leakThreshold) {
+			fmt.Fprintln(out, "Warning:", w)
+		}
+	}
+
+	if *screenSize == 0 {
+		*screenSize = 15
+		if *screenMethod == "sis" {
+			*screenSize = sisSize(len(data))
+		}
+	}
+	if *screenMethod != "" && *screenSize < numExplanatory {
+		if *screenSize < 4 {
+			fatalf("-screen-size must be at least 4, got %d", *screenSize)
+		}
+		signs, err := signConstraints(names[:numExplanatory], cfg.Signs)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		keep, err := screenFeatures(*screenMethod, y, data, numExplanatory, *screenSize, *workers, signs)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		fmt.Fprintf(out, "Screened to %d of %d predictors (%s): %v\n", len(keep), numExplanatory, *screenMethod, featureNames(names, keep))
+
+		cols := keep
+		if groupColumn >= 0 {
+			cols = append(cols, groupColumn)
+			groupColumn = len(keep)
+		}
+		cols = append(cols, responseIndex)
+		names = featureNames(names, cols)
+		data = projectColumns(data, cols)
+		holdout = projectColumns(holdout, cols)
+		numExplanatory = len(keep)
+		responseIndex = len(cols) - 1
+	}
+
+	if *miqpPath != "" {
+		if *miqpSize < 1 || *miqpSize > numExplanatory {
+			fatalf("-miqp-size must be between 1 and %d, got %d", numExplanatory, *miqpSize)
+		}
+		if err := writeMIQP(*miqpPath, names, y, data, numExplanatory, *miqpSize, !*intercept, *gramBlock, *workers); err != nil {
+			fatalf("failed to write MIQP: %v", err)
+		}
+		fmt.Fprintf(out, "Wrote best-subset MIQP for %d of %d features to %s\n", *miqpSize, numExplanatory, *miqpPath)
+		if *miqpSolver != "" {
+			args := strings.Fields(strings.ReplaceAll(*miqpSolver, "{}", *miqpPath))
+			cmd := exec.Command(args[0], args[1:]...)
+			cmd.Stdout, cmd.Stderr = out, os.Stderr
+			if err := cmd.Run(); err != nil {
+				fatalf("failed to run MIQP solver: %v", err)
+			}
+		}
+		return
+	}
+
+	opts := searchOptions{
+		compensated:       *compensated,
+		throughOrigin:     !*intercept,
+		criterion:         crit,
+		pareto:            *pareto,
+		folds:             *folds,
+		checkInvariants:   *checkInvariants,
+		workers:           *workers,
+		unitSize:          *unitSize,
+		fitTimeout:        *fitTimeout,
+		fitIterations:     *fitIterations,
+		totalLeastSquares: *fitMethod == "tls",
+		randomIntercept:   groupColumn >= 0,
+		groupColumn:       groupColumn,
+		gramBlock:         *gramBlock,
+	}
+	var filters []subsetFilter
+	if len(cfg.Exclude) > 0 {
+		f, err := excludeFilter(names[:numExplanatory], cfg.Exclude)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		filters = append(filters, f)
+	}
+	if len(groups) > 0 {
+		filters = append(filters, groupFilter(names[:numExplanatory], groups))
+	}
+	maxSize := numExplanatory
+	if *minRowsPerFeature > 0 {
+		maxSize = int(float64(len(data)) / *minRowsPerFeature)
+		if maxSize < 4 {
+			fatalf("%d rows are too few for subsets of 4 features with -min-rows-per-feature %g", len(data), *minRowsPerFeature)
+		}
+		if maxSize < numExplanatory {
+			fmt.Fprintf(out, "Warning: skipping subsets of %d to %d features: %d rows give fewer than %g rows per feature\n", maxSize+1, numExplanatory, len(data), *minRowsPerFeature)
+			filters = append(filters, sizeFilter(maxSize))
+		} else {
+			maxSize = numExplanatory
+		}
+	}
+	opts.filter = allFilters(filters)
+	if *modelAverage || *useAveraged || *frequencyDir != "" {
+		opts.top = *top
+	}
+	if *ensembleSize > opts.top {
+		opts.top = *ensembleSize
+	}
+	var spill *modelSpill
+	if *allModels != "" {
+		spill, err = newModelSpill(*spillRows)
+		if err != nil {
+			fatalf("failed to record all models: %v", err)
+		}
+		defer spill.Close()
+		opts.hooks.OnModelEvaluated = spill.Add
+	}
+	var progressLines *progress.JSONLines
+	if *progressFormat != "none" {
+		reporter, err := progress.NewReporter(*progressFormat, "combinations", os.Stderr)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		opts.hooks.OnProgress = progress.NewMeter(reporter, *progressInterval).Update
+		progressLines, _ = reporter.(*progress.JSONLines)
+	}
+	if *checkpointPath != "" {
+		key := checkpointKey(names, data, opts, cfg, groups, maxSize)
+		opts.checkpoint, err = openCheckpoint(*checkpointPath, key, *checkpointInterval, *resume)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		if n := len(opts.checkpoint.state.Done); n > 0 {
+			if *checkInvariants {
+				fatalf("-check-invariants cannot verify a resumed search")
+			}
+			fmt.Fprintf(out, "Resuming from %s: %d ranges already done\n", *checkpointPath, n)
+		}
+	}
+	if *skippedPath != "" {
+		opts.audit, err = newSkipAudit(*skippedPath, names)
+		if err != nil {
+			fatalf("failed to open skipped subsets log: %v", err)
+		}
+	}
+	var profile *latencyProfile
+	var commitProfile func()
+	if *profilePath != "" {
+		profile, err = loadLatencyProfile(*profilePath)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		opts.unitSizes = profile.unitSizes(opts.unitSize)
+		opts.hooks.OnUnitTimed, commitProfile = profile.timer()
+	}
+	var results []sizeResult
+	if *l0Starts > 0 {
+		if opts.pareto || opts.top > 0 || opts.checkpoint != nil || spill != nil || opts.checkInvariants {
+			fatalf("-l0-starts finds one model per size and can't be used with -pareto, leaderboards, -checkpoint, -all-models or -check-invariants")
+		}
+		results = l0Search(y, data, numExplanatory, opts, *l0Starts, *seed)
+	} else {
+		results = search(y, data, numExplanatory, opts)
+	}
+	if progressLines != nil && progressLines.Err() != nil {
+		fmt.Fprintf(out, "Warning: failed to write progress: %v\n", progressLines.Err())
+	}
+	if profile != nil {
+		commitProfile()
+		if err := profile.save(*profilePath); err != nil {
+			fatalf("failed to save latency profile: %v", err)
+		}
+	}
+	if opts.audit != nil {
+		if err := opts.audit.Close(); err != nil {
+			fatalf("failed to write skipped subsets log: %v", err)
+		}
+		fmt.Fprintf(out, "Skipped subsets: %s\n", opts.audit.Summary())
+	}
+	if spill != nil {
+		if err := spill.WriteCSV(*allModels, names); err != nil {
+			fatalf("failed to write all models: %v", err)
+		}
+	}
+
+	// Process results from the channel
+	best := result{Score: math.Inf(1)}
+	var front []frontierModel
+	var leaderboard []result
+	for _, sr := range results {
+		res := sr.Best
+		fmt.Fprintf(out, "Best Model Features: %v\n", res.Features)
+		fmt.Fprintf(out, "Best Model %s: %.4f\n", opts.criterion.Name(), res.Score)
+		fmt.Fprintf(out, "Best Model MSE: %.4f\n", res.MSE)
+
+		if better(res, best) {
+			best = res
+		}
+		for _, m := range sr.Front {
+			front = addToFront(front, m)
+		}
+		for _, m := range sr.Top {
+			leaderboard = insertTop(leaderboard, m, opts.top)
+		}
+	}
+
+	fmt.Fprintf(out, "\nOverall Best Model Features: %v\n", best.Features)
+	fmt.Fprintf(out, "Overall Best Model %s: %.4f\n", opts.criterion.Name(), best.Score)
+	fmt.Fprintf(out, "Overall Best Model MSE: %.4f\n", best.MSE)
+
+	if *pareto {
+		printFront(out, front, opts.criterion)
+		if *paretoPlot != "" {
+			if err := writeFrontPlot(*paretoPlot, front); err != nil {
+				fatalf("failed to write Pareto front plot: %v", err)
+			}
+		}
+	}
+
+	// The final model is the best subset refitted, or with -use-averaged
+	// the model average over the leaderboard.
+	finalFeatures := best.Features
+	finalModel := opts.train(y, best.Features, data)
+	if *modelAverage || *useAveraged {
+		avg := averageModels(y, data, leaderboard, opts.train)
+		printModelAverage(out, names, opts.criterion, avg)
+		if *useAveraged {
+			finalFeatures, finalModel = avg.Features, avg.Model
+			fmt.Fprintf(out, "Averaged Model MSE: %.4f\n", meanSquaredError(finalModel, y, finalFeatures, data, opts.compensated))
+		}
+	}
+
+	if _, ok := opts.criterion.(gPrior); ok && finalModel.Coeffs != nil && !opts.totalLeastSquares && !*useAveraged {
+		post, err := gPriorPosterior(y, data, finalFeatures, finalModel, opts.throughOrigin)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		fmt.Fprintf(out, "\nPosterior Summaries (g-prior, g = %d):\n", len(data))
+		fmt.Fprintf(out, "%-16s %10s %10s %22s\n", "feature", "mean", "sd", "95% interval")
+		for i, idx := range finalFeatures {
+			p := post[i]
+			fmt.Fprintf(out, "%-16s %+10.4f %10.4f  [%+9.4f, %+9.4f]\n", names[idx], p.Mean, p.SD, p.Lo, p.Hi)
+		}
+	}
+
+	if opts.randomIntercept {
+		_, mixed := fitRandomIntercept(y, best.Features, data, opts.groupColumn, opts.throughOrigin, nil)
+		coeffs := len(best.Features)
+		if !opts.throughOrigin {
+			coeffs++
+		}
+		residual := mixed.RSS / float64(len(data)-coeffs)
+		fmt.Fprintf(out, "\nRandom intercept by %s: variance ratio %.4f, group sd %.4f, residual sd %.4f\n",
+			*randomIntercept, mixed.Lambda, math.Sqrt(mixed.Lambda*residual), math.Sqrt(residual))
+	}
+
+	var checks []checkResult
+	if len(cfg.Monotonic) > 0 && finalModel.Coeffs != nil {
+		checks, err = checkMonotonic(names, data, finalFeatures, finalModel, cfg.Monotonic)
+		if err != nil {
+			fatalf("%v", err)
+		}
+	}
+	if len(cfg.MinEffect) > 0 && finalModel.Coeffs != nil {
+		effects, err := checkEffectSizes(names, y, data, finalFeatures, finalModel, opts.throughOrigin, cfg.MinEffect)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		checks = append(checks, effects...)
+	}
+	if len(checks) > 0 {
+		fmt.Fprintln(out, "\nSanity Checks:")
+		for _, c := range checks {
+			fmt.Fprintln(out, c)
+		}
+	}
+
+	if len(cfg.Metadata) > 0 && finalModel.Coeffs != nil {
+		fmt.Fprintln(out, "\nFinal Model Coefficients:")
+		response := names[responseIndex]
+		fmt.Fprintf(out, "(intercept): %+.4f %s\n", finalModel.Intercept, cfg.Metadata[response].Unit)
+		for i, idx := range finalFeatures {
+			fmt.Fprintln(out, cfg.Metadata.annotate(names[idx], response, finalModel.Coeffs[i]))
+		}
+	}
+
+	if *baselines {
+		models := compareBaselines(y, data, numExplanatory, best.Features, opts)
+		if *treeBaselines {
+			models = append(models, compareTrees(y, data, numExplanatory, opts.folds, *seed, opts.workers)...)
+		}
+		printBaselines(out, models, opts.folds)
+	}
+
+	if *mcmcChains > 0 {
+		printInclusion(out, names, gibbsInclusion(y, data, numExplanatory, opts, *mcmcChains, *mcmcSweeps, *mcmcBurnIn, *seed))
+	}
+
+	if len(holdout) > 0 {
+		holdoutY := column(holdout, responseIndex)
+		fmt.Fprintf(out, "\nHoldout MSE (final model): %.4f\n", meanSquaredError(finalModel, holdoutY, finalFeatures, holdout, opts.compensated))
+
+		if *ensembleSize > 0 {
+			members := leaderboard
+			if len(members) > *ensembleSize {
+				members = members[:*ensembleSize]
+			}
+			single := meanSquaredError(opts.train(y, best.Features, data), holdoutY, best.Features, holdout, opts.compensated)
+			e := newEnsemble(y, data, members, *ensembleWeighting, opts.train)
+			blended := e.meanSquaredError(holdoutY, holdout)
+
+			fmt.Fprintf(out, "Holdout MSE (best single model): %.4f\n", single)
+			fmt.Fprintf(out, "Holdout MSE (ensemble of %d, %s weights): %.4f\n", len(members), *ensembleWeighting, blended)
+			if blended < single {
+				fmt.Fprintln(out, "The ensemble beats the best single model on the holdout.")
+			} else {
+				fmt.Fprintln(out, "The ensemble does not beat the best single model on the holdout.")
+			}
+		}
+	}
+
+	if *subgroupColumn != "" {
+		// Subgroups are judged on the holdout when there is one, so the
+		// errors aren't flattered by the fit.
+		rows, set := data, "training"
+		if len(holdout) > 0 {
+			rows, set = holdout, "holdout"
+		}
+		cols, err := selectColumns(names[:responseIndex], []string{*subgroupColumn})
+		if err != nil {
+			fatalf("bad -subgroups column: %v", err)
+		}
+		groups := subgroupPerformance(finalModel, finalFeatures, rows, responseIndex, column(rows, cols[0]), *subgroupBins)
+		printSubgroups(out, *subgroupColumn, set, groups)
+	}
+
+	for _, attr := range protected {
+		rows, x, set := data, attr.train, "training"
+		if len(holdout) > 0 {
+			rows, x, set = holdout, attr.holdout, "holdout"
+		}
+		groups := subgroupPerformance(finalModel, finalFeatures, rows, responseIndex, x, *subgroupBins)
+		printFairness(out, attr.name, set, groups)
+	}
+
+	if *pdDir != "" {
+		if err := writePartialDependence(*pdDir, *pdPlots, finalModel, finalFeatures, names, data); err != nil {
+			fatalf("failed to write partial dependence: %v", err)
+		}
+		if err := writeJSONFile(filepath.Join(*pdDir, "provenance.json"), prov); err != nil {
+			fatalf("failed to write provenance: %v", err)
+		}
+	}
+
+	if *frequencyDir != "" {
+		if err := writeSelectionFrequency(*frequencyDir, names[:numExplanatory], results); err != nil {
+			fatalf("failed to write selection frequency: %v", err)
+		}
+		if err := writeJSONFile(filepath.Join(*frequencyDir, "provenance.json"), prov); err != nil {
+			fatalf("failed to write provenance: %v", err)
+		}
+	}
+
+	if *transformSearch {
+		searchTransforms(out, y, data, numExplanatory, opts.quiet())
+	}
+
+	if *splitFraction > 0 {
+		estimates, err := splitInference(y, data, numExplanatory, opts, *splitFraction, *seed)
+		if err != nil {
+			fatalf("failed to run post-selection inference: %v", err)
+		}
+		printSplitInference(out, names, estimates, *splitFraction, len(data))
+	}
+
+	if *knockoffs {
+		res, err := knockoffFilter(y, data, numExplanatory, *fdr, *seed, *gramBlock, *workers)
+		if err != nil {
+			fatalf("failed to run the knockoff filter: %v", err)
+		}
+		printKnockoffs(out, names, res, *fdr)
+	}
+
+	if *stabilitySubsamples > 0 {
+		freq := stabilitySelection(y, data, numExplanatory, opts, *stabilitySubsamples, *stabilityFraction, *seed)
+		printStability(out, names, freq, *stabilitySubsamples, *stabilityFraction, *stabilityThreshold)
+		if *stabilityTable != "" {
+			if err := writeStability(*stabilityTable, names, freq, *stabilityThreshold); err != nil {
+				fatalf("failed to write stability table: %v", err)
+			}
+		}
+	}
+
+	if *permutations > 0 {
+		null := permutationTest(y, data, numExplanatory, opts, *permutations, *seed)
+		printPermutationTest(out, opts.criterion, best.Score, null)
+	}
+
+	fmt.Fprintf(out, "\nData SHA-256: %s (%d rows, tool version %s)\n", prov.DataHash, prov.Rows, prov.Version)
+
+	elapsed := time.Since(start)
+	fmt.Fprintf(out, "CPU time taken: %s\n", elapsed)
+	fmt.Fprintln(out, resourceReport())
+
+	if *outputFormat == "json" {
+		report := newRunReport(names, opts.criterion, results, best, front)
+		report.Provenance = prov
+		report.Columns = cfg.Metadata
+		report.Final = newFinalReport(names, finalFeatures, finalModel)
+		report.Checks = checks
+		report.Preprocessing = clips
+		report.Pruned = pruned
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			fatalf("failed to write results: %v", err)
+		}
+	}
+
+	if runNotifier != nil {
+		runNotifier.send(runSummary{
+			Status:    "completed",
+			Criterion: opts.criterion.Name(),
+			Best:      &modelReport{featureNames(names, best.Features), jsonFloat(best.Score), jsonFloat(best.MSE)},
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of TestGoldenReports")
+
+// runMainEnv makes the test binary run the program's main instead of the
+// tests, so TestGoldenReports can run the whole CLI in a child process.
+const runMainEnv = "BOSTON2_TEST_RUN_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(runMainEnv) == "1" {
+		// Drop the testing flags, which would otherwise be parsed and
+		// recorded in the report's provenance.
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// volatileProvenance lists the provenance fields that depend on the build
+// or the machine rather than on the run, left out of the comparison.
+var volatileProvenance = []string{"version", "hostname", "go_version", "time"}
+
+// TestGoldenReports runs the program on the embedded demo data and the
+// mtcars fixture and compares its JSON reports with the golden files in
+// testdata/golden, numbers to a relative tolerance. Run go test -update
+// to rewrite them after a deliberate change, and review the diff.
+func TestGoldenReports(t *testing.T) {
+	mtcarsColumns := "-columns=cyl,disp,hp,drat,wt,qsec,vs,am,gear,carb,mpg"
+	tests := []struct {
+		golden string
+		args   []string
+	}{
+		{"demo", []string{"-demo"}},
+		{"demo_workers", []string{"-demo", "-workers=4", "-unit-size=7"}},
+		{"demo_aicc_origin", []string{"-demo", "-criterion=aicc", "-intercept=false"}},
+		{"mtcars_aic", []string{"-input=testdata/mtcars.csv", mtcarsColumns}},
+		{"mtcars_bayes", []string{"-input=testdata/mtcars.csv", mtcarsColumns, "-criterion=bayes", "-workers=3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			got := runCLI(t, append(tt.args, "-output-format=json")...)
+			path := filepath.Join("testdata", "golden", tt.golden+".json")
+			if *update {
+				b, err := json.MarshalIndent(got, "", "  ")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, append(b, '\n'), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			b, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("%v; run go test -update to create it", err)
+			}
+			var want any
+			if err := json.Unmarshal(b, &want); err != nil {
+				t.Fatalf("failed to parse %s: %v", path, err)
+			}
+			for _, diff := range jsonDiff("", got, want, 1e-9) {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+// runCLI runs the program with args and returns its JSON report
+// without the volatile provenance fields.
+func runCLI(t *testing.T, args ...string) any {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), runMainEnv+"=1")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("%v: %v\n%s", args, err, stderr.Bytes())
+	}
+	var report map[string]any
+	if err := json.Unmarshal(stdout.Bytes(), &report); err != nil {
+		t.Fatalf("%v: failed to parse the report: %v", args, err)
+	}
+	if prov, ok := report["provenance"].(map[string]any); ok {
+		for _, field := range volatileProvenance {
+			delete(prov, field)
+		}
+	}
+	return report
+}
+
+// jsonDiff describes the differences between two decoded JSON values,
+// comparing numbers to within a relative tolerance of tol.
+func jsonDiff(path string, got, want any, tol float64) []string {
+	switch w := want.(type) {
+	case map[string]any:
+		g, ok := got.(map[string]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s: got %v, want an object", path, got)}
+		}
+		keys := make(map[string]bool)
+		for k := range w {
+			keys[k] = true
+		}
+		for k := range g {
+			keys[k] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+		var diffs []string
+		for _, k := range sorted {
+			gv, inGot := g[k]
+			wv, inWant := w[k]
+			switch {
+			case !inGot:
+				diffs = append(diffs, fmt.Sprintf("%s/%s: missing", path, k))
+			case !inWant:
+				diffs = append(diffs, fmt.Sprintf("%s/%s: unexpected %v", path, k, gv))
+			default:
+				diffs = append(diffs, jsonDiff(path+"/"+k, gv, wv, tol)...)
+			}
+		}
+		return diffs
+	case []any:
+		g, ok := got.([]any)
+		if !ok || len(g) != len(w) {
+			return []string{fmt.Sprintf("%s: got %v, want %v", path, got, want)}
+		}
+		var diffs []string
+		for i := range w {
+			diffs = append(diffs, jsonDiff(fmt.Sprintf("%s[%d]", path, i), g[i], w[i], tol)...)
+		}
+		return diffs
+	case float64:
+		g, ok := got.(float64)
+		if !ok || math.Abs(g-w) > tol*math.Max(1, math.Abs(w)) {
+			return []string{fmt.Sprintf("%s: got %v, want %v", path, got, want)}
+		}
+		return nil
+	}
+	if !reflect.DeepEqual(got, want) {
+		return []string{fmt.Sprintf("%s: got %v, want %v", path, got, want)}
+	}
+	return nil
+}
+
+func TestJSONDiff(t *testing.T) {
+	want := map[string]any{"a": 1.0, "b": []any{"x", 2.0}, "c": map[string]any{"d": true}}
+	if diffs := jsonDiff("", map[string]any{"a": 1 + 1e-12, "b": []any{"x", 2.0}, "c": map[string]any{"d": true}}, want, 1e-9); diffs != nil {
+		t.Errorf("values within tolerance differ: %v", diffs)
+	}
+	got := map[string]any{"a": 1.1, "b": []any{"y", 2.0}, "c": map[string]any{}, "e": nil}
+	if diffs := jsonDiff("", got, want, 1e-9); len(diffs) != 4 {
+		t.Errorf("got %d differences, want 4: %v", len(diffs), diffs)
+	}
+}
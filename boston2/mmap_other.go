@@ -0,0 +1,13 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// mapFile reads the whole of the file at path; there is no memory mapping
+// on this platform.
+func mapFile(path string) ([]byte, error) {
+	return os.ReadFile(path)
+}
+
+func unmapFile(b []byte) {}
@@ -0,0 +1,33 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// mapFile maps the whole of the file at path into memory, readable and
+// copy-on-write, so the pages stay shared with the page cache until this
+// process writes to them.
+func mapFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() == 0 {
+		return nil, errors.New("empty file")
+	}
+	return syscall.Mmap(int(f.Fd()), 0, int(info.Size()), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE)
+}
+
+// unmapFile releases a mapping made by mapFile. Nothing may use b after.
+func unmapFile(b []byte) {
+	syscall.Munmap(b)
+}
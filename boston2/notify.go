@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/smtp"
+	"os"
+	"time"
+)
+
+// runNotifier, if set, is told when the run completes or fails.
+var runNotifier *notifier
+
+// fatalf logs a fatal error and exits, first sending any failure
+// notification.
+func fatalf(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	if runNotifier != nil {
+		runNotifier.send(runSummary{Status: "failed", Error: msg})
+	}
+	log.Fatal(msg)
+}
+
+// runSummary is the notification sent at the end of a run.
+type runSummary struct {
+	Status    string       `json:"status"`
+	Error     string       `json:"error,omitempty"`
+	Criterion string       `json:"criterion,omitempty"`
+	Best      *modelReport `json:"best,omitempty"`
+	Runtime   string       `json:"runtime"`
+	Artifacts []string     `json:"artifacts,omitempty"`
+	Hostname  string       `json:"hostname"`
+}
+
+// notifier posts run summaries to a webhook and emails them.
+type notifier struct {
+	url       string
+	email     string
+	smtpAddr  string
+	start     time.Time
+	artifacts []string
+}
+
+// send delivers s to every configured destination. A failed delivery is
+// logged but does not affect the run.
+func (n *notifier) send(s runSummary) {
+	s.Runtime = time.Since(n.start).String()
+	s.Artifacts = n.artifacts
+	s.Hostname, _ = os.Hostname()
+
+	body, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		log.Printf("failed to encode notification: %v", err)
+		return
+	}
+
+	if n.url != "" {
+		client := &http.Client{Timeout: 30 * time.Second}
+		resp, err := client.Post(n.url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("failed to notify %s: %v", n.url, err)
+		} else {
+			resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				log.Printf("failed to notify %s: %s", n.url, resp.Status)
+			}
+		}
+	}
+
+	if n.email != "" {
+		from := "boston2@" + s.Hostname
+		msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: boston2 run %s on %s\r\nContent-Type: application/json\r\n\r\n%s\r\n",
+			from, n.email, s.Status, s.Hostname, body)
+		if err := smtp.SendMail(n.smtpAddr, nil, from, []string{n.email}, []byte(msg)); err != nil {
+			log.Printf("failed to email %s: %v", n.email, err)
+		}
+	}
+}
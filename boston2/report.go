@@ -0,0 +1,539 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// runReport is the structured result written by -output-format json.
+type runReport struct {
+	SchemaVersion int              `json:"schema_version"`
+	Provenance    provenance       `json:"provenance"`
+	Criterion     string           `json:"criterion"`
+	Sizes         []modelReport    `json:"sizes"`
+	Best          modelReport      `json:"best"`
+	Pareto        []frontierReport `json:"pareto,omitempty"`
+	Columns       columnMetadata   `json:"columns,omitempty"`
+	Final         *finalReport     `json:"final_model,omitempty"`
+	Checks        []checkResult    `json:"checks,omitempty"`
+	// Preprocessing holds the fitted clipping bounds, so that new rows can
+	// be clipped the same way before prediction.
+	Preprocessing []clipBounds `json:"preprocessing,omitempty"`
+	// Pruned lists the columns dropped by -max-correlation.
+	Pruned []pruneDecision `json:"pruned,omitempty"`
+}
+
+// finalReport is the fitted final model: its intercept and the coefficient
+// of each feature, by name.
+type finalReport struct {
+	Intercept    jsonFloat            `json:"intercept"`
+	Coefficients map[string]jsonFloat `json:"coefficients"`
+}
+
+func newFinalReport(names []string, features []int, m *linearModel) *finalReport {
+	if m.Coeffs == nil {
+		return nil
+	}
+	r := &finalReport{Intercept: jsonFloat(m.Intercept), Coefficients: make(map[string]jsonFloat)}
+	for i, idx := range features {
+		r.Coefficients[names[idx]] = jsonFloat(m.Coeffs[i])
+	}
+	return r
+}
+
+// reportSchemaVersion is the version of runReport written by this build.
+// Bump it whenever a field changes meaning or is removed, and teach
+// readReport to upgrade the previous version.
+//
+//	1: criterion, sizes, best and pareto; no schema_version field.
+//	2: adds schema_version and provenance.
+//
+// Adding an optional field, such as columns, final_model or checks, needs
+// no new version.
+const reportSchemaVersion = 2
+
+// readReport decodes a JSON report of any version up to
+// reportSchemaVersion, upgrading it to the current layout.
+func readReport(r io.Reader) (runReport, error) {
+	var report runReport
+	if err := json.NewDecoder(r).Decode(&report); err != nil {
+		return runReport{}, fmt.Errorf("failed to decode report: %v", err)
+	}
+	if report.SchemaVersion == 0 {
+		report.SchemaVersion = 1
+	}
+	if report.SchemaVersion > reportSchemaVersion {
+		return runReport{}, fmt.Errorf("report schema version %d is newer than this build supports (%d)", report.SchemaVersion, reportSchemaVersion)
+	}
+	// Version 1 has the same fields as version 2 less the provenance,
+	// which stays empty.
+	report.SchemaVersion = reportSchemaVersion
+	return report, nil
+}
+
+// showReport prints a summary of saved JSON reports of any schema version.
+func showReport(args []string) {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		log.Fatal("usage: report file.json...")
+	}
+
+	for _, path := range fs.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		report, err := readReport(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("%s: %v", path, err)
+		}
+
+		fmt.Printf("%s:\n", path)
+		if report.Provenance.DataHash != "" {
+			fmt.Printf("Data SHA-256: %s (%d rows, tool version %s)\n", report.Provenance.DataHash, report.Provenance.Rows, report.Provenance.Version)
+		}
+		for _, m := range report.Sizes {
+			fmt.Printf("Best Model Features (size %d): %v, %s: %.4f, MSE: %.4f\n", len(m.Features), m.Features, report.Criterion, m.Score, m.MSE)
+		}
+		fmt.Printf("Overall Best Model Features: %v\n", report.Best.Features)
+		fmt.Printf("Overall Best Model %s: %.4f\n", report.Criterion, report.Best.Score)
+		fmt.Printf("Overall Best Model MSE: %.4f\n", report.Best.MSE)
+	}
+}
+
+// diffReports compares two saved JSON reports, e.g. from successive
+// retrains, and prints what changed from the first to the second: the
+// data, the features selected at each size and overall, the criterion and
+// MSE, and the final model's coefficients when both reports have them.
+func diffReports(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		log.Fatal("usage: diff old.json new.json")
+	}
+
+	var reports [2]runReport
+	for i, path := range fs.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		reports[i], err = readReport(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("%s: %v", path, err)
+		}
+	}
+	old, cur := reports[0], reports[1]
+
+	if old.Criterion != cur.Criterion {
+		fmt.Printf("Criterion changed from %s to %s; score deltas are not comparable\n", old.Criterion, cur.Criterion)
+	}
+	switch {
+	case old.Provenance.DataHash == "" || cur.Provenance.DataHash == "":
+		fmt.Println("Data: unknown (a report has no provenance)")
+	case old.Provenance.DataHash == cur.Provenance.DataHash:
+		fmt.Printf("Data: unchanged (%d rows)\n", cur.Provenance.Rows)
+	default:
+		fmt.Printf("Data: changed (%d rows, was %d)\n", cur.Provenance.Rows, old.Provenance.Rows)
+	}
+
+	bySize := func(r runReport) map[int]modelReport {
+		m := make(map[int]modelReport)
+		for _, s := range r.Sizes {
+			m[len(s.Features)] = s
+		}
+		return m
+	}
+	oldSizes, curSizes := bySize(old), bySize(cur)
+	var sizes []int
+	for size := range oldSizes {
+		sizes = append(sizes, size)
+	}
+	for size := range curSizes {
+		if _, ok := oldSizes[size]; !ok {
+			sizes = append(sizes, size)
+		}
+	}
+	sort.Ints(sizes)
+	for _, size := range sizes {
+		o, ok1 := oldSizes[size]
+		c, ok2 := curSizes[size]
+		switch {
+		case !ok1:
+			fmt.Printf("Size %d: new, %v\n", size, c.Features)
+		case !ok2:
+			fmt.Printf("Size %d: gone, was %v\n", size, o.Features)
+		default:
+			fmt.Printf("Size %d: %s, %s %+.4f, MSE %+.4f\n", size, featureChange(o.Features, c.Features), cur.Criterion, c.Score-o.Score, c.MSE-o.MSE)
+		}
+	}
+	fmt.Printf("Overall best: %s, %s %+.4f, MSE %+.4f\n", featureChange(old.Best.Features, cur.Best.Features), cur.Criterion, cur.Best.Score-old.Best.Score, cur.Best.MSE-old.Best.MSE)
+
+	if old.Final == nil || cur.Final == nil {
+		return
+	}
+	fmt.Println("\nCoefficient shifts (old -> new):")
+	fmt.Printf("(intercept): %+.4f -> %+.4f (%+.4f)\n", old.Final.Intercept, cur.Final.Intercept, cur.Final.Intercept-old.Final.Intercept)
+	names := sortedKeys(old.Final.Coefficients)
+	for _, name := range sortedKeys(cur.Final.Coefficients) {
+		if _, ok := old.Final.Coefficients[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	for _, name := range names {
+		o, ok1 := old.Final.Coefficients[name]
+		c, ok2 := cur.Final.Coefficients[name]
+		switch {
+		case !ok1:
+			fmt.Printf("%s: added, %+.4f\n", name, c)
+		case !ok2:
+			fmt.Printf("%s: dropped, was %+.4f\n", name, o)
+		default:
+			fmt.Printf("%s: %+.4f -> %+.4f (%+.4f)\n", name, o, c, c-o)
+		}
+	}
+}
+
+// featureChange describes how a selected feature set changed.
+func featureChange(old, cur []string) string {
+	var added, removed []string
+	for _, f := range cur {
+		if !containsString(old, f) {
+			added = append(added, f)
+		}
+	}
+	for _, f := range old {
+		if !containsString(cur, f) {
+			removed = append(removed, f)
+		}
+	}
+	if len(added) == 0 && len(removed) == 0 {
+		return fmt.Sprintf("unchanged %v", cur)
+	}
+	return fmt.Sprintf("%v (added %v, removed %v)", cur, added, removed)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// version identifies the build in result artifacts; release builds set it
+// with -ldflags "-X main.version=...".
+var version = "dev"
+
+// provenance records what produced a result artifact, so it can be traced
+// back to its exact inputs.
+type provenance struct {
+	Version string `json:"version"`
+	// DataHash is the SHA-256 of the loaded dataset: the column names and
+	// the bits of every value, before sampling or holdout splits.
+	DataHash  string            `json:"data_sha256"`
+	Rows      int               `json:"rows"`
+	Columns   []string          `json:"columns"`
+	Args      []string          `json:"args"`
+	Flags     map[string]string `json:"flags"`
+	Config    runConfig         `json:"config"`
+	Seed      int64             `json:"seed"`
+	Hostname  string            `json:"hostname"`
+	GoVersion string            `json:"go_version"`
+	Time      string            `json:"time"`
+}
+
+// newProvenance describes a run from its flag set, config, seed and data.
+func newProvenance(fs *flag.FlagSet, cfg runConfig, seed int64, names []string, data [][]float64) provenance {
+	p := provenance{
+		Version:   version,
+		DataHash:  hashData(names, data),
+		Rows:      len(data),
+		Columns:   names,
+		Args:      fs.Args(),
+		Flags:     make(map[string]string),
+		Config:    cfg,
+		Seed:      seed,
+		GoVersion: runtime.Version(),
+		Time:      time.Now().UTC().Format(time.RFC3339),
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		p.Flags[f.Name] = f.Value.String()
+	})
+	p.Hostname, _ = os.Hostname()
+	return p
+}
+
+// hashData returns the hex SHA-256 of the column names and values of data.
+func hashData(names []string, data [][]float64) string {
+	h := sha256.New()
+	for _, name := range names {
+		io.WriteString(h, name)
+		h.Write([]byte{0})
+	}
+	var buf [8]byte
+	for _, row := range data {
+		for _, v := range row {
+			binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v))
+			h.Write(buf[:])
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// writeJSONFile writes v as indented JSON to path.
+func writeJSONFile(path string, v any) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0o644)
+}
+
+type modelReport struct {
+	Features []string  `json:"features"`
+	Score    jsonFloat `json:"score"`
+	MSE      jsonFloat `json:"mse"`
+}
+
+type frontierReport struct {
+	Features []string  `json:"features"`
+	CVError  jsonFloat `json:"cv_mse"`
+	Score    jsonFloat `json:"score"`
+}
+
+// jsonFloat is a float64 that survives JSON: JSON has no NaN or infinity,
+// so those are written as the strings "NaN", "+Inf" and "-Inf", and read
+// back from them.
+type jsonFloat float64
+
+func (f jsonFloat) MarshalJSON() ([]byte, error) {
+	v := float64(f)
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return json.Marshal(strconv.FormatFloat(v, 'g', -1, 64))
+	}
+	return json.Marshal(v)
+}
+
+func (f *jsonFloat) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil || !math.IsNaN(v) && !math.IsInf(v, 0) {
+			return fmt.Errorf("invalid number %q", s)
+		}
+		*f = jsonFloat(v)
+		return nil
+	}
+	var v float64
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*f = jsonFloat(v)
+	return nil
+}
+
+// newRunReport gathers a run's results, ordered by subset size, with
+// features named rather than numbered.
+func newRunReport(names []string, crit criterion, results []sizeResult, best result, front []frontierModel) runReport {
+	report := runReport{
+		SchemaVersion: reportSchemaVersion,
+		Criterion:     crit.Name(),
+		Best:          modelReport{featureNames(names, best.Features), jsonFloat(best.Score), jsonFloat(best.MSE)},
+	}
+
+	sizes := append([]sizeResult(nil), results...)
+	sort.Slice(sizes, func(i, j int) bool { return len(sizes[i].Best.Features) < len(sizes[j].Best.Features) })
+	for _, sr := range sizes {
+		report.Sizes = append(report.Sizes, modelReport{featureNames(names, sr.Best.Features), jsonFloat(sr.Best.Score), jsonFloat(sr.Best.MSE)})
+	}
+	for _, m := range front {
+		report.Pareto = append(report.Pareto, frontierReport{featureNames(names, m.Features), jsonFloat(m.CVError), jsonFloat(m.Score)})
+	}
+	return report
+}
+
+// featureNames maps feature indices to column names.
+func featureNames(names []string, features []int) []string {
+	named := make([]string, len(features))
+	for i, idx := range features {
+		named[i] = names[idx]
+	}
+	return named
+}
+
+// printSplitInference reports the coefficients of the model selected by
+// splitInference with their intervals and p-values.
+func printSplitInference(w io.Writer, names []string, estimates []splitEstimate, fraction float64, rows int) {
+	cut := int(math.Round(fraction * float64(rows)))
+	fmt.Fprintf(w, "\nPost-selection inference (selected on %d rows, estimated on the other %d):\n", cut, rows-cut)
+	if len(estimates) == 0 {
+		fmt.Fprintln(w, "The selected model has no features.")
+		return
+	}
+	fmt.Fprintf(w, "%-16s %12s %10s %25s %10s\n", "feature", "coefficient", "std err", "95% interval", "p-value")
+	for _, e := range estimates {
+		interval := fmt.Sprintf("[%.4f, %.4f]", e.Lower, e.Upper)
+		fmt.Fprintf(w, "%-16s %12.4f %10.4f %25s %10.3g\n", names[e.Feature], e.Coeff, e.SE, interval, e.P)
+	}
+}
+
+// printKnockoffs lists each feature's knockoff statistic and the features
+// the filter selects.
+func printKnockoffs(w io.Writer, names []string, res knockoffResult, fdr float64) {
+	fmt.Fprintf(w, "\nKnockoff filter (target FDR %g):\n", fdr)
+	fmt.Fprintf(w, "%-16s %12s\n", "feature", "W")
+	for j, v := range res.W {
+		mark := ""
+		if v >= res.Threshold {
+			mark = " *"
+		}
+		fmt.Fprintf(w, "%-16s %12.5f%s\n", names[j], v, mark)
+	}
+	if math.IsInf(res.Threshold, 1) {
+		fmt.Fprintf(w, "No threshold meets the target; nothing is selected.\n")
+		return
+	}
+	fmt.Fprintf(w, "Threshold %.5f selects %v\n", res.Threshold, featureNames(names, res.Selected))
+}
+
+// printBaselines prints the CV error of each model and how much lower the
+// selected model's error is, as a percentage of the baseline's.
+func printBaselines(w io.Writer, models []baseline, folds int) {
+	selected := models[0]
+	fmt.Fprintf(w, "\nBaselines (%d-fold CV MSE):\n", folds)
+	fmt.Fprintf(w, "%-12s %8s %12s %12s\n", "Model", "Features", "CV MSE", "Improvement")
+	fmt.Fprintf(w, "%-12s %8d %12.4f %12s\n", selected.Name, len(selected.Features), selected.CVError, "")
+	for _, m := range models[1:] {
+		improvement := 100 * (m.CVError - selected.CVError) / m.CVError
+		fmt.Fprintf(w, "%-12s %8d %12.4f %11.2f%%\n", m.Name, len(m.Features), m.CVError, improvement)
+	}
+}
+
+func printInclusion(w io.Writer, names []string, r inclusionResult) {
+	fmt.Fprintf(w, "\nPosterior Inclusion Probabilities (g-prior, %d chains of %d sweeps, %d burn-in):\n", r.Chains, r.Sweeps, r.BurnIn)
+	fmt.Fprintf(w, "%-16s %12s %14s\n", "feature", "probability", "chain spread")
+	for j, p := range r.Probability {
+		fmt.Fprintf(w, "%-16s %12.4f %14.4f\n", names[j], p, r.Spread[j])
+	}
+	fmt.Fprintf(w, "Most visited model: %v (%.1f%% of sweeps)\n", featureNames(names, r.Top), 100*r.Share)
+}
+
+// printFairness prints the final model's accuracy across the levels of a
+// protected attribute, then two disparity measures: the gap between the
+// largest and smallest mean residual, and the ratio of the largest to the
+// smallest MSE.
+func printFairness(w io.Writer, attribute, set string, groups []subgroupStats) {
+	fmt.Fprintf(w, "\nFairness audit of %s (%s rows):\n", attribute, set)
+	fmt.Fprintf(w, "%-24s %6s %10s %10s\n", attribute, "rows", "MSE", "bias")
+	loBias, hiBias := math.Inf(1), math.Inf(-1)
+	loMSE, hiMSE := math.Inf(1), math.Inf(-1)
+	for _, g := range groups {
+		fmt.Fprintf(w, "%-24s %6d %10.4f %+10.4f\n", g.Label, g.N, g.MSE, g.Bias)
+		if g.N == 0 {
+			continue
+		}
+		loBias, hiBias = math.Min(loBias, g.Bias), math.Max(hiBias, g.Bias)
+		loMSE, hiMSE = math.Min(loMSE, g.MSE), math.Max(hiMSE, g.MSE)
+	}
+	fmt.Fprintf(w, "Mean residual difference: %.4f\n", hiBias-loBias)
+	fmt.Fprintf(w, "MSE ratio (worst / best level): %.4f\n", hiMSE/loMSE)
+}
+
+func printSubgroups(w io.Writer, column, set string, groups []subgroupStats) {
+	fmt.Fprintf(w, "\nSubgroup performance by %s (%s rows):\n", column, set)
+	fmt.Fprintf(w, "%-24s %6s %10s %10s\n", column, "rows", "MSE", "bias")
+	for _, g := range groups {
+		fmt.Fprintf(w, "%-24s %6d %10.4f %+10.4f\n", g.Label, g.N, g.MSE, g.Bias)
+	}
+}
+
+// printStability lists each feature's selection frequency, marking those
+// at or above threshold, and returns the stable set they form.
+func printStability(w io.Writer, names []string, freq []float64, subsamples int, fraction, threshold float64) []int {
+	fmt.Fprintf(w, "\nStability selection (%d subsamples of %.0f%% of the rows):\n", subsamples, 100*fraction)
+	fmt.Fprintf(w, "%-16s %10s\n", "feature", "frequency")
+	var stable []int
+	for j, f := range freq {
+		mark := ""
+		if f >= threshold {
+			mark = " *"
+			stable = append(stable, j)
+		}
+		fmt.Fprintf(w, "%-16s %10.3f%s\n", names[j], f, mark)
+	}
+	fmt.Fprintf(w, "Stable features (frequency >= %g): %v\n", threshold, featureNames(names, stable))
+	return stable
+}
+
+// printPermutationTest reports where the observed best score falls in the
+// null distribution. The p-value counts the observed run as one of the
+// permutations, so it is never zero.
+func printPermutationTest(w io.Writer, crit criterion, observed float64, null []float64) {
+	sorted := append([]float64(nil), null...)
+	sort.Float64s(sorted)
+	atLeastAsGood := 0
+	for _, v := range null {
+		if v <= observed {
+			atLeastAsGood++
+		}
+	}
+	p := float64(1+atLeastAsGood) / float64(1+len(null))
+	fmt.Fprintf(w, "\nPermutation test (%d permutations of the response):\n", len(null))
+	fmt.Fprintf(w, "Observed best %s: %.4f\n", crit.Name(), observed)
+	fmt.Fprintf(w, "Null best %s: min %.4f, median %.4f, max %.4f\n", crit.Name(), sorted[0], quantile(sorted, 0.5), sorted[len(sorted)-1])
+	fmt.Fprintf(w, "Empirical p-value: %.4g\n", p)
+}
+
+// printModelAverage prints the leaderboard with Akaike weights, then the
+// averaged coefficients.
+func printModelAverage(w io.Writer, names []string, crit criterion, avg modelAverage) {
+	fmt.Fprintln(w, "\nLeaderboard:")
+	fmt.Fprintf(w, "%-4s %12s %10s %8s  %s\n", "Rank", crit.Name(), "Delta", "Weight", "Features")
+	for i, m := range avg.Models {
+		fmt.Fprintf(w, "%-4d %12.4f %10.4f %8.4f  %v\n", i+1, m.Score, m.Score-avg.Models[0].Score, avg.Weights[i], featureNames(names, m.Features))
+	}
+
+	if avg.Model == nil {
+		return
+	}
+	fmt.Fprintln(w, "\nModel-Averaged Coefficients:")
+	fmt.Fprintf(w, "%-12s %12s %10s\n", "Term", "Coefficient", "Importance")
+	fmt.Fprintf(w, "%-12s %12.4f %10s\n", "(intercept)", avg.Model.Intercept, "")
+	for i, idx := range avg.Features {
+		fmt.Fprintf(w, "%-12s %12.4f %10.4f\n", names[idx], avg.Model.Coeffs[i], avg.Importance[i])
+	}
+}
+
+// printFront prints the Pareto front as a table ordered by model size, then
+// criterion.
+func printFront(w io.Writer, front []frontierModel, crit criterion) {
+	sort.Slice(front, func(i, j int) bool {
+		if len(front[i].Features) != len(front[j].Features) {
+			return len(front[i].Features) < len(front[j].Features)
+		}
+		return front[i].Score < front[j].Score
+	})
+
+	fmt.Fprintln(w, "\nPareto Front:")
+	fmt.Fprintf(w, "%-6s %12s %12s  %s\n", "Size", "CV MSE", crit.Name(), "Features")
+	for _, m := range front {
+		fmt.Fprintf(w, "%-6d %12.4f %12.4f  %v\n", len(m.Features), m.CVError, m.Score, m.Features)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestReadReportUpgradesV1 reads a version 1 report, which has no
+// schema_version or provenance, and checks it comes back in the current
+// layout with its fields intact.
+func TestReadReportUpgradesV1(t *testing.T) {
+	f, err := os.Open("testdata/report_v1.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got, err := readReport(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	four := []string{"crim", "rooms", "ptratio", "lstat"}
+	five := []string{"crim", "chas", "rooms", "ptratio", "lstat"}
+	want := runReport{
+		SchemaVersion: reportSchemaVersion,
+		Criterion:     "AIC",
+		Sizes: []modelReport{
+			{Features: four, Score: 625.1803, MSE: 9.6342},
+			{Features: five, Score: 619.9523, MSE: 9.1315},
+		},
+		Best: modelReport{Features: five, Score: 619.9523, MSE: 9.1315},
+		Pareto: []frontierReport{
+			{Features: four, CVError: 10.2711, Score: 625.1803},
+			{Features: five, CVError: 9.9812, Score: jsonFloat(math.Inf(1))},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readReport = %+v\nwant %+v", got, want)
+	}
+}
+
+// TestReadReportCurrent checks that a report written by this build reads
+// back at its own version.
+func TestReadReportCurrent(t *testing.T) {
+	f, err := os.Open("testdata/golden/demo.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got, err := readReport(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.SchemaVersion != reportSchemaVersion || got.Provenance.DataHash == "" || got.Final == nil {
+		t.Errorf("golden report read back as version %d, data hash %q, final model %v", got.SchemaVersion, got.Provenance.DataHash, got.Final)
+	}
+}
+
+func TestReadReportRefusesNewer(t *testing.T) {
+	_, err := readReport(strings.NewReader(`{"schema_version": 3, "criterion": "AIC"}`))
+	if err == nil || !strings.Contains(err.Error(), "newer than this build supports") {
+		t.Errorf("readReport of a version 3 report returned %v, want a refusal", err)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// applyGCConfig sets the garbage collector percentage and soft memory limit
+// given in cfg.
+func applyGCConfig(cfg runConfig) error {
+	if cfg.GOGC != nil {
+		debug.SetGCPercent(*cfg.GOGC)
+	}
+	if cfg.MemoryLimit != "" {
+		limit, err := parseByteSize(cfg.MemoryLimit)
+		if err != nil {
+			return fmt.Errorf("bad memory_limit: %v", err)
+		}
+		debug.SetMemoryLimit(limit)
+	}
+	return nil
+}
+
+// parseByteSize parses a size in the GOMEMLIMIT syntax: a number of bytes
+// with an optional B, KiB, MiB, GiB or TiB suffix.
+func parseByteSize(v string) (int64, error) {
+	units := []struct {
+		suffix string
+		scale  int64
+	}{{"TiB", 1 << 40}, {"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10}, {"B", 1}}
+
+	digits, scale := v, int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(v, u.suffix) {
+			digits, scale = strings.TrimSuffix(v, u.suffix), u.scale
+			break
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(digits), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", v)
+	}
+	return n * scale, nil
+}
+
+// resourceReport summarizes the run's memory use: peak resident set size
+// where the platform reports it, allocations, and garbage collections.
+func resourceReport() string {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	rss := "unavailable"
+	if peak, ok := peakRSS(); ok {
+		rss = formatBytes(peak)
+	}
+	return fmt.Sprintf("Resources: peak RSS %s, %d allocations (%s), %d GCs pausing %s in total",
+		rss, ms.Mallocs, formatBytes(ms.TotalAlloc), ms.NumGC, time.Duration(ms.PauseTotalNs))
+}
+
+// peakRSS reads the peak resident set size from /proc/self/status; it is
+// only available on Linux.
+func peakRSS() (uint64, bool) {
+	status, err := os.ReadFile("/proc/self/status")
+	if err != nil {
+		return 0, false
+	}
+	for _, line := range strings.Split(string(status), "\n") {
+		if v, ok := strings.CutPrefix(line, "VmHWM:"); ok {
+			kb, err := strconv.ParseUint(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(v), "kB")), 10, 64)
+			if err != nil {
+				return 0, false
+			}
+			return kb << 10, true
+		}
+	}
+	return 0, false
+}
+
+func formatBytes(n uint64) string {
+	const unit = 1 << 10
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// screenFeatures shortlists m of the first numExplanatory columns of data
+// and returns their indices in increasing order. The lasso method keeps the
+// first m predictors to enter the lasso path, fitted under the sign
+// constraints in signs (see lassoOrder); the correlation and sis
+// methods keep the m with the largest absolute correlation with y, computed
+// by workers goroutines. They differ only in the default m.
+func screenFeatures(method string, y []float64, data [][]float64, numExplanatory, m, workers int, signs []float64) ([]int, error) {
+	var order []int
+	switch method {
+	case "lasso":
+		order = lassoOrder(y, data, numExplanatory, signs)
+	case "correlation", "sis":
+		order = correlationOrder(y, data, numExplanatory, workers)
+	default:
+		return nil, fmt.Errorf("unknown screening method %q", method)
+	}
+
+	keep := append([]int(nil), order[:m]...)
+	sort.Ints(keep)
+	return keep, nil
+}
+
+// signConstraints returns the sign lassoOrder allows for each of names,
+// from the config's signs, or nil if there are none.
+func signConstraints(names []string, constraints map[string]string) ([]float64, error) {
+	if len(constraints) == 0 {
+		return nil, nil
+	}
+	signs := make([]float64, len(names))
+	for _, name := range sortedKeys(constraints) {
+		cols, err := selectColumns(names, []string{name})
+		if err != nil {
+			return nil, fmt.Errorf("bad sign constraint: %v", err)
+		}
+		switch constraints[name] {
+		case "non-negative":
+			signs[cols[0]] = 1
+		case "non-positive":
+			signs[cols[0]] = -1
+		default:
+			return nil, fmt.Errorf("sign constraint for %q: want non-negative or non-positive, got %q", name, constraints[name])
+		}
+	}
+	return signs, nil
+}
+
+// sisSize is the number of predictors kept by Sure Independence Screening
+// for n rows: n/log(n), as suggested by Fan and Lv (2008).
+func sisSize(n int) int {
+	if n < 3 {
+		return 1
+	}
+	return int(float64(n) / math.Log(float64(n)))
+}
+
+// correlationOrder returns the first numExplanatory columns of data by
+// decreasing absolute correlation with y.
+func correlationOrder(y []float64, data [][]float64, numExplanatory, workers int) []int {
+	r := marginalCorrelations(y, data, numExplanatory, workers)
+	order := make([]int, numExplanatory)
+	for j := range order {
+		r[j] = math.Abs(r[j])
+		order[j] = j
+	}
+	sort.SliceStable(order, func(a, b int) bool { return r[order[a]] > r[order[b]] })
+	return order
+}
+
+// marginalCorrelations returns the correlation of each of the first
+// numExplanatory columns of data with y. The columns are shared out among
+// workers goroutines, so thousands of predictors screen quickly.
+func marginalCorrelations(y []float64, data [][]float64, numExplanatory, workers int) []float64 {
+	if workers < 1 {
+		workers = 1
+	}
+
+	r := make([]float64, numExplanatory)
+	jobs := make(chan int)
+	done := make(chan struct{})
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			for j := range jobs {
+				r[j] = correlation(column(data, j), y)
+			}
+		}()
+	}
+	for j := 0; j < numExplanatory; j++ {
+		jobs <- j
+	}
+	close(jobs)
+	for w := 0; w < workers; w++ {
+		<-done
+	}
+	return r
+}
+
+// lassoOrder returns the first numExplanatory columns of data in the order
+// they enter the lasso path, fitted by coordinate descent on standardized
+// predictors over a decreasing grid of penalties. Columns that never enter
+// follow, by decreasing absolute correlation with y.
+//
+// signs, if not nil, constrains each coefficient: +1 keeps it
+// non-negative, -1 non-positive and 0 leaves it free. Each coordinate
+// update is projected onto its allowed half-line, so a column whose
+// effect has the wrong sign never enters.
+func lassoOrder(y []float64, data [][]float64, numExplanatory int, signs []float64) []int {
+	x := make([][]float64, numExplanatory)
+	for j := range x {
+		x[j] = standardize(column(data, j))
+	}
+	r := standardize(y)
+	if r == nil {
+		return correlationOrder(y, data, numExplanatory, 1)
+	}
+
+	entry := lassoEntry(r, x, signs)
+	var order []int
+	for j, lambda := range entry {
+		if lambda > 0 {
+			order = append(order, j)
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool { return entry[order[a]] > entry[order[b]] })
+	for _, j := range correlationOrder(y, data, numExplanatory, 1) {
+		if entry[j] == 0 {
+			order = append(order, j)
+		}
+	}
+	return order
+}
+
+// lassoEntry follows the lasso path of the standardized response r on the
+// standardized columns x and returns, for each column, the penalty at which
+// its coefficient first becomes non-zero, or 0 if it never does. Nil
+// columns are skipped. r is overwritten with the final residuals.
+func lassoEntry(r []float64, x [][]float64, signs []float64) []float64 {
+	const (
+		steps     = 100
+		minRatio  = 1e-4
+		tolerance = 1e-7
+		maxSweeps = 1000
+	)
+
+	n := float64(len(r))
+	lambdaMax := 0.0
+	for j := range x {
+		lambdaMax = math.Max(lambdaMax, math.Abs(dot(x[j], r))/n)
+	}
+
+	beta := make([]float64, len(x))
+	entry := make([]float64, len(x))
+	entered := 0
+	for step := 1; step <= steps && entered < len(x); step++ {
+		lambda := lambdaMax * math.Pow(minRatio, float64(step)/steps)
+		for sweep := 0; sweep < maxSweeps; sweep++ {
+			var change float64
+			for j := range x {
+				if x[j] == nil {
+					continue
+				}
+				// Standardized columns have x'x = n, so the update is a
+				// soft threshold of the partial residual correlation.
+				rho := dot(x[j], r)/n + beta[j]
+				b := softThreshold(rho, lambda)
+				if signs != nil && signs[j]*b < 0 {
+					b = 0
+				}
+				if d := b - beta[j]; d != 0 {
+					for i := range r {
+						r[i] -= d * x[j][i]
+					}
+					beta[j] = b
+					change = math.Max(change, math.Abs(d))
+				}
+			}
+			if change < tolerance {
+				break
+			}
+		}
+		for j, b := range beta {
+			if b != 0 && entry[j] == 0 {
+				entry[j] = lambda
+				entered++
+			}
+		}
+	}
+	return entry
+}
+
+// standardize returns x centred and scaled to unit variance, or nil if x is
+// constant.
+func standardize(x []float64) []float64 {
+	var mean float64
+	for _, v := range x {
+		mean += v
+	}
+	mean /= float64(len(x))
+
+	var ss float64
+	for _, v := range x {
+		ss += (v - mean) * (v - mean)
+	}
+	if ss == 0 {
+		return nil
+	}
+	sd := math.Sqrt(ss / float64(len(x)))
+
+	z := make([]float64, len(x))
+	for i, v := range x {
+		z[i] = (v - mean) / sd
+	}
+	return z
+}
+
+func softThreshold(v, lambda float64) float64 {
+	switch {
+	case v > lambda:
+		return v - lambda
+	case v < -lambda:
+		return v + lambda
+	}
+	return 0
+}
+
+// projectColumns returns the rows of data restricted to cols, in that order.
+func projectColumns(data [][]float64, cols []int) [][]float64 {
+	projected := make([][]float64, len(data))
+	for i, row := range data {
+		projected[i] = featureRow(row, cols)
+	}
+	return projected
+}
@@ -0,0 +1,857 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cc1358/Week-6-Assignment-Exploring-Concurrency/hooks"
+)
+
+// searchOptions holds the settings shared by every fit in a search.
+type searchOptions struct {
+	compensated   bool
+	throughOrigin bool
+	pareto        bool
+	folds         int
+	criterion     criterion
+	// top is the number of models kept on the leaderboard; 0 keeps none.
+	top int
+	// checkInvariants makes search count every evaluation and stop the
+	// program if any combination was skipped or evaluated twice.
+	checkInvariants bool
+	// workers is the number of goroutines evaluating work units, and
+	// unitSize the number of combinations in each unit. unitSizes, if set,
+	// overrides unitSize for the sizes it lists; see latencyProfile.
+	workers   int
+	unitSize  int
+	unitSizes map[int]int
+	// fitTimeout, if positive, bounds the time spent fitting one subset,
+	// and fitIterations the iterations of an iterative fitter.
+	fitTimeout    time.Duration
+	fitIterations int
+	// budget, set by fitWithTimeout for one fit, is handed to the
+	// iterative fitters; nil sets no bounds.
+	budget *fitBudget
+	// hooks, if set, are called as the search progresses.
+	hooks hooks.Hooks
+	// filter, if set, is asked about each subset before it is fitted;
+	// subsets it rejects are skipped without a fit.
+	filter subsetFilter
+	// checkpoint, if set, supplies ranges already done by an earlier run
+	// and records each range as it completes.
+	checkpoint *checkpointer
+	// audit, if set, records every subset skipped without a result.
+	audit *skipAudit
+	// totalLeastSquares fits by total least squares instead of ordinary
+	// least squares; see fitTLS.
+	totalLeastSquares bool
+	// randomIntercept fits a random intercept for each level of column
+	// groupColumn of data, which is never a feature; see
+	// fitRandomIntercept.
+	randomIntercept bool
+	groupColumn     int
+
+	// gramBlock is the number of columns per tile of gramMatrix.
+	gramBlock int
+}
+
+// fitter fits a linear model of y on the selected features of data.
+type fitter func(y []float64, features []int, data [][]float64) *linearModel
+
+// train fits a model with the fitting method and intercept of opts. It
+// has the signature of a fitter.
+func (o searchOptions) train(y []float64, features []int, data [][]float64) *linearModel {
+	if o.randomIntercept {
+		m, _ := fitRandomIntercept(y, features, data, o.groupColumn, o.throughOrigin, o.budget)
+		return m
+	}
+	if o.totalLeastSquares {
+		return fitTLS(y, features, data, o.throughOrigin, o.budget)
+	}
+	return trainModel(y, features, data, o.throughOrigin)
+}
+
+// subsetFilter reports whether a subset of feature indices should be fitted.
+// It is called concurrently from the workers.
+type subsetFilter func(features []int) bool
+
+// excludeFilter rejects every subset holding all the columns of any of the
+// groups, given by name among names.
+func excludeFilter(names []string, groups [][]string) (subsetFilter, error) {
+	excluded := make([][]int, len(groups))
+	for i, group := range groups {
+		if len(group) == 0 {
+			return nil, fmt.Errorf("empty exclude group %d", i)
+		}
+		cols, err := selectColumns(names, group)
+		if err != nil {
+			return nil, fmt.Errorf("bad exclude group %v: %v", group, err)
+		}
+		excluded[i] = cols
+	}
+
+	return func(features []int) bool {
+		for _, group := range excluded {
+			if containsAll(features, group) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// groupFilter rejects every subset holding some but not all of the
+// columns of a group, so each group enters or leaves a model as a whole.
+// Group members missing from names, e.g. dropped by screening, are ignored.
+func groupFilter(names []string, groups [][]string) subsetFilter {
+	position := make(map[string]int, len(names))
+	for i, name := range names {
+		position[name] = i
+	}
+	var resolved [][]int
+	for _, group := range groups {
+		var cols []int
+		for _, name := range group {
+			if c, ok := position[name]; ok {
+				cols = append(cols, c)
+			}
+		}
+		if len(cols) > 1 {
+			resolved = append(resolved, cols)
+		}
+	}
+
+	return func(features []int) bool {
+		for _, group := range resolved {
+			if containsAny(features, group) && !containsAll(features, group) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// sizeFilter rejects every subset of more than maxSize features.
+func sizeFilter(maxSize int) subsetFilter {
+	return func(features []int) bool { return len(features) <= maxSize }
+}
+
+// allFilters combines filters into one that accepts a subset only if they
+// all do, or returns nil if there are none.
+func allFilters(filters []subsetFilter) subsetFilter {
+	switch len(filters) {
+	case 0:
+		return nil
+	case 1:
+		return filters[0]
+	}
+	return func(features []int) bool {
+		for _, f := range filters {
+			if !f(features) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// containsAny reports whether the sorted features include any index in
+// cols.
+func containsAny(features, cols []int) bool {
+	for _, c := range cols {
+		if i := sort.SearchInts(features, c); i < len(features) && features[i] == c {
+			return true
+		}
+	}
+	return false
+}
+
+// containsAll reports whether the sorted features include every index in
+// cols.
+func containsAll(features, cols []int) bool {
+	for _, c := range cols {
+		i := sort.SearchInts(features, c)
+		if i == len(features) || features[i] != c {
+			return false
+		}
+	}
+	return true
+}
+
+// search fits every subset of four or more of the first numExplanatory
+// columns of data and returns each size's result, smallest size first.
+//
+// The combinations of each size are split, in lexicographic order, into
+// work units of at most opts.unitSize combinations. A pool of opts.workers
+// goroutines evaluates the units; their results are merged per size, and
+// the search stops the program unless every unit completed exactly once and
+// the processed combinations add up to the sum of C(n, k) over the sizes.
+// OnNewBest and OnSizeComplete hooks are called from the merge, one at a
+// time, in the order the results arrive.
+func search(y []float64, data [][]float64, numExplanatory int, opts searchOptions) []sizeResult {
+	const minSize = 4
+	units := planWork(numExplanatory, minSize, opts.unitSize, opts.unitSizes)
+	var restored []completedRange
+	if opts.checkpoint != nil {
+		restored = opts.checkpoint.state.Done
+		units = remainingWork(units, restored)
+	}
+
+	var tracker *evaluationTracker
+	if opts.checkInvariants {
+		tracker = newEvaluationTracker()
+	}
+
+	workers := opts.workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	// Channels for communicating work and results
+	jobs := make(chan workUnit)
+	results := make(chan unitResult)
+	done := make(chan struct{})
+
+	// Start the worker goroutines
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			for u := range jobs {
+				results <- evaluateUnit(u, y, data, numExplanatory, opts, tracker)
+			}
+		}()
+	}
+
+	go func() {
+		for _, u := range units {
+			jobs <- u
+		}
+		close(jobs)
+	}()
+
+	// Wait for all workers to finish
+	go func() {
+		for i := 0; i < workers; i++ {
+			<-done
+		}
+		close(results) // Close the results channel after all workers finish
+	}()
+
+	remaining := make(map[int]int)
+	for _, u := range units {
+		remaining[u.Size]++
+	}
+
+	var expected int
+	for size := minSize; size <= numExplanatory; size++ {
+		expected += binomial(numExplanatory, size)
+	}
+
+	completed := make([]bool, len(units))
+	bySize := make(map[int]*sizeResult)
+	best := result{Score: math.Inf(1)}
+	var processed int
+	merge := func(size int, part sizeResult) {
+		sr, ok := bySize[size]
+		if !ok {
+			sr = &sizeResult{Best: result{Score: math.Inf(1)}}
+			bySize[size] = sr
+		}
+		if better(part.Best, sr.Best) {
+			sr.Best = part.Best
+		}
+		for _, m := range part.Front {
+			sr.Front = addToFront(sr.Front, m)
+		}
+		for _, m := range part.Top {
+			sr.Top = insertTop(sr.Top, m, opts.top)
+		}
+	}
+	for _, r := range restored {
+		merge(r.Size, r.sizeResult())
+		processed += r.Count
+		if r.Best != nil && better(*r.Best, best) {
+			best = *r.Best
+		}
+	}
+	if opts.hooks.OnProgress != nil {
+		opts.hooks.OnProgress(processed, expected)
+	}
+
+	for ur := range results {
+		if completed[ur.ID] {
+			fatalf("work unit %d completed twice", ur.ID)
+		}
+		completed[ur.ID] = true
+		processed += ur.Count
+		merge(units[ur.ID].Size, ur.sizeResult)
+		if opts.hooks.OnUnitTimed != nil {
+			opts.hooks.OnUnitTimed(units[ur.ID].Size, ur.Count, ur.Elapsed)
+		}
+		if opts.checkpoint != nil {
+			if err := opts.checkpoint.record(units[ur.ID], ur); err != nil {
+				fatalf("failed to save checkpoint: %v", err)
+			}
+		}
+
+		if better(ur.Best, best) {
+			best = ur.Best
+			if opts.hooks.OnNewBest != nil {
+				opts.hooks.OnNewBest(best)
+			}
+		}
+		size := units[ur.ID].Size
+		remaining[size]--
+		if remaining[size] == 0 && opts.hooks.OnSizeComplete != nil {
+			sr := bySize[size]
+			opts.hooks.OnSizeComplete(size, hooks.Size{Best: sr.Best, Top: sr.Top})
+		}
+		if opts.hooks.OnProgress != nil {
+			opts.hooks.OnProgress(processed, expected)
+		}
+	}
+
+	for id, ok := range completed {
+		if !ok {
+			fatalf("work unit %d never completed", id)
+		}
+	}
+	if opts.checkpoint != nil {
+		if err := opts.checkpoint.save(); err != nil {
+			fatalf("failed to save checkpoint: %v", err)
+		}
+	}
+	if processed != expected {
+		fatalf("processed %d combinations, want %d", processed, expected)
+	}
+
+	if tracker != nil {
+		if err := tracker.verify(numExplanatory, minSize); err != nil {
+			fatalf("search invariant violated: %v", err)
+		}
+	}
+
+	// A size has no result if every subset of it was filtered out or
+	// timed out.
+	var all []sizeResult
+	for size := minSize; size <= numExplanatory; size++ {
+		if sr, ok := bySize[size]; ok && sr.Best.Features != nil {
+			all = append(all, *sr)
+		}
+	}
+	return all
+}
+
+// verifySearch runs search on small synthetic problems under several worker
+// counts and unit sizes and checks that every run matches naiveSearch
+// exactly: the same best subset, score and MSE for each size, and the same
+// leaderboard.
+func verifySearch(opts searchOptions, seed int64) error {
+	rng := rand.New(rand.NewSource(seed))
+	for problem := 0; problem < 3; problem++ {
+		numExplanatory := 6 + problem
+		y, data := syntheticProblem(rng, 50+20*problem, numExplanatory)
+		want := naiveSearch(y, data, numExplanatory, opts)
+
+		for _, workers := range []int{1, 3, 8} {
+			for _, unitSize := range []int{1, 7, 256} {
+				o := opts
+				o.workers, o.unitSize = workers, unitSize
+				got := search(y, data, numExplanatory, o)
+				if err := compareResults(got, want); err != nil {
+					return fmt.Errorf("problem %d with %d workers and unit size %d: %v", problem, workers, unitSize, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// syntheticProblem returns n rows of numExplanatory standard normal
+// features and a response that depends linearly on the first half of them,
+// plus noise. The response is also appended to each row, as in readData.
+func syntheticProblem(rng *rand.Rand, n, numExplanatory int) (y []float64, data [][]float64) {
+	y = make([]float64, n)
+	data = make([][]float64, n)
+	for i := range data {
+		row := make([]float64, numExplanatory+1)
+		for j := 0; j < numExplanatory; j++ {
+			row[j] = rng.NormFloat64()
+			if j < numExplanatory/2 {
+				y[i] += float64(j+1) * row[j]
+			}
+		}
+		y[i] += rng.NormFloat64()
+		row[numExplanatory] = y[i]
+		data[i] = row
+	}
+	return y, data
+}
+
+// naiveSearch is the reference for verifySearch: it fits every subset of
+// four or more features, one after another, in lexicographic order.
+func naiveSearch(y []float64, data [][]float64, numExplanatory int, opts searchOptions) []sizeResult {
+	var all []sizeResult
+	for size := 4; size <= numExplanatory; size++ {
+		sr := sizeResult{Best: result{Score: math.Inf(1)}}
+		for _, features := range generateCombinations(numExplanatory, size) {
+			mse, score, err := fitModel(y, features, data, opts)
+			if err != nil {
+				continue
+			}
+			m := result{Features: features, Score: score, MSE: mse}
+			if better(m, sr.Best) {
+				sr.Best = m
+			}
+			if opts.top > 0 {
+				sr.Top = insertTop(sr.Top, m, opts.top)
+			}
+		}
+		all = append(all, sr)
+	}
+	return all
+}
+
+// compareResults reports the first difference between two searches.
+func compareResults(got, want []sizeResult) error {
+	if len(got) != len(want) {
+		return fmt.Errorf("%d sizes, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !sameResult(got[i].Best, want[i].Best) {
+			return fmt.Errorf("best of size %d is %v, want %v", len(want[i].Best.Features), got[i].Best, want[i].Best)
+		}
+		if len(got[i].Top) != len(want[i].Top) {
+			return fmt.Errorf("leaderboard of size %d has %d models, want %d", len(want[i].Best.Features), len(got[i].Top), len(want[i].Top))
+		}
+		for j := range want[i].Top {
+			if !sameResult(got[i].Top[j], want[i].Top[j]) {
+				return fmt.Errorf("model %d of size %d is %v, want %v", j, len(want[i].Best.Features), got[i].Top[j], want[i].Top[j])
+			}
+		}
+	}
+	return nil
+}
+
+func sameResult(a, b result) bool {
+	return a.Score == b.Score && a.MSE == b.MSE && fmt.Sprint(a.Features) == fmt.Sprint(b.Features)
+}
+
+// evaluateUnit fits every combination in u.
+func evaluateUnit(u workUnit, y []float64, data [][]float64, numExplanatory int, opts searchOptions, tracker *evaluationTracker) unitResult {
+	start := time.Now()
+	ur := unitResult{ID: u.ID}
+	ur.Best = result{Score: math.Inf(1)}
+
+	combination := unrankCombination(numExplanatory, u.Size, u.Start)
+	for rank := u.Start; rank < u.End; rank++ {
+		features := append([]int(nil), combination...)
+		nextCombination(combination, numExplanatory)
+
+		if tracker != nil {
+			tracker.record(features)
+		}
+		ur.Count++
+		if opts.filter != nil && !opts.filter(features) {
+			if opts.audit != nil {
+				opts.audit.Add(features, skipFiltered)
+			}
+			continue
+		}
+		mse, score, skip := fitWithTimeout(y, features, data, opts)
+		if skip != "" {
+			switch skip {
+			case skipTimeout:
+				log.Printf("fit of subset %v timed out after %v; skipping it", features, opts.fitTimeout)
+			case skipIterations:
+				log.Printf("fit of subset %v did not converge in %d iterations; skipping it", features, opts.fitIterations)
+			}
+			if opts.audit != nil {
+				opts.audit.Add(features, skip)
+			}
+			continue
+		}
+
+		candidate := result{Features: features, Score: score, MSE: mse}
+		if opts.hooks.OnModelEvaluated != nil {
+			opts.hooks.OnModelEvaluated(candidate)
+		}
+		if better(candidate, ur.Best) {
+			ur.Best = candidate
+		}
+		if opts.top > 0 {
+			ur.Top = insertTop(ur.Top, candidate, opts.top)
+		}
+
+		if opts.pareto {
+			cv := cvError(y, features, data, opts)
+			ur.Front = addToFront(ur.Front, frontierModel{features, cv, score})
+		}
+	}
+	ur.Elapsed = time.Since(start)
+	return ur
+}
+
+// fitWithTimeout runs fitModel, giving up after opts.fitTimeout if that is
+// set, and bounding an iterative fitter by opts.fitIterations. A timed-out
+// fit is cancelled through its fitBudget, which the iterative fitters check
+// between iterations; a direct solve can't be interrupted, so it finishes
+// in the background and its result is discarded. Without a timeout the fit
+// runs on the calling goroutine. skip is empty for a good fit, or the
+// reason the subset has no result.
+func fitWithTimeout(y []float64, features []int, data [][]float64, opts searchOptions) (mse, score float64, skip skipReason) {
+	if opts.fitTimeout <= 0 {
+		if opts.fitIterations > 0 {
+			opts.budget = &fitBudget{max: opts.fitIterations}
+		}
+		mse, score, err := fitModel(y, features, data, opts)
+		if err != nil {
+			return 0, 0, skipFor(err)
+		}
+		return mse, score, ""
+	}
+
+	type fit struct {
+		mse, score float64
+		err        error
+	}
+	cancel := make(chan struct{})
+	opts.budget = &fitBudget{max: opts.fitIterations, cancel: cancel}
+	finished := make(chan fit, 1)
+	go func() {
+		mse, score, err := fitModel(y, features, data, opts)
+		finished <- fit{mse, score, err}
+	}()
+
+	timer := time.NewTimer(opts.fitTimeout)
+	defer timer.Stop()
+	select {
+	case f := <-finished:
+		if f.err != nil {
+			return 0, 0, skipFor(f.err)
+		}
+		return f.mse, f.score, ""
+	case <-timer.C:
+		close(cancel)
+		return 0, 0, skipTimeout
+	}
+}
+
+// skipFor is the skipReason for a fitModel error.
+func skipFor(err error) skipReason {
+	if err == errFitBudget {
+		return skipIterations
+	}
+	return skipSingular
+}
+
+// skipReason says why a subset of the search has no result.
+type skipReason string
+
+const (
+	skipFiltered   skipReason = "filtered"
+	skipTimeout    skipReason = "timeout"
+	skipIterations skipReason = "iterations"
+	skipSingular   skipReason = "singular"
+)
+
+// skipAudit writes every skipped subset, with its reason, to a CSV file and
+// counts them by reason. It is safe for concurrent use by the workers.
+type skipAudit struct {
+	mu     sync.Mutex
+	names  []string
+	file   *os.File
+	w      *csv.Writer
+	counts map[skipReason]int
+}
+
+func newSkipAudit(path string, names []string) (*skipAudit, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	a := &skipAudit{names: names, file: f, w: csv.NewWriter(f), counts: make(map[skipReason]int)}
+	a.w.Write([]string{"size", "features", "reason"})
+	return a, nil
+}
+
+// Add records that features were skipped for reason.
+func (a *skipAudit) Add(features []int, reason skipReason) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.counts[reason]++
+	a.w.Write([]string{strconv.Itoa(len(features)), strings.Join(featureNames(a.names, features), " "), string(reason)})
+}
+
+// Close flushes and closes the file.
+func (a *skipAudit) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.w.Flush()
+	if err := a.w.Error(); err != nil {
+		a.file.Close()
+		return err
+	}
+	return a.file.Close()
+}
+
+// Summary returns the number of subsets skipped for each reason.
+func (a *skipAudit) Summary() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return fmt.Sprintf("%d filtered, %d timed out, %d out of iterations, %d singular",
+		a.counts[skipFiltered], a.counts[skipTimeout], a.counts[skipIterations], a.counts[skipSingular])
+}
+
+// binomial returns C(n, k).
+func binomial(n, k int) int {
+	if k < 0 || k > n {
+		return 0
+	}
+	c := 1
+	for i := 1; i <= k; i++ {
+		c = c * (n - k + i) / i
+	}
+	return c
+}
+
+// unrankCombination returns the k-combination of 0..n-1 at position rank in
+// lexicographic order.
+func unrankCombination(n, k, rank int) []int {
+	combination := make([]int, 0, k)
+	next := 0
+	for remaining := k; remaining > 0; remaining-- {
+		// Skip past every combination starting with next while rank lies
+		// beyond them.
+		for {
+			count := binomial(n-next-1, remaining-1)
+			if rank < count {
+				break
+			}
+			rank -= count
+			next++
+		}
+		combination = append(combination, next)
+		next++
+	}
+	return combination
+}
+
+// nextCombination advances combination to its lexicographic successor among
+// the k-combinations of 0..n-1, reporting false after the last one.
+func nextCombination(combination []int, n int) bool {
+	k := len(combination)
+	i := k - 1
+	for i >= 0 && combination[i] == n-k+i {
+		i--
+	}
+	if i < 0 {
+		return false
+	}
+	combination[i]++
+	for j := i + 1; j < k; j++ {
+		combination[j] = combination[j-1] + 1
+	}
+	return true
+}
+
+// result is a fitted subset, the type the search hooks receive.
+type result = hooks.Model
+
+// evaluationTracker counts how often each combination is evaluated, for
+// -check-invariants. It is safe for concurrent use.
+type evaluationTracker struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func newEvaluationTracker() *evaluationTracker {
+	return &evaluationTracker{counts: make(map[string]int)}
+}
+
+func (t *evaluationTracker) record(features []int) {
+	key := fmt.Sprint(features)
+	t.mu.Lock()
+	t.counts[key]++
+	t.mu.Unlock()
+}
+
+// verify checks that every combination of at least minSize of n features
+// was recorded exactly once and that nothing else was.
+func (t *evaluationTracker) verify(n, minSize int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var expected int
+	for size := minSize; size <= n; size++ {
+		for _, features := range generateCombinations(n, size) {
+			expected++
+			switch c := t.counts[fmt.Sprint(features)]; c {
+			case 1:
+			case 0:
+				return fmt.Errorf("combination %v was never evaluated", features)
+			default:
+				return fmt.Errorf("combination %v was evaluated %d times", features, c)
+			}
+		}
+	}
+	if len(t.counts) != expected {
+		return fmt.Errorf("%d distinct combinations evaluated, want %d", len(t.counts), expected)
+	}
+	return nil
+}
+
+// sizeResult holds the search result for one subset size: its best model,
+// with -pareto its Pareto front, and with a leaderboard its best models in
+// order.
+type sizeResult struct {
+	Best  result
+	Front []frontierModel
+	Top   []result
+}
+
+// insertTop adds m to a leaderboard ordered by better, keeping at most n
+// models.
+func insertTop(top []result, m result, n int) []result {
+	i := sort.Search(len(top), func(i int) bool { return better(m, top[i]) })
+	if i >= n {
+		return top
+	}
+	top = append(top, result{})
+	copy(top[i+1:], top[i:])
+	top[i] = m
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// better reports whether a should be preferred over b. Lower criterion wins;
+// on equal criterion the model with fewer features wins, and after that the one whose
+// feature indices come first lexicographically. This keeps the selected
+// model independent of the order in which goroutines deliver their results.
+func better(a, b result) bool {
+	if a.Score != b.Score {
+		return a.Score < b.Score
+	}
+	if len(a.Features) != len(b.Features) {
+		return len(a.Features) < len(b.Features)
+	}
+	for i := range a.Features {
+		if a.Features[i] != b.Features[i] {
+			return a.Features[i] < b.Features[i]
+		}
+	}
+	return false
+}
+
+// frontierModel is a candidate for the Pareto front of model size, cross
+// validated error and criterion.
+type frontierModel struct {
+	Features []int
+	CVError  float64
+	Score    float64
+}
+
+// dominates reports whether a is at least as good as b on size, CV error and
+// criterion, and strictly better on at least one of them.
+func dominates(a, b frontierModel) bool {
+	if len(a.Features) > len(b.Features) || a.CVError > b.CVError || a.Score > b.Score {
+		return false
+	}
+	return len(a.Features) < len(b.Features) || a.CVError < b.CVError || a.Score < b.Score
+}
+
+// addToFront inserts m into a set of mutually non-dominated models, dropping
+// any models m dominates. m is discarded if something already dominates it.
+func addToFront(front []frontierModel, m frontierModel) []frontierModel {
+	kept := front[:0]
+	for _, other := range front {
+		if dominates(other, m) {
+			return front
+		}
+		if !dominates(m, other) {
+			kept = append(kept, other)
+		}
+	}
+	return append(kept, m)
+}
+
+// bestOf returns the preferred model across all subset sizes.
+func bestOf(results []sizeResult) result {
+	best := result{Score: math.Inf(1)}
+	for _, sr := range results {
+		if better(sr.Best, best) {
+			best = sr.Best
+		}
+	}
+	return best
+}
+
+// quiet returns o with the search's side outputs turned off, for the
+// searches rerun on resampled data.
+func (o searchOptions) quiet() searchOptions {
+	o.pareto = false
+	o.top = 0
+	o.checkInvariants = false
+	o.hooks = hooks.Hooks{}
+	o.checkpoint = nil
+	o.audit = nil
+	return o
+}
+
+// forEachConcurrently calls f(0) to f(n-1) on a pool of workers goroutines
+// and returns when every call has.
+func forEachConcurrently(n, workers int, f func(i int)) {
+	if workers > n {
+		workers = n
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	jobs := make(chan int)
+	done := make(chan struct{})
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			for i := range jobs {
+				f(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	for w := 0; w < workers; w++ {
+		<-done
+	}
+}
+
+func generateCombinations(n, k int) [][]int {
+	var combinations [][]int
+	generateCombinationsHelper(n, k, 0, []int{}, &combinations)
+	return combinations
+}
+
+func generateCombinationsHelper(n, k, index int, combination []int, combinations *[][]int) {
+	if k == 0 {
+		*combinations = append(*combinations, append([]int{}, combination...))
+		return
+	}
+
+	for i := index; i < n; i++ {
+		combination = append(combination, i)
+		generateCombinationsHelper(n, k-1, i+1, combination, combinations)
+		combination = combination[:len(combination)-1]
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cc1358/Week-6-Assignment-Exploring-Concurrency/hooks"
+)
+
+func TestBetter(t *testing.T) {
+	tests := []struct {
+		a, b result
+		want bool
+	}{
+		{result{Features: []int{0, 1, 2, 3}, Score: 1}, result{Features: []int{0, 1, 2, 3}, Score: 2}, true},
+		{result{Features: []int{0, 1, 2, 3}, Score: 2}, result{Features: []int{0, 1, 2, 3}, Score: 1}, false},
+		// Equal scores: fewer features, then lexicographic order.
+		{result{Features: []int{4, 5, 6, 7}, Score: 1}, result{Features: []int{0, 1, 2, 3, 4}, Score: 1}, true},
+		{result{Features: []int{0, 1, 2, 3, 4}, Score: 1}, result{Features: []int{4, 5, 6, 7}, Score: 1}, false},
+		{result{Features: []int{0, 1, 2, 4}, Score: 1}, result{Features: []int{0, 1, 3, 4}, Score: 1}, true},
+		{result{Features: []int{0, 1, 3, 4}, Score: 1}, result{Features: []int{0, 1, 2, 4}, Score: 1}, false},
+		{result{Features: []int{0, 1, 2, 3}, Score: 1}, result{Features: []int{0, 1, 2, 3}, Score: 1}, false},
+	}
+	for _, tt := range tests {
+		if got := better(tt.a, tt.b); got != tt.want {
+			t.Errorf("better(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// TestInsertTopArrivalOrder feeds the same tied models to insertTop in
+// every order and checks the leaderboard always comes out the same.
+func TestInsertTopArrivalOrder(t *testing.T) {
+	models := []result{
+		{Features: []int{0, 1, 2, 5}, Score: 10},
+		{Features: []int{0, 1, 2, 4}, Score: 10},
+		{Features: []int{0, 1, 2, 3, 4}, Score: 10},
+		{Features: []int{1, 2, 3, 4}, Score: 9},
+		{Features: []int{0, 2, 3, 4}, Score: 10},
+		{Features: []int{0, 1, 3, 4}, Score: 11},
+	}
+	want := fmt.Sprint([]result{models[3], models[1], models[0], models[4]})
+
+	var permute func(k int)
+	permute = func(k int) {
+		if k == len(models) {
+			var top []result
+			for _, m := range models {
+				top = insertTop(top, m, 4)
+			}
+			if got := fmt.Sprint(top); got != want {
+				t.Fatalf("arrival order %v gives %s, want %s", models, got, want)
+			}
+			return
+		}
+		for i := k; i < len(models); i++ {
+			models[k], models[i] = models[i], models[k]
+			permute(k + 1)
+			models[k], models[i] = models[i], models[k]
+		}
+	}
+	permute(0)
+}
+
+// tiedProblem is a syntheticProblem whose second column repeats the first,
+// so every subset using one of them has an exact twin using the other.
+func tiedProblem(seed int64, n, numExplanatory int) ([]float64, [][]float64) {
+	y, data := syntheticProblem(rand.New(rand.NewSource(seed)), n, numExplanatory)
+	for _, row := range data {
+		row[1] = row[0]
+	}
+	return y, data
+}
+
+// jitter delays each evaluation by a pseudo-random amount that depends on
+// the subset and the round, so workers finish their units in a different
+// order each round.
+func jitter(round int) func(result) {
+	return func(r result) {
+		h := fnv.New32a()
+		fmt.Fprint(h, round, r.Features)
+		time.Sleep(time.Duration(h.Sum32()%50) * time.Microsecond)
+	}
+}
+
+// TestSearchTiesIndependentOfWorkers checks that exactly tied subsets are
+// ranked the same by the sequential reference and by concurrent searches
+// whose workers finish in different orders.
+func TestSearchTiesIndependentOfWorkers(t *testing.T) {
+	const numExplanatory = 7
+	y, data := tiedProblem(1, 60, numExplanatory)
+	opts := searchOptions{criterion: gaussianAIC{}, top: 6}
+	// The full subset holds both copies of the column, so it can be
+	// singular, and search, unlike naiveSearch, leaves out a size with no
+	// result.
+	var want []sizeResult
+	for _, sr := range naiveSearch(y, data, numExplanatory, opts) {
+		if sr.Best.Features != nil {
+			want = append(want, sr)
+		}
+	}
+
+	// The data must produce ties for the test to mean anything.
+	var ties int
+	for _, sr := range want {
+		for i := 1; i < len(sr.Top); i++ {
+			if sr.Top[i].Score == sr.Top[i-1].Score {
+				ties++
+			}
+		}
+	}
+	if ties == 0 {
+		t.Fatal("no tied scores on the leaderboards")
+	}
+
+	for round, workers := range []int{1, 2, 4, 8, 8, 8} {
+		o := opts
+		o.workers, o.unitSize = workers, 1+round%3
+		o.hooks.OnModelEvaluated = jitter(round)
+		if err := compareResults(search(y, data, numExplanatory, o), want); err != nil {
+			t.Errorf("%d workers, unit size %d: %v", o.workers, o.unitSize, err)
+		}
+	}
+}
+
+// TestSearchEvaluatesEachSubsetOnce counts the subsets the workers report
+// and checks every one of four or more features is fitted exactly once,
+// whatever the number of workers and the unit size. Run it with -race: the
+// hook is called from the worker goroutines.
+func TestSearchEvaluatesEachSubsetOnce(t *testing.T) {
+	const numExplanatory = 9
+	y, data := syntheticProblem(rand.New(rand.NewSource(2)), 50, numExplanatory)
+	var want int
+	for size := 4; size <= numExplanatory; size++ {
+		want += binomial(numExplanatory, size)
+	}
+
+	for _, workers := range []int{1, 3, 8} {
+		for _, unitSize := range []int{1, 7, 1000} {
+			var mu sync.Mutex
+			seen := make(map[string]int)
+			opts := searchOptions{criterion: gaussianAIC{}, workers: workers, unitSize: unitSize, checkInvariants: true}
+			opts.hooks.OnModelEvaluated = func(r result) {
+				mu.Lock()
+				seen[fmt.Sprint(r.Features)]++
+				mu.Unlock()
+			}
+			search(y, data, numExplanatory, opts)
+
+			if len(seen) != want {
+				t.Errorf("%d workers, unit size %d: %d distinct subsets evaluated, want %d", workers, unitSize, len(seen), want)
+			}
+			for subset, n := range seen {
+				if n != 1 {
+					t.Errorf("%d workers, unit size %d: subset %s evaluated %d times", workers, unitSize, subset, n)
+				}
+			}
+		}
+	}
+}
+
+// TestSearchHooks checks the order and arguments of every hook on a
+// one-worker search, whose units are evaluated and merged in plan order:
+// four of sizes 4, 4, 5 and 6 holding 10, 5, 6 and 1 subsets.
+func TestSearchHooks(t *testing.T) {
+	const numExplanatory = 6
+	y, data := syntheticProblem(rand.New(rand.NewSource(4)), 40, numExplanatory)
+	opts := searchOptions{criterion: gaussianAIC{}, workers: 1, unitSize: 10, top: 3}
+
+	// OnModelEvaluated runs on the worker and the other hooks on the
+	// merge, so the two are logged separately.
+	var mu sync.Mutex
+	var evaluated []hooks.Model
+	var events []string
+	var bests []hooks.Model
+	completed := make(map[int]hooks.Size)
+	var evaluatedBefore []int
+	opts.hooks = hooks.Hooks{
+		OnModelEvaluated: func(m hooks.Model) {
+			mu.Lock()
+			evaluated = append(evaluated, m)
+			mu.Unlock()
+		},
+		OnNewBest: func(m hooks.Model) {
+			events = append(events, "best")
+			bests = append(bests, m)
+		},
+		OnSizeComplete: func(size int, s hooks.Size) {
+			events = append(events, fmt.Sprintf("size %d", size))
+			completed[size] = s
+		},
+		OnProgress: func(done, total int) {
+			events = append(events, fmt.Sprintf("progress %d/%d", done, total))
+		},
+		OnUnitTimed: func(size, count int, elapsed time.Duration) {
+			if elapsed <= 0 {
+				t.Errorf("unit of size %d timed at %v", size, elapsed)
+			}
+			mu.Lock()
+			n := len(evaluated)
+			mu.Unlock()
+			events = append(events, fmt.Sprintf("unit %d %d", size, count))
+			evaluatedBefore = append(evaluatedBefore, n)
+		},
+	}
+	results := search(y, data, numExplanatory, opts)
+
+	// Every model passed to OnModelEvaluated is the fit of its subset.
+	if len(evaluated) != 22 {
+		t.Fatalf("%d models evaluated, want 22", len(evaluated))
+	}
+	for _, m := range evaluated {
+		mse, score, err := fitModel(y, m.Features, data, opts)
+		if err != nil || mse != m.MSE || score != m.Score {
+			t.Errorf("evaluated %v, but the fit gives MSE %v and score %v (%v)", m, mse, score, err)
+		}
+	}
+
+	// A unit's subsets are all evaluated before it is timed, though the
+	// worker may have gone on to the next unit.
+	for i, n := range evaluatedBefore {
+		if want := []int{10, 15, 21, 22}[i]; n < want {
+			t.Errorf("unit %d timed after %d evaluations, want at least %d", i, n, want)
+		}
+	}
+
+	// A unit's best becomes the new overall best only if it beats the
+	// previous one.
+	var want []string
+	var wantBests []hooks.Model
+	best := result{Score: math.Inf(1)}
+	done := 0
+	want = append(want, "progress 0/22")
+	for _, u := range []struct{ size, count int }{{4, 10}, {4, 5}, {5, 6}, {6, 1}} {
+		unitBest := result{Score: math.Inf(1)}
+		for _, m := range evaluated[done : done+u.count] {
+			if better(m, unitBest) {
+				unitBest = m
+			}
+		}
+		done += u.count
+		want = append(want, fmt.Sprintf("unit %d %d", u.size, u.count))
+		if better(unitBest, best) {
+			best = unitBest
+			want = append(want, "best")
+			wantBests = append(wantBests, best)
+		}
+		if u.size != 4 || done == 15 {
+			want = append(want, fmt.Sprintf("size %d", u.size))
+		}
+		want = append(want, fmt.Sprintf("progress %d/22", done))
+	}
+	if got, w := fmt.Sprint(events), fmt.Sprint(want); got != w {
+		t.Errorf("hooks called in order\n%s\nwant\n%s", got, w)
+	}
+	if fmt.Sprint(bests) != fmt.Sprint(wantBests) {
+		t.Errorf("OnNewBest got %v, want %v", bests, wantBests)
+	}
+
+	// OnSizeComplete gets the result search returns for the size.
+	for _, sr := range results {
+		size := len(sr.Best.Features)
+		s, ok := completed[size]
+		if !ok {
+			t.Errorf("no OnSizeComplete call for size %d", size)
+			continue
+		}
+		if fmt.Sprint(s.Best, s.Top) != fmt.Sprint(sr.Best, sr.Top) {
+			t.Errorf("size %d completed with %v %v, search returned %v %v", size, s.Best, s.Top, sr.Best, sr.Top)
+		}
+	}
+}
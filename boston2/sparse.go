@@ -0,0 +1,155 @@
+package main
+
+import "math"
+
+// sparseDensity is the fraction of nonzero entries below which l0Search
+// computes its Gram matrix from compressed rows rather than dense columns.
+// Binned and hashed indicator columns are mostly zeros, and the cost of the
+// sparse path grows with the squared number of nonzeros per row instead of
+// with the squared number of columns.
+const sparseDensity = 0.1
+
+// csrMatrix is a matrix in compressed sparse row form: the nonzero entries
+// of row i are val[rowPtr[i]:rowPtr[i+1]], in the columns listed in colIdx
+// over the same range, in increasing order.
+type csrMatrix struct {
+	cols   int
+	rowPtr []int
+	colIdx []int
+	val    []float64
+}
+
+// nonzeroFraction returns the fraction of nonzero entries in the first cols
+// columns of data.
+func nonzeroFraction(data [][]float64, cols int) float64 {
+	if len(data) == 0 || cols == 0 {
+		return 0
+	}
+	var nnz int
+	for _, row := range data {
+		for _, v := range row[:cols] {
+			if v != 0 {
+				nnz++
+			}
+		}
+	}
+	return float64(nnz) / float64(len(data)*cols)
+}
+
+// newCSR compresses the first cols columns of data.
+func newCSR(data [][]float64, cols int) csrMatrix {
+	m := csrMatrix{cols: cols, rowPtr: make([]int, 1, len(data)+1)}
+	for _, row := range data {
+		for j, v := range row[:cols] {
+			if v != 0 {
+				m.colIdx = append(m.colIdx, j)
+				m.val = append(m.val, v)
+			}
+		}
+		m.rowPtr = append(m.rowPtr, len(m.val))
+	}
+	return m
+}
+
+func (m csrMatrix) rows() int { return len(m.rowPtr) - 1 }
+
+// gram returns x'x, accumulated as the sum over rows of the outer products
+// of their nonzero entries. The rows are split into workers chunks, each
+// summed into its own matrix, and the chunks are added in order so that
+// the result does not depend on scheduling.
+func (m csrMatrix) gram(workers int) [][]float64 {
+	n := m.rows()
+	if workers < 1 {
+		workers = 1
+	}
+	chunks := min(workers, max(n, 1))
+	parts := make([][][]float64, chunks)
+	forEachConcurrently(chunks, workers, func(c int) {
+		g := make([][]float64, m.cols)
+		for j := range g {
+			g[j] = make([]float64, m.cols)
+		}
+		for i := c * n / chunks; i < (c+1)*n/chunks; i++ {
+			lo, hi := m.rowPtr[i], m.rowPtr[i+1]
+			for a := lo; a < hi; a++ {
+				ga, va := g[m.colIdx[a]], m.val[a]
+				for b := a; b < hi; b++ {
+					ga[m.colIdx[b]] += va * m.val[b]
+				}
+			}
+		}
+		parts[c] = g
+	})
+
+	g := parts[0]
+	for _, part := range parts[1:] {
+		for j := range g {
+			for k := j; k < m.cols; k++ {
+				g[j][k] += part[j][k]
+			}
+		}
+	}
+	for j := range g {
+		for k := 0; k < j; k++ {
+			g[j][k] = g[k][j]
+		}
+	}
+	return g
+}
+
+// transposeMul returns x'y.
+func (m csrMatrix) transposeMul(y []float64) []float64 {
+	out := make([]float64, m.cols)
+	for i := 0; i < m.rows(); i++ {
+		for a := m.rowPtr[i]; a < m.rowPtr[i+1]; a++ {
+			out[m.colIdx[a]] += m.val[a] * y[i]
+		}
+	}
+	return out
+}
+
+// columnSums returns the sum of each column.
+func (m csrMatrix) columnSums() []float64 {
+	out := make([]float64, m.cols)
+	for a, j := range m.colIdx {
+		out[j] += m.val[a]
+	}
+	return out
+}
+
+// standardizedMoments returns what l0Search needs of the standardized
+// columns without forming them, which would fill in every zero: their
+// Gram matrix over n and their inner products with the centered response
+// yc over n. Centering is applied afterwards, as x_j'x_k - n m_j m_k, and a
+// column whose variance vanishes to within rounding is treated as constant,
+// with zero row and column as gramMatrix gives a nil column.
+func (m csrMatrix) standardizedMoments(yc []float64, workers int) ([][]float64, []float64) {
+	n := float64(m.rows())
+	gram := m.gram(workers)
+	xy := m.transposeMul(yc)
+	mean := m.columnSums()
+	sd := make([]float64, m.cols)
+	for j := range mean {
+		mean[j] /= n
+		ss := gram[j][j] - n*mean[j]*mean[j]
+		if ss > 1e-12*gram[j][j] {
+			sd[j] = math.Sqrt(ss / n)
+		}
+	}
+
+	corr := make([]float64, m.cols)
+	for j := range gram {
+		for k := range gram[j] {
+			if sd[j] == 0 || sd[k] == 0 {
+				gram[j][k] = 0
+				continue
+			}
+			gram[j][k] = (gram[j][k] - n*mean[j]*mean[k]) / (sd[j] * sd[k] * n)
+		}
+		// yc sums to zero, so the mean of column j drops out.
+		if sd[j] != 0 {
+			corr[j] = xy[j] / (sd[j] * n)
+		}
+	}
+	return gram, corr
+}
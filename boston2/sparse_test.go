@@ -0,0 +1,56 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// TestSparseMomentsMatchDense checks the moments l0Search computes from
+// compressed rows against the dense path, on indicator columns, a mostly
+// zero continuous column and a constant column.
+func TestSparseMomentsMatchDense(t *testing.T) {
+	const n, p = 200, 12
+	rng := rand.New(rand.NewSource(5))
+	data := make([][]float64, n)
+	y := make([]float64, n)
+	for i := range data {
+		row := make([]float64, p+1)
+		row[rng.Intn(8)] = 1 // one-hot over columns 0-7
+		if rng.Float64() < 0.05 {
+			row[8] = rng.NormFloat64()
+		}
+		row[9] = 2.5
+		row[10+rng.Intn(2)] = 1
+		y[i] = 3*row[2] - row[8] + rng.NormFloat64()
+		row[p] = y[i]
+		data[i] = row
+	}
+	var mean float64
+	for _, v := range y {
+		mean += v / n
+	}
+	yc := make([]float64, n)
+	for i, v := range y {
+		yc[i] = v - mean
+	}
+
+	m := newCSR(data, p)
+	if got := nonzeroFraction(data, p); got != float64(len(m.val))/(n*p) {
+		t.Errorf("nonzeroFraction = %v, but the matrix has %d nonzeros", got, len(m.val))
+	}
+	wantGram, wantCorr := denseMoments(data, yc, p, 4, 1)
+	for _, workers := range []int{1, 3} {
+		gram, corr := m.standardizedMoments(yc, workers)
+		for j := range wantGram {
+			if math.Abs(corr[j]-wantCorr[j]) > 1e-12 {
+				t.Errorf("%d workers: corr[%d] = %v, want %v", workers, j, corr[j], wantCorr[j])
+			}
+			for k := range wantGram[j] {
+				if math.Abs(gram[j][k]-wantGram[j][k]) > 1e-12 {
+					t.Errorf("%d workers: gram[%d][%d] = %v, want %v", workers, j, k, gram[j][k], wantGram[j][k])
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// modelSpill records every evaluated model without holding them all in
+// memory: once limit models are buffered they are sorted by score and
+// written to a temporary run file, and WriteCSV merges the runs.
+type modelSpill struct {
+	mu    sync.Mutex
+	limit int
+	dir   string
+	buf   []result
+	runs  []string
+	err   error
+}
+
+func newModelSpill(limit int) (*modelSpill, error) {
+	if limit < 1 {
+		limit = 1
+	}
+	dir, err := os.MkdirTemp("", "boston2-models-")
+	if err != nil {
+		return nil, err
+	}
+	return &modelSpill{limit: limit, dir: dir}, nil
+}
+
+// Add records m. It is safe for concurrent use, so it can serve as an
+// OnModelEvaluated hook.
+func (s *modelSpill) Add(m result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buf = append(s.buf, m)
+	if len(s.buf) >= s.limit && s.err == nil {
+		s.err = s.flush()
+	}
+}
+
+// flush writes the buffered models, sorted, to a new run file.
+func (s *modelSpill) flush() error {
+	if len(s.buf) == 0 {
+		return nil
+	}
+	sort.Slice(s.buf, func(i, j int) bool { return better(s.buf[i], s.buf[j]) })
+
+	path := filepath.Join(s.dir, fmt.Sprintf("run%d", len(s.runs)))
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, m := range s.buf {
+		writeRunRecord(w, m)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	s.runs = append(s.runs, path)
+	s.buf = s.buf[:0]
+	return nil
+}
+
+// writeRunRecord appends m to a run file: score and MSE, the number of
+// features, then the feature indices.
+func writeRunRecord(w io.Writer, m result) {
+	binary.Write(w, binary.LittleEndian, m.Score)
+	binary.Write(w, binary.LittleEndian, m.MSE)
+	binary.Write(w, binary.LittleEndian, uint32(len(m.Features)))
+	for _, j := range m.Features {
+		binary.Write(w, binary.LittleEndian, uint32(j))
+	}
+}
+
+// readRunRecord reads the next model from a run file, returning io.EOF
+// after the last.
+func readRunRecord(r io.Reader) (result, error) {
+	var m result
+	if err := binary.Read(r, binary.LittleEndian, &m.Score); err != nil {
+		return m, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &m.MSE); err != nil {
+		return m, err
+	}
+	var k uint32
+	if err := binary.Read(r, binary.LittleEndian, &k); err != nil {
+		return m, err
+	}
+	idx := make([]uint32, k)
+	if err := binary.Read(r, binary.LittleEndian, idx); err != nil {
+		return m, err
+	}
+	m.Features = make([]int, k)
+	for i, j := range idx {
+		m.Features[i] = int(j)
+	}
+	return m, nil
+}
+
+// WriteCSV spills what is left in memory and merges all runs into a CSV
+// file at path, best model first, with features named by names.
+func (s *modelSpill) WriteCSV(path string, names []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	if err := s.flush(); err != nil {
+		return err
+	}
+
+	var h runHeap
+	for _, run := range s.runs {
+		f, err := os.Open(run)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r := bufio.NewReader(f)
+		m, err := readRunRecord(r)
+		if err == io.EOF {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", run, err)
+		}
+		h = append(h, runHead{m, r})
+	}
+	heap.Init(&h)
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"score", "mse", "size", "features"})
+	for h.Len() > 0 {
+		m := h[0].m
+		w.Write([]string{
+			strconv.FormatFloat(m.Score, 'g', -1, 64),
+			strconv.FormatFloat(m.MSE, 'g', -1, 64),
+			strconv.Itoa(len(m.Features)),
+			strings.Join(featureNames(names, m.Features), " "),
+		})
+
+		next, err := readRunRecord(h[0].r)
+		switch {
+		case err == io.EOF:
+			heap.Pop(&h)
+		case err != nil:
+			f.Close()
+			return fmt.Errorf("failed to read run: %v", err)
+		default:
+			h[0].m = next
+			heap.Fix(&h, 0)
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Close removes the run files.
+func (s *modelSpill) Close() error {
+	return os.RemoveAll(s.dir)
+}
+
+// runHead is the next unmerged model of a run.
+type runHead struct {
+	m result
+	r io.Reader
+}
+
+// runHeap orders run heads best model first.
+type runHeap []runHead
+
+func (h runHeap) Len() int { return len(h) }
+
+func (h runHeap) Less(i, j int) bool { return better(h[i].m, h[j].m) }
+
+func (h runHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *runHeap) Push(x any) { *h = append(*h, x.(runHead)) }
+
+func (h *runHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
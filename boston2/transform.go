@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+// responseTransform is a transformation of the response tried by
+// -transform-search.
+type responseTransform struct {
+	Name    string
+	Lambda  float64
+	Forward func(y float64) float64
+	Inverse func(z float64) float64
+	// LogJacobian is log|dT/dy|; summed over the observations it moves the
+	// criterion of a model of T(y) onto the scale of y.
+	LogJacobian func(y float64) float64
+	// Params counts parameters estimated for the transformation itself.
+	Params int
+}
+
+var (
+	identityTransform = responseTransform{
+		Name:        "none",
+		Forward:     func(y float64) float64 { return y },
+		Inverse:     func(z float64) float64 { return z },
+		LogJacobian: func(y float64) float64 { return 0 },
+	}
+	logTransform = responseTransform{
+		Name:        "log",
+		Forward:     math.Log,
+		Inverse:     math.Exp,
+		LogJacobian: func(y float64) float64 { return -math.Log(y) },
+	}
+	sqrtTransform = responseTransform{
+		Name:        "sqrt",
+		Forward:     math.Sqrt,
+		Inverse:     func(z float64) float64 { return z * z },
+		LogJacobian: func(y float64) float64 { return -math.Log(2 * math.Sqrt(y)) },
+	}
+)
+
+// boxCox returns the Box-Cox transformation (y^lambda - 1) / lambda.
+func boxCox(lambda float64) responseTransform {
+	if math.Abs(lambda) < 1e-9 {
+		t := logTransform
+		t.Name = "box-cox"
+		t.Params = 1
+		return t
+	}
+	return responseTransform{
+		Name:    "box-cox",
+		Lambda:  lambda,
+		Forward: func(y float64) float64 { return (math.Pow(y, lambda) - 1) / lambda },
+		Inverse: func(z float64) float64 {
+			return math.Pow(math.Max(lambda*z+1, 0), 1/lambda)
+		},
+		LogJacobian: func(y float64) float64 { return (lambda - 1) * math.Log(y) },
+		Params:      1,
+	}
+}
+
+// estimateBoxCoxLambda picks the Box-Cox lambda in [-2, 2] maximising the
+// profile log-likelihood of the model with every explanatory variable,
+// fitted as opts fits the search. Lambdas whose fit fails, or fits exactly
+// so the likelihood is unbounded, are passed over; ok is false if every
+// one is.
+func estimateBoxCoxLambda(y []float64, data [][]float64, numExplanatory int, opts searchOptions) (lambda float64, ok bool) {
+	all := make([]int, numExplanatory)
+	for i := range all {
+		all[i] = i
+	}
+
+	var sumLogY float64
+	for _, v := range y {
+		sumLogY += math.Log(v)
+	}
+
+	bestLambda, bestLL := 1.0, math.Inf(-1)
+	for step := -200; step <= 200; step++ {
+		lambda := float64(step) / 100
+		z := transformResponse(y, boxCox(lambda))
+		mse, _, err := fitModel(z, all, data, opts)
+		if err != nil || !(mse > 0) || math.IsInf(mse, 1) {
+			continue
+		}
+		ll := -float64(len(y))/2*math.Log(mse) + (lambda-1)*sumLogY
+		if ll > bestLL {
+			bestLambda, bestLL, ok = lambda, ll, true
+		}
+	}
+	return bestLambda, ok
+}
+
+func transformResponse(y []float64, t responseTransform) []float64 {
+	z := make([]float64, len(y))
+	for i, v := range y {
+		z[i] = t.Forward(v)
+	}
+	return z
+}
+
+// searchTransforms reruns the search on each valid transformation of the
+// response and reports which transformation and subset give the lowest
+// criterion on the original scale. MSE is computed from back-transformed predictions.
+// Moving a criterion between scales adds the log Jacobian to its
+// log-likelihood, so opts.criterion must be a likelihood criterion, not
+// gPrior. A transformation whose best model can't predict is skipped.
+func searchTransforms(w io.Writer, y []float64, data [][]float64, numExplanatory int, opts searchOptions) {
+	minY := math.Inf(1)
+	for _, v := range y {
+		minY = math.Min(minY, v)
+	}
+
+	transforms := []responseTransform{identityTransform}
+	if minY > 0 {
+		transforms = append(transforms, logTransform, sqrtTransform)
+		if lambda, ok := estimateBoxCoxLambda(y, data, numExplanatory, opts); ok {
+			transforms = append(transforms, boxCox(lambda))
+		} else {
+			fmt.Fprintln(w, "\nSkipping the Box-Cox transformation: no lambda gave a usable fit")
+		}
+	} else {
+		fmt.Fprintln(w, "\nSkipping log, sqrt and Box-Cox transformations: response is not strictly positive")
+	}
+
+	fmt.Fprintln(w, "\nResponse Transformations:")
+	fmt.Fprintf(w, "%-8s %8s %12s %12s  %s\n", "Name", "Lambda", opts.criterion.Name(), "MSE", "Features")
+
+	var bestName string
+	bestScore := math.Inf(1)
+	for _, t := range transforms {
+		z := transformResponse(y, t)
+		best := bestOf(search(z, data, numExplanatory, opts))
+
+		var logJacobian float64
+		for _, v := range y {
+			logJacobian += t.LogJacobian(v)
+		}
+		score := best.Score - 2*logJacobian + 2*float64(t.Params)
+
+		r := opts.train(z, best.Features, data)
+		var rss kahanSum
+		var err error
+		for i, row := range data {
+			var zPred float64
+			if zPred, err = r.Predict(featureRow(row, best.Features)); err != nil {
+				break
+			}
+			rss.Add(math.Pow(y[i]-t.Inverse(zPred), 2))
+		}
+		if err != nil {
+			fmt.Fprintf(w, "%-8s %8.2f  skipped: failed to predict: %v\n", t.Name, t.Lambda, err)
+			continue
+		}
+		mse := rss.sum / float64(len(data))
+
+		fmt.Fprintf(w, "%-8s %8.2f %12.4f %12.4f  %v\n", t.Name, t.Lambda, score, mse, best.Features)
+		if score < bestScore {
+			bestName, bestScore = t.Name, score
+		}
+	}
+	fmt.Fprintf(w, "Best Transformation: %s\n", bestName)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestEstimateBoxCoxLambda(t *testing.T) {
+	const numExplanatory = 4
+	rng := rand.New(rand.NewSource(5))
+	_, data := syntheticProblem(rng, 200, numExplanatory)
+	y := make([]float64, len(data))
+	for i, row := range data {
+		y[i] = math.Exp(1 + 0.5*row[0] - 0.3*row[1] + 0.1*rng.NormFloat64())
+	}
+	opts := searchOptions{criterion: gaussianAIC{}}
+
+	// A log-normal response wants lambda near 0.
+	if lambda, ok := estimateBoxCoxLambda(y, data, numExplanatory, opts); !ok || math.Abs(lambda) > 0.1 {
+		t.Errorf("lambda = %v, %v; want about 0", lambda, ok)
+	}
+
+	// With a column repeated every fit is singular, so there is no estimate.
+	for _, row := range data {
+		row[1] = row[0]
+	}
+	if lambda, ok := estimateBoxCoxLambda(y, data, numExplanatory, opts); ok {
+		t.Errorf("singular fits gave lambda %v", lambda)
+	}
+}
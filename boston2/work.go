@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/cc1358/Week-6-Assignment-Exploring-Concurrency/hooks"
+)
+
+// workUnit is a range of combinations of one size, identified by their ranks
+// Start to End-1 in lexicographic order.
+type workUnit struct {
+	ID         int
+	Size       int
+	Start, End int
+}
+
+// unitResult is what a worker reports for a work unit: how many
+// combinations it evaluated and the unit's share of the size result.
+type unitResult struct {
+	ID    int
+	Count int
+	// Elapsed is the time the worker spent on the unit.
+	Elapsed time.Duration
+	sizeResult
+}
+
+// planWork splits the combinations of each size from minSize to n into work
+// units of at most unitSize combinations, or sizes[size] if that is set,
+// numbered from 0.
+func planWork(n, minSize, unitSize int, sizes map[int]int) []workUnit {
+	var units []workUnit
+	for size := minSize; size <= n; size++ {
+		per := unitSize
+		if s, ok := sizes[size]; ok {
+			per = s
+		}
+		if per < 1 {
+			per = 1
+		}
+		total := binomial(n, size)
+		for start := 0; start < total; start += per {
+			end := start + per
+			if end > total {
+				end = total
+			}
+			units = append(units, workUnit{ID: len(units), Size: size, Start: start, End: end})
+		}
+	}
+	return units
+}
+
+// remainingWork returns the parts of units not covered by the done ranges,
+// renumbered from 0. Ranges are compared by size and rank, not by unit ID,
+// so a checkpoint from a run with a different -unit-size or -workers still
+// applies.
+func remainingWork(units []workUnit, done []completedRange) []workUnit {
+	bySize := make(map[int][]completedRange)
+	for _, r := range done {
+		bySize[r.Size] = append(bySize[r.Size], r)
+	}
+	for _, ranges := range bySize {
+		sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start < ranges[j].Start })
+	}
+
+	var remaining []workUnit
+	for _, u := range units {
+		start := u.Start
+		for _, r := range bySize[u.Size] {
+			if r.End <= start || r.Start >= u.End {
+				continue
+			}
+			if r.Start > start {
+				remaining = append(remaining, workUnit{ID: len(remaining), Size: u.Size, Start: start, End: r.Start})
+			}
+			if r.End > start {
+				start = r.End
+			}
+		}
+		if start < u.End {
+			remaining = append(remaining, workUnit{ID: len(remaining), Size: u.Size, Start: start, End: u.End})
+		}
+	}
+	return remaining
+}
+
+// checkpointSchemaVersion is the version of checkpoint written by this
+// build; openCheckpoint refuses newer ones.
+const checkpointSchemaVersion = 1
+
+// checkpoint is the -checkpoint file: the combination ranges finished so
+// far, each with its share of the size result. Key identifies the data and
+// options, so progress is never merged into a different search.
+type checkpoint struct {
+	SchemaVersion int              `json:"schema_version"`
+	Key           string           `json:"key"`
+	Done          []completedRange `json:"done"`
+}
+
+// completedRange is a finished range of ranks Start to End-1 among the
+// combinations of one size.
+type completedRange struct {
+	Size  int `json:"size"`
+	Start int `json:"start"`
+	End   int `json:"end"`
+	Count int `json:"count"`
+	// Best is nil if no subset in the range was fitted.
+	Best  *result         `json:"best,omitempty"`
+	Front []frontierModel `json:"front,omitempty"`
+	Top   []result        `json:"top,omitempty"`
+}
+
+func (r completedRange) sizeResult() sizeResult {
+	sr := sizeResult{Best: result{Score: math.Inf(1)}, Front: r.Front, Top: r.Top}
+	if r.Best != nil {
+		sr.Best = *r.Best
+	}
+	return sr
+}
+
+// checkpointer records completed ranges, saving them to path at most once
+// per interval. It is only used from the search's merge loop.
+type checkpointer struct {
+	path     string
+	interval time.Duration
+	saved    time.Time
+	state    checkpoint
+}
+
+// checkpointKey identifies a search by its data, every search option and
+// configuration setting that can change its results, the column groups
+// that enter or leave models together, and the largest subset size.
+// Options that only divide or observe the work are left out, so a search
+// can resume with more workers or a different unit size. The filter is a
+// function and cannot be hashed; the settings it is built from stand in
+// for it.
+func checkpointKey(names []string, data [][]float64, opts searchOptions, cfg runConfig, groups [][]string, maxSize int) string {
+	var key bytes.Buffer
+	key.WriteString(hashData(names, data))
+	opts.checkInvariants, opts.workers, opts.unitSize, opts.unitSizes = false, 0, 0, nil
+	opts.budget, opts.hooks, opts.filter, opts.checkpoint, opts.audit = nil, hooks.Hooks{}, nil, nil, nil
+	opts.gramBlock = 0
+	// Nor do the runtime settings or the column descriptions.
+	cfg.GOGC, cfg.MemoryLimit, cfg.Metadata = nil, "", nil
+	writeKey(&key, reflect.ValueOf(opts))
+	writeKey(&key, reflect.ValueOf(cfg))
+	writeKey(&key, reflect.ValueOf(groups))
+	fmt.Fprintf(&key, "max size %d", maxSize)
+	sum := sha256.Sum256(key.Bytes())
+	return hex.EncodeToString(sum[:])
+}
+
+// openCheckpoint starts a checkpoint at path. With resume, the ranges
+// already done in an existing file are loaded, provided its key matches.
+func openCheckpoint(path, key string, interval time.Duration, resume bool) (*checkpointer, error) {
+	c := &checkpointer{path: path, interval: interval, saved: time.Now()}
+	c.state = checkpoint{SchemaVersion: checkpointSchemaVersion, Key: key}
+	if !resume {
+		return c, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %v", err)
+	}
+	var prev checkpoint
+	if err := json.Unmarshal(b, &prev); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint %s: %v", path, err)
+	}
+	if prev.SchemaVersion > checkpointSchemaVersion {
+		return nil, fmt.Errorf("checkpoint schema version %d is newer than this build supports (%d)", prev.SchemaVersion, checkpointSchemaVersion)
+	}
+	if prev.Key != key {
+		return nil, fmt.Errorf("checkpoint %s is for different data or options", path)
+	}
+	c.state.Done = prev.Done
+	return c, nil
+}
+
+// record adds a finished unit and saves if the interval has passed.
+func (c *checkpointer) record(u workUnit, ur unitResult) error {
+	r := completedRange{Size: u.Size, Start: u.Start, End: u.End, Count: ur.Count, Front: ur.Front, Top: ur.Top}
+	if ur.Best.Features != nil {
+		best := ur.Best
+		r.Best = &best
+	}
+	c.state.Done = append(c.state.Done, r)
+	if time.Since(c.saved) < c.interval {
+		return nil
+	}
+	return c.save()
+}
+
+// save writes the checkpoint through a temporary file, so a crash mid-save
+// leaves the previous checkpoint intact.
+func (c *checkpointer) save() error {
+	b, err := json.Marshal(c.state)
+	if err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	c.saved = time.Now()
+	return os.Rename(tmp, c.path)
+}
+
+// latencyProfile records the observed time per fit of each subset size,
+// so that later runs can size work units to take about the same time
+// rather than the same number of combinations: with a fixed unit size the
+// units of the largest subsets are the slowest, and the search can end
+// with a few workers finishing them while the rest sit idle.
+type latencyProfile struct {
+	SchemaVersion int                 `json:"schema_version"`
+	Sizes         map[int]sizeLatency `json:"sizes"`
+}
+
+// sizeLatency is the total time spent on the fits of one subset size in
+// the last run that fitted it.
+type sizeLatency struct {
+	Fits  int           `json:"fits"`
+	Total time.Duration `json:"total_ns"`
+}
+
+const latencyProfileSchemaVersion = 1
+
+// loadLatencyProfile reads the profile at path, or returns an empty one if
+// there is none yet.
+func loadLatencyProfile(path string) (*latencyProfile, error) {
+	p := &latencyProfile{SchemaVersion: latencyProfileSchemaVersion, Sizes: make(map[int]sizeLatency)}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read latency profile: %v", err)
+	}
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, fmt.Errorf("failed to parse latency profile %s: %v", path, err)
+	}
+	if p.SchemaVersion > latencyProfileSchemaVersion {
+		return nil, fmt.Errorf("latency profile schema version %d is newer than this build supports (%d)", p.SchemaVersion, latencyProfileSchemaVersion)
+	}
+	if p.Sizes == nil {
+		p.Sizes = make(map[int]sizeLatency)
+	}
+	p.SchemaVersion = latencyProfileSchemaVersion
+	return p, nil
+}
+
+// unitSizes returns the number of combinations per work unit for each
+// profiled size, scaled so that a unit takes about as long as unitSize
+// fits at the average latency over all the profiled fits. Units are kept
+// between 1 and 16 times unitSize combinations.
+func (p *latencyProfile) unitSizes(unitSize int) map[int]int {
+	var fits int
+	var total time.Duration
+	for _, l := range p.Sizes {
+		fits += l.Fits
+		total += l.Total
+	}
+	if fits == 0 || total <= 0 {
+		return nil
+	}
+	average := float64(total) / float64(fits)
+
+	sizes := make(map[int]int)
+	for size, l := range p.Sizes {
+		if l.Fits == 0 || l.Total <= 0 {
+			continue
+		}
+		latency := float64(l.Total) / float64(l.Fits)
+		n := int(math.Round(float64(unitSize) * average / latency))
+		sizes[size] = max(1, min(n, 16*unitSize))
+	}
+	return sizes
+}
+
+// timer returns an OnUnitTimed hook that collects this run's latencies.
+// Sizes fitted in this run replace their old entries when the profile is
+// saved; the rest are kept.
+func (p *latencyProfile) timer() (hook func(size, count int, elapsed time.Duration), commit func()) {
+	run := make(map[int]sizeLatency)
+	hook = func(size, count int, elapsed time.Duration) {
+		l := run[size]
+		l.Fits += count
+		l.Total += elapsed
+		run[size] = l
+	}
+	commit = func() {
+		for size, l := range run {
+			p.Sizes[size] = l
+		}
+	}
+	return hook, commit
+}
+
+// save writes the profile to path through a temporary file, as
+// checkpointer.save does.
+func (p *latencyProfile) save(path string) error {
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
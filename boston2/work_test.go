@@ -0,0 +1,120 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestCheckpointKeyCoversOptions changes each search option and
+// configuration setting in turn and checks that it changes the checkpoint
+// key, apart from those that don't change the search's results.
+func TestCheckpointKeyCoversOptions(t *testing.T) {
+	names := []string{"a", "b", "y"}
+	data := [][]float64{{1, 2, 3}, {4, 5, 6}}
+	gogc, ten, upper := 400, 10.0, 0.99
+
+	optionChanges := map[string]func(o *searchOptions){
+		"compensated":       func(o *searchOptions) { o.compensated = true },
+		"throughOrigin":     func(o *searchOptions) { o.throughOrigin = true },
+		"pareto":            func(o *searchOptions) { o.pareto = true },
+		"folds":             func(o *searchOptions) { o.folds = 10 },
+		"criterion":         func(o *searchOptions) { o.criterion = aicc{} },
+		"top":               func(o *searchOptions) { o.top = 5 },
+		"fitTimeout":        func(o *searchOptions) { o.fitTimeout = time.Second },
+		"fitIterations":     func(o *searchOptions) { o.fitIterations = 50 },
+		"totalLeastSquares": func(o *searchOptions) { o.totalLeastSquares = true },
+		"randomIntercept":   func(o *searchOptions) { o.randomIntercept = true },
+		"groupColumn":       func(o *searchOptions) { o.groupColumn = 1 },
+	}
+	optionsUnchanged := map[string]func(o *searchOptions){
+		"checkInvariants": func(o *searchOptions) { o.checkInvariants = true },
+		"workers":         func(o *searchOptions) { o.workers = 8 },
+		"unitSize":        func(o *searchOptions) { o.unitSize = 7 },
+		"unitSizes":       func(o *searchOptions) { o.unitSizes = map[int]int{4: 3} },
+		"budget":          func(o *searchOptions) { o.budget = &fitBudget{} },
+		"hooks":           func(o *searchOptions) { o.hooks.OnProgress = func(int, int) {} },
+		"filter":          func(o *searchOptions) { o.filter = sizeFilter(1) },
+		"checkpoint":      func(o *searchOptions) { o.checkpoint = &checkpointer{} },
+		"audit":           func(o *searchOptions) { o.audit = &skipAudit{} },
+		"gramBlock":       func(o *searchOptions) { o.gramBlock = 16 },
+	}
+	configChanges := map[string]func(c *runConfig){
+		"Derived":      func(c *runConfig) { c.Derived = map[string]string{"ab": "a * b"} },
+		"Exclude":      func(c *runConfig) { c.Exclude = [][]string{{"a", "b"}} },
+		"Bins":         func(c *runConfig) { c.Bins = map[string]binSpec{"a": {Method: "quantile", Count: 4}} },
+		"Splines":      func(c *runConfig) { c.Splines = map[string]splineSpec{"a": {Knots: 4}} },
+		"TargetEncode": func(c *runConfig) { c.TargetEncode = map[string]targetEncoding{"a": {Smoothing: &ten}} },
+		"Dates":        func(c *runConfig) { c.Dates = map[string]dateSpec{"a": {Features: []string{"month"}}} },
+		"Numbers":      func(c *runConfig) { c.Numbers = numberFormat{Decimal: ","} },
+		"Monotonic":    func(c *runConfig) { c.Monotonic = map[string]monotonicSpec{"a": {Direction: "increasing"}} },
+		"MinEffect":    func(c *runConfig) { c.MinEffect = map[string]float64{"a": 0.05} },
+		"Protected":    func(c *runConfig) { c.Protected = []string{"a"} },
+		"Signs":        func(c *runConfig) { c.Signs = map[string]string{"a": "non-negative"} },
+		"Winsorize":    func(c *runConfig) { c.Winsorize = map[string]winsorSpec{"a": {Upper: &upper}} },
+		"HashEncode":   func(c *runConfig) { c.HashEncode = map[string]hashSpec{"a": {Buckets: 8}} },
+	}
+	configUnchanged := map[string]func(c *runConfig){
+		"GOGC":        func(c *runConfig) { c.GOGC = &gogc },
+		"MemoryLimit": func(c *runConfig) { c.MemoryLimit = "4GiB" },
+		"Metadata":    func(c *runConfig) { c.Metadata = columnMetadata{"a": {Unit: "room"}} },
+	}
+
+	typ := reflect.TypeOf(searchOptions{})
+	for i := 0; i < typ.NumField(); i++ {
+		if name := typ.Field(i).Name; optionChanges[name] == nil && optionsUnchanged[name] == nil {
+			t.Errorf("searchOptions.%s has no test case; add one here", name)
+		}
+	}
+	typ = reflect.TypeOf(runConfig{})
+	for i := 0; i < typ.NumField(); i++ {
+		if name := typ.Field(i).Name; configChanges[name] == nil && configUnchanged[name] == nil {
+			t.Errorf("runConfig.%s has no test case; add one here", name)
+		}
+	}
+
+	baseOpts := searchOptions{criterion: gaussianAIC{}, groupColumn: -1}
+	base := checkpointKey(names, data, baseOpts, runConfig{}, nil, 2)
+	if again := checkpointKey(names, data, baseOpts, runConfig{}, nil, 2); again != base {
+		t.Fatalf("key of the same search changed: %s, then %s", base, again)
+	}
+	for name, change := range optionChanges {
+		opts := baseOpts
+		change(&opts)
+		if checkpointKey(names, data, opts, runConfig{}, nil, 2) == base {
+			t.Errorf("changing %s keeps the key", name)
+		}
+	}
+	for name, change := range optionsUnchanged {
+		opts := baseOpts
+		change(&opts)
+		if checkpointKey(names, data, opts, runConfig{}, nil, 2) != base {
+			t.Errorf("changing %s changes the key", name)
+		}
+	}
+	for name, change := range configChanges {
+		var cfg runConfig
+		change(&cfg)
+		if checkpointKey(names, data, baseOpts, cfg, nil, 2) == base {
+			t.Errorf("changing %s keeps the key", name)
+		}
+	}
+	for name, change := range configUnchanged {
+		var cfg runConfig
+		change(&cfg)
+		if checkpointKey(names, data, baseOpts, cfg, nil, 2) != base {
+			t.Errorf("changing %s changes the key", name)
+		}
+	}
+
+	for name, key := range map[string]string{
+		"data":     checkpointKey(names, [][]float64{{1, 2, 3}, {4, 5, 7}}, baseOpts, runConfig{}, nil, 2),
+		"names":    checkpointKey([]string{"a", "c", "y"}, data, baseOpts, runConfig{}, nil, 2),
+		"groups":   checkpointKey(names, data, baseOpts, runConfig{}, [][]string{{"a", "b"}}, 2),
+		"max size": checkpointKey(names, data, baseOpts, runConfig{}, nil, 1),
+	} {
+		if key == base {
+			t.Errorf("changing the %s keeps the key", name)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+// Package dataset holds the in-memory datasets the boston2 best-subset
+// search works on: one row of float64 values per observation, the response
+// in the last column.
+//
+// The boston2 reader builds its datasets from CSV, TSV, JSON Lines and
+// XLSX files with FromRows; programs that already hold their data in
+// memory can call FromRows or FromColumns directly and skip the file
+// formats altogether.
+package dataset
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Dataset is a table of observations. Names holds one name per column, the
+// response last, and each row of Rows holds one value per name.
+type Dataset struct {
+	Names []string
+	Rows  [][]float64
+}
+
+// FromRows builds a dataset from rows already in memory. headers names the
+// columns, the last being the response, and every row must have one value
+// per header. The dataset shares rows with the caller, so changing a value
+// changes both; copy them first to keep them apart.
+func FromRows(rows [][]float64, headers []string) (*Dataset, error) {
+	if len(headers) < 2 {
+		return nil, fmt.Errorf("need at least one explanatory column and a response, got %d columns", len(headers))
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("no rows")
+	}
+	for i, row := range rows {
+		if len(row) != len(headers) {
+			return nil, fmt.Errorf("row %d has %d values, want %d", i, len(row), len(headers))
+		}
+	}
+	return &Dataset{Names: append([]string(nil), headers...), Rows: rows}, nil
+}
+
+// FromColumns builds a dataset from named columns held in memory, which
+// must all have the same length. The response column comes last and the
+// explanatory columns precede it in name order, since a map has none. The
+// values are copied into new rows.
+func FromColumns(columns map[string][]float64, response string) (*Dataset, error) {
+	y, ok := columns[response]
+	if !ok {
+		return nil, fmt.Errorf("unknown response column %q", response)
+	}
+
+	var names []string
+	for name := range columns {
+		if name != response {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	names = append(names, response)
+
+	rows := make([][]float64, len(y))
+	for i := range rows {
+		rows[i] = make([]float64, len(names))
+	}
+	for j, name := range names {
+		x := columns[name]
+		if len(x) != len(y) {
+			return nil, fmt.Errorf("column %q has %d values, want %d", name, len(x), len(y))
+		}
+		for i, v := range x {
+			rows[i][j] = v
+		}
+	}
+	return FromRows(rows, names)
+}
@@ -0,0 +1,62 @@
+package dataset
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFromRows(t *testing.T) {
+	rows := [][]float64{{1, 2, 3}, {4, 5, 6}}
+	d, err := FromRows(rows, []string{"a", "b", "y"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(d.Names, []string{"a", "b", "y"}) || !reflect.DeepEqual(d.Rows, rows) {
+		t.Errorf("got %v %v", d.Names, d.Rows)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		rows    [][]float64
+		headers []string
+	}{
+		{"short row", [][]float64{{1, 2, 3}, {4, 5}}, []string{"a", "b", "y"}},
+		{"long row", [][]float64{{1, 2, 3, 4}}, []string{"a", "b", "y"}},
+		{"no rows", nil, []string{"a", "y"}},
+		{"response only", [][]float64{{1}}, []string{"y"}},
+	} {
+		if _, err := FromRows(tt.rows, tt.headers); err == nil {
+			t.Errorf("%s: FromRows succeeded", tt.name)
+		}
+	}
+}
+
+func TestFromColumns(t *testing.T) {
+	// The explanatory columns come out in name order whatever the order
+	// they were written in, and the response last.
+	d, err := FromColumns(map[string][]float64{
+		"y":    {10, 20},
+		"zeta": {5, 6},
+		"a":    {1, 2},
+		"mid":  {3, 4},
+	}, "y")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a", "mid", "zeta", "y"}; !reflect.DeepEqual(d.Names, want) {
+		t.Errorf("names %v, want %v", d.Names, want)
+	}
+	if want := [][]float64{{1, 3, 5, 10}, {2, 4, 6, 20}}; !reflect.DeepEqual(d.Rows, want) {
+		t.Errorf("rows %v, want %v", d.Rows, want)
+	}
+
+	if _, err := FromColumns(map[string][]float64{"a": {1}, "b": {2}}, "y"); err == nil {
+		t.Error("FromColumns succeeded without the response column")
+	}
+	if _, err := FromColumns(map[string][]float64{"a": {1, 2}, "y": {1, 2, 3}}, "y"); err == nil {
+		t.Error("FromColumns succeeded with columns of different lengths")
+	}
+	if _, err := FromColumns(map[string][]float64{"y": {1, 2}}, "y"); err == nil {
+		t.Error("FromColumns succeeded without an explanatory column")
+	}
+}
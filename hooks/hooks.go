@@ -0,0 +1,41 @@
+// Package hooks defines the callbacks through which a program embedding
+// the boston2 best-subset search streams its intermediate results, e.g. to
+// a UI or a database, instead of polling files.
+package hooks
+
+import "time"
+
+// Model is one fitted subset: the indices of its features in increasing
+// order, its selection criterion (lower is better) and its in-sample MSE.
+type Model struct {
+	Features []int
+	Score    float64
+	MSE      float64
+}
+
+// Size is the merged result of every subset of one size: the best model
+// and, if the search keeps leaderboards, the top models in rank order.
+type Size struct {
+	Best Model
+	Top  []Model
+}
+
+// Hooks are called as the search runs. Any hook may be nil.
+type Hooks struct {
+	// OnModelEvaluated is called for every subset fitted. It runs on the
+	// worker goroutines, so it must be safe for concurrent use, and it
+	// should be quick: it holds up the worker that called it.
+	OnModelEvaluated func(Model)
+	// OnNewBest is called each time the overall best model so far changes.
+	OnNewBest func(Model)
+	// OnSizeComplete is called once all subsets of a size are evaluated,
+	// with that size's merged result.
+	OnSizeComplete func(size int, s Size)
+	// OnProgress is called with the number of combinations done out of
+	// total: once before any work unit of this run completes, counting
+	// those restored from a checkpoint, then after each unit.
+	OnProgress func(done, total int)
+	// OnUnitTimed is called after each work unit with its subset size,
+	// the number of combinations in it and how long the worker took.
+	OnUnitTimed func(size, count int, elapsed time.Duration)
+}
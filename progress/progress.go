@@ -0,0 +1,140 @@
+// Package progress reports how far a long computation, such as an
+// exhaustive model search, has got, with an estimate of the time left.
+//
+// A Meter is fed the number of items done out of a total and turns those
+// counts into throttled Snapshots for a Reporter: Console draws a status
+// line, JSONLines writes one object per line, Silent discards everything
+// and Func hands each snapshot to a function, for programs embedding the
+// computation.
+package progress
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Snapshot is the state of a running computation.
+type Snapshot struct {
+	Done  int `json:"done"`
+	Total int `json:"total"`
+	// Elapsed is the time since the first update; ETA estimates the time
+	// left from the rate since then, ignoring the items already done at
+	// the first update, e.g. restored from a checkpoint. ETA is zero until
+	// an item completes.
+	Elapsed time.Duration `json:"elapsed_ns"`
+	ETA     time.Duration `json:"eta_ns"`
+}
+
+// Reporter consumes snapshots. A Meter calls Report from one goroutine at
+// a time.
+type Reporter interface {
+	Report(s Snapshot)
+}
+
+// Func adapts a function to a Reporter.
+type Func func(Snapshot)
+
+func (f Func) Report(s Snapshot) { f(s) }
+
+// Silent discards every snapshot.
+type Silent struct{}
+
+func (Silent) Report(Snapshot) {}
+
+// Console redraws a single status line on W, ending it once the
+// computation is done. Items names what is counted, e.g. "combinations";
+// empty means "items".
+type Console struct {
+	W     io.Writer
+	Items string
+}
+
+func (c Console) Report(s Snapshot) {
+	pct := 100.0
+	if s.Total > 0 {
+		pct = 100 * float64(s.Done) / float64(s.Total)
+	}
+	items := c.Items
+	if items == "" {
+		items = "items"
+	}
+	fmt.Fprintf(c.W, "\r%d of %d %s done (%.1f%%), elapsed %v", s.Done, s.Total, items, pct, s.Elapsed.Round(time.Second))
+	if s.ETA > 0 {
+		fmt.Fprintf(c.W, ", ETA %v", s.ETA.Round(time.Second))
+	}
+	fmt.Fprint(c.W, "\033[K")
+	if s.Done == s.Total {
+		fmt.Fprintln(c.W)
+	}
+}
+
+// JSONLines writes each snapshot to its writer as one JSON object per
+// line. After a write fails it writes nothing more, and Err returns the
+// error.
+type JSONLines struct {
+	enc *json.Encoder
+	err error
+}
+
+func NewJSONLines(w io.Writer) *JSONLines {
+	return &JSONLines{enc: json.NewEncoder(w)}
+}
+
+func (j *JSONLines) Report(s Snapshot) {
+	if j.err == nil {
+		j.err = j.enc.Encode(s)
+	}
+}
+
+// Err returns the error of the first write that failed, or nil.
+func (j *JSONLines) Err() error { return j.err }
+
+// NewReporter returns the Reporter named by kind, writing to w: "none"
+// (Silent), "console" (Console, counting items) or "json" (JSONLines).
+func NewReporter(kind, items string, w io.Writer) (Reporter, error) {
+	switch kind {
+	case "none":
+		return Silent{}, nil
+	case "console":
+		return Console{W: w, Items: items}, nil
+	case "json":
+		return NewJSONLines(w), nil
+	}
+	return nil, fmt.Errorf("unknown progress format %q", kind)
+}
+
+// Meter turns counts of items done into snapshots with an ETA and passes
+// them to a Reporter, at most once per interval apart from the first and
+// the last. Its Update method is not safe for concurrent use.
+type Meter struct {
+	reporter Reporter
+	interval time.Duration
+	start    time.Time
+	initial  int
+	last     time.Time
+	// now reads the clock; tests replace it.
+	now func() time.Time
+}
+
+func NewMeter(r Reporter, interval time.Duration) *Meter {
+	return &Meter{reporter: r, interval: interval, initial: -1, now: time.Now}
+}
+
+// Update records that done of total items are complete.
+func (m *Meter) Update(done, total int) {
+	now := m.now()
+	if m.initial < 0 {
+		m.start, m.initial = now, done
+	} else if done < total && now.Sub(m.last) < m.interval {
+		return
+	}
+	m.last = now
+
+	s := Snapshot{Done: done, Total: total, Elapsed: now.Sub(m.start)}
+	if run := done - m.initial; run > 0 && done < total {
+		s.ETA = time.Duration(float64(s.Elapsed) / float64(run) * float64(total-done))
+	}
+	m.reporter.Report(s)
+}
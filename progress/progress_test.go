@@ -0,0 +1,154 @@
+package progress
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock that only moves when told to.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestMeter(interval time.Duration) (*Meter, *fakeClock, *[]Snapshot) {
+	var got []Snapshot
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	m := NewMeter(Func(func(s Snapshot) { got = append(got, s) }), interval)
+	m.now = clock.now
+	return m, clock, &got
+}
+
+// TestMeterThrottles checks that a Meter reports the first update, then at
+// most one update per interval, and always the last.
+func TestMeterThrottles(t *testing.T) {
+	m, clock, got := newTestMeter(time.Second)
+	m.Update(0, 10)
+	for done := 1; done < 10; done++ {
+		clock.advance(400 * time.Millisecond)
+		m.Update(done, 10)
+	}
+	m.Update(10, 10)
+
+	// Updates come every 0.4s, so those at 1.2s, 2.4s and 3.6s are
+	// reported after the first, and the last always is.
+	var done []int
+	for _, s := range *got {
+		done = append(done, s.Done)
+	}
+	want := []int{0, 3, 6, 9, 10}
+	if len(done) != len(want) {
+		t.Fatalf("reported %v, want %v", done, want)
+	}
+	for i := range want {
+		if done[i] != want[i] {
+			t.Fatalf("reported %v, want %v", done, want)
+		}
+	}
+}
+
+// TestMeterETA checks the elapsed time and the ETA, which is estimated
+// from the rate since the first update and ignores the items already done
+// then.
+func TestMeterETA(t *testing.T) {
+	m, clock, got := newTestMeter(0)
+	m.Update(40, 100) // restored from a checkpoint
+	clock.advance(10 * time.Second)
+	m.Update(60, 100)
+	clock.advance(5 * time.Second)
+	m.Update(100, 100)
+
+	want := []Snapshot{
+		{Done: 40, Total: 100},
+		{Done: 60, Total: 100, Elapsed: 10 * time.Second, ETA: 20 * time.Second},
+		{Done: 100, Total: 100, Elapsed: 15 * time.Second},
+	}
+	if len(*got) != len(want) {
+		t.Fatalf("got %d snapshots, want %d", len(*got), len(want))
+	}
+	for i, s := range *got {
+		if s != want[i] {
+			t.Errorf("snapshot %d = %+v, want %+v", i, s, want[i])
+		}
+	}
+}
+
+func TestConsole(t *testing.T) {
+	var buf bytes.Buffer
+	c := Console{W: &buf, Items: "combinations"}
+	c.Report(Snapshot{Done: 1, Total: 4, Elapsed: 2 * time.Second, ETA: 6 * time.Second})
+	c.Report(Snapshot{Done: 4, Total: 4, Elapsed: 8 * time.Second})
+	want := "\r1 of 4 combinations done (25.0%), elapsed 2s, ETA 6s\033[K" +
+		"\r4 of 4 combinations done (100.0%), elapsed 8s\033[K\n"
+	if buf.String() != want {
+		t.Errorf("Console wrote %q, want %q", buf.String(), want)
+	}
+}
+
+func TestJSONLines(t *testing.T) {
+	var buf bytes.Buffer
+	j := NewJSONLines(&buf)
+	j.Report(Snapshot{Done: 1, Total: 2, Elapsed: time.Second})
+	j.Report(Snapshot{Done: 2, Total: 2})
+	if err := j.Err(); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("wrote %d lines, want 2: %q", len(lines), buf.String())
+	}
+	var s Snapshot
+	if err := json.Unmarshal([]byte(lines[0]), &s); err != nil {
+		t.Fatal(err)
+	}
+	if want := (Snapshot{Done: 1, Total: 2, Elapsed: time.Second}); s != want {
+		t.Errorf("first line decodes to %+v, want %+v", s, want)
+	}
+}
+
+// failingWriter fails every write after the first n.
+type failingWriter struct{ n int }
+
+var errWrite = errors.New("disk full")
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.n == 0 {
+		return 0, errWrite
+	}
+	w.n--
+	return len(p), nil
+}
+
+func TestJSONLinesRecordsWriteError(t *testing.T) {
+	w := &failingWriter{n: 1}
+	j := NewJSONLines(w)
+	j.Report(Snapshot{Done: 1, Total: 3})
+	if err := j.Err(); err != nil {
+		t.Fatalf("first write failed: %v", err)
+	}
+	j.Report(Snapshot{Done: 2, Total: 3})
+	j.Report(Snapshot{Done: 3, Total: 3})
+	if err := j.Err(); !errors.Is(err, errWrite) {
+		t.Errorf("Err() = %v, want %v", err, errWrite)
+	}
+}
+
+func TestNewReporter(t *testing.T) {
+	for kind, want := range map[string]string{"none": "progress.Silent", "console": "progress.Console", "json": "*progress.JSONLines"} {
+		r, err := NewReporter(kind, "items", &bytes.Buffer{})
+		if err != nil {
+			t.Fatalf("%s: %v", kind, err)
+		}
+		if got := fmt.Sprintf("%T", r); got != want {
+			t.Errorf("NewReporter(%q) is a %s, want %s", kind, got, want)
+		}
+	}
+	if _, err := NewReporter("xml", "items", &bytes.Buffer{}); err == nil {
+		t.Error("NewReporter accepted an unknown format")
+	}
+}